@@ -13,6 +13,7 @@ require (
 	github.com/lukeroth/gdal v0.0.0-20211109203239-b571df3ee436
 	github.com/usace/filestore v0.1.5-0.20220416172749-6484811f7b13
 	github.com/usace/goquery v0.0.0-20220307153314-47955c94bf3a
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
 )
 
 require (
@@ -34,7 +35,7 @@ require (
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgproto3/v2 v2.1.1 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20200714003250-2b9c44734f2b // indirect
-	github.com/jackc/pgx/v4 v4.13.0 // indirect
+	github.com/jackc/pgx/v4 v4.13.0
 	github.com/jackc/puddle v1.1.3 // indirect
 	github.com/jmoiron/sqlx v1.3.4 // indirect
 	github.com/mohae/deepcopy v0.0.0-20170929034955-c48cc78d4826 // indirect
@@ -53,5 +54,5 @@ require (
 	golang.org/x/crypto v0.0.0-20220408190544-5352b0902921 // indirect
 	golang.org/x/net v0.0.0-20220407224826-aac1ed45d8e3 // indirect
 	golang.org/x/text v0.3.7 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
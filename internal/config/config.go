@@ -3,7 +3,14 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/elevation"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/global"
 	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
 	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/util"
 	"github.com/dlclark/regexp2"
@@ -15,9 +22,18 @@ import (
 type Config struct {
 	Mode types.Mode
 	PathConfig
+	UploadConfig
 	StoreConfig
 	AccessConfig
+	DatasetConfig
+	AdoptConfig
 	ElevationConfig
+	DemConfig
+	QAConfig
+	RasterConfig
+	EnrichConfig
+	AssessConfig
+	WorkerConfig
 }
 
 type PathConfig struct {
@@ -25,6 +41,12 @@ type PathConfig struct {
 	XlsPath string
 }
 
+// UploadConfig holds params that only affect inventory uploads
+type UploadConfig struct {
+	FieldConflict types.FieldConflict
+	Cluster       bool // physically reorder inventory rows on the spatial index after load
+}
+
 // StoreConfig holds only params required for database connection
 type StoreConfig struct {
 	ConnStr string
@@ -41,12 +63,85 @@ type AccessConfig struct {
 	UserId string
 }
 
-type ElevationConfig struct {
+// AdoptConfig points to an existing inventory table and the metadata
+// (xlsx workbook or yaml) describing it
+type AdoptConfig struct {
+	Table    string // table or schema.table
+	MetaPath string
+}
+
+// DatasetConfig identifies a single dataset by its unique name, version and quality
+type DatasetConfig struct {
 	Dataset string
 	Version string
 	Quality types.Quality
 }
 
+// ElevationConfig selects where elevation is sampled from. An empty DemSource
+// queries the National Map
+type ElevationConfig struct {
+	DemSource      string                   // directory of GeoTIFFs or a GDAL VRT
+	NationalMapURL string                   // base url of the TNM Access API
+	RequestRate    float64                  // TNM product queries per second
+	DemCache       string                   // directory National Map tiles are downloaded to
+	Products       []types.ElevationProduct // National Map products, best first
+	Sampling       types.SamplingMethod
+	Workers        int                        // partitions processed concurrently
+	Partition      types.ElevationPartition   // how empty points are split into partitions
+	Retries        int                        // failed batches retried before the run is aborted
+	Units          types.ElevationUnit        // unit ground elevation is written in
+	Geoid          string                     // grid of offsets in meters from NAVD88 to Datum
+	Datum          string                     // vertical datum ground elevation is written in
+	Coordinates    types.ElevationCoordinates // where points are read from
+	Overwrite      bool                       // resample the points selected by Where instead of the empty ones
+	All            bool                       // lets Overwrite run with an empty Where, resampling every point
+	Where          elevation.Filter
+}
+
+// DemConfig holds params of the dem cache commands, the cache itself is
+// ElevationConfig.DemCache
+type DemConfig struct {
+	Repair  bool      // remove tiles that fail verification
+	MaxSize int64     // bytes the cache is pruned to
+	BBox    []float64 // minX, minY, maxX, maxY to prefetch, nil to prefetch a dataset
+}
+
+// QAConfig holds params of elevation qa, the DEM is sampled as configured by
+// ElevationConfig
+type QAConfig struct {
+	Radius    float64 // meters structures count as neighbors within
+	Threshold float64 // difference flagged for review, in the unit of ground_elev
+}
+
+// RasterConfig selects the local raster band mod raster samples into Column
+type RasterConfig struct {
+	Column   string
+	Raster   string // GeoTIFF, VRT or a directory of them
+	Band     int
+	Sampling types.SamplingMethod
+}
+
+// AssessConfig holds params of elevation assess, the DEM is sampled as
+// configured by ElevationConfig
+type AssessConfig struct {
+	Benchmarks string // csv of surveyed points, see core.readBenchmarks
+	Output     string // csv the difference at each benchmark is written to, optional
+}
+
+// WorkerConfig holds params of elevation worker, every dataset is filled as
+// configured by ElevationConfig
+type WorkerConfig struct {
+	Idle time.Duration // sleep once no dataset has empty points
+}
+
+// EnrichConfig selects the polygon attribute mod enrich writes into Column
+type EnrichConfig struct {
+	Polygons  string // any vector file ogr2ogr reads, e.g. a GeoPackage or shapefile
+	Layer     string // layer of Polygons, empty for the first
+	Attribute string
+	Column    string
+}
+
 func (c *StoreConfig) Rdbmsconfig() dq.RdbmsConfig {
 	return dq.RdbmsConfig{
 		Dbuser:   c.Dbuser,
@@ -63,23 +158,39 @@ func (c *StoreConfig) Rdbmsconfig() dq.RdbmsConfig {
 func NewConfig(c *cli.Context, mode types.Mode) (Config, error) {
 
 	// validate for valid mode
-	if mode != types.Access && mode != types.Prep && mode != types.Upload && mode != types.Elevation {
+	if _, ok := types.ModeReverse[string(mode)]; !ok {
+		var modes []string
+		for m := range types.ModeReverse {
+			modes = append(modes, m)
+		}
+		sort.Strings(modes)
 		return Config{}, errors.New(fmt.Sprintf(
-			"invalid mode, --mode can only be %s, %s, %s, or %s",
-			types.Access,
-			types.Prep,
-			types.Upload,
-			types.Elevation,
+			"invalid mode, --mode can only be one of %s",
+			strings.Join(modes, ", "),
 		))
 	}
 
 	var storeCfg StoreConfig
 	var pathCfg PathConfig
+	var uploadCfg UploadConfig
 	var accessCfg AccessConfig
-	var elevCfg ElevationConfig
+	var datasetCfg DatasetConfig
+	var adoptCfg AdoptConfig
+	var elevationCfg ElevationConfig
+	var demCfg DemConfig
+	var qaCfg QAConfig
+	var rasterCfg RasterConfig
+	var enrichCfg EnrichConfig
+	var assessCfg AssessConfig
+	var workerCfg WorkerConfig
+
+	// dem cache commands only connect to look up the dataset to prefetch
+	demMode := mode == types.DemList || mode == types.DemVerify || mode == types.DemPrune || mode == types.DemPrefetch
+	prefetchDataset := mode == types.DemPrefetch && c.String("dataset") != ""
 
 	// validate sql connection creds
-	if mode == types.Access || mode == types.Upload || mode == types.Elevation {
+	// elevation assess only samples the DEM at benchmarks
+	if mode != types.Prep && mode != types.ElevationAssess && (!demMode || prefetchDataset) {
 		sqlConn := c.String("sqlConn")
 		if sqlConn == "" {
 			return Config{}, errors.New("invalid sql connection string, --sqlConn should not be empty")
@@ -127,6 +238,41 @@ func NewConfig(c *cli.Context, mode types.Mode) (Config, error) {
 		}
 	}
 
+	// validate adopt params
+	if mode == types.Adopt {
+		adoptCfg = AdoptConfig{
+			Table:    c.String("table"),
+			MetaPath: c.Path("metaPath"),
+		}
+		if adoptCfg.Table == "" {
+			return Config{}, errors.New("invalid table, --table should not be empty")
+		}
+		if adoptCfg.MetaPath == "" {
+			return Config{}, errors.New("invalid path to metadata file, --metaPath should not be empty")
+		}
+	}
+
+	// validate upload params
+	if mode == types.Upload || mode == types.Adopt {
+		policy := c.String("fieldConflict")
+		if policy == "" {
+			policy = string(types.Fail)
+		}
+		fieldConflict, ok := types.FieldConflictReverse[policy]
+		if !ok {
+			return Config{}, errors.New(fmt.Sprintf(
+				"invalid field conflict policy, --fieldConflict accepts only %s, %s, or %s",
+				types.Fail,
+				types.Coerce,
+				types.Create,
+			))
+		}
+		uploadCfg = UploadConfig{
+			FieldConflict: fieldConflict,
+			Cluster:       c.Bool("cluster"),
+		}
+	}
+
 	// validate access mod params
 	if mode == types.Access {
 		role := types.Role(c.String("role"))
@@ -153,8 +299,11 @@ func NewConfig(c *cli.Context, mode types.Mode) (Config, error) {
 		}
 	}
 
-	//validate elevation params
-	if mode == types.Elevation {
+	// validate dataset params
+	// elevation qa, assess and worker sample the DEM like mod elevation
+	sampleMode := mode == types.Elevation || mode == types.ElevationQA || mode == types.ElevationAssess || mode == types.ElevationWorker
+
+	if mode == types.Elevation || mode == types.ElevationQA || mode == types.Show || mode == types.Raster || mode == types.Enrich || prefetchDataset {
 		m := map[string]string{}
 		params := []string{"dataset", "version", "quality"}
 		for _, param := range params {
@@ -164,18 +313,383 @@ func NewConfig(c *cli.Context, mode types.Mode) (Config, error) {
 			}
 			m[param] = p
 		}
-		elevCfg = ElevationConfig{
+		datasetCfg = DatasetConfig{
 			Dataset: m["dataset"],
 			Version: m["version"],
 			Quality: types.QualityReverse[m["quality"]],
 		}
 	}
 
+	if sampleMode || demMode {
+		elevationCfg = ElevationConfig{
+			NationalMapURL: c.String("tnmUrl"),
+			DemCache:       c.Path("demCache"),
+		}
+		if elevationCfg.DemCache == "" {
+			elevationCfg.DemCache = global.NATIONAL_MAP_CACHE_BASEPATH
+		}
+		if elevationCfg.NationalMapURL == "" {
+			elevationCfg.NationalMapURL = global.NATIONAL_MAP_URL
+		}
+		u, err := url.Parse(elevationCfg.NationalMapURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Config{}, errors.New(fmt.Sprintf("invalid National Map url, --tnmUrl=%s should look like https://host[:port]", elevationCfg.NationalMapURL))
+		}
+		elevationCfg.RequestRate = c.Float64("tnmRate")
+		if elevationCfg.RequestRate < 0 {
+			return Config{}, errors.New(fmt.Sprintf("invalid --tnmRate=%g, must not be negative", elevationCfg.RequestRate))
+		}
+	}
+	if sampleMode || mode == types.DemPrefetch {
+		products, err := parseProducts(c.String("products"))
+		if err != nil {
+			return Config{}, err
+		}
+		elevationCfg.Products = products
+	}
+
+	if sampleMode {
+		elevationCfg.DemSource = c.Path("demSource")
+		var err error
+		elevationCfg.Sampling, err = parseSampling(c.String("sampling"))
+		if err != nil {
+			return Config{}, err
+		}
+		var ok bool
+		units := c.String("units")
+		if units == "" {
+			units = string(types.Meters)
+		}
+		elevationCfg.Units, ok = types.ElevationUnitReverse[units]
+		if !ok {
+			return Config{}, errors.New(fmt.Sprintf("invalid units, --units accepts only %s or %s", types.Meters, types.Feet))
+		}
+		elevationCfg.Geoid = c.Path("geoid")
+		elevationCfg.Datum = c.String("datum")
+		if elevationCfg.Datum == "" {
+			elevationCfg.Datum = global.NATIONAL_MAP_VERTICAL_DATUM
+		}
+		if elevationCfg.Geoid != "" && elevationCfg.Datum == global.NATIONAL_MAP_VERTICAL_DATUM {
+			return Config{}, errors.New(fmt.Sprintf("--geoid requires --datum to name the datum the grid shifts %s to", global.NATIONAL_MAP_VERTICAL_DATUM))
+		}
+		if elevationCfg.Geoid == "" && elevationCfg.Datum != global.NATIONAL_MAP_VERTICAL_DATUM {
+			return Config{}, errors.New(fmt.Sprintf("--datum=%s requires a --geoid grid shifting %s to it", elevationCfg.Datum, global.NATIONAL_MAP_VERTICAL_DATUM))
+		}
+	}
+
+	// the worker fills every dataset like mod elevation without --overwrite
+	if mode == types.Elevation || mode == types.ElevationWorker {
+		var ok bool
+		elevationCfg.Workers = c.Int("workers")
+		if elevationCfg.Workers < 1 {
+			return Config{}, errors.New(fmt.Sprintf("invalid --workers=%d, at least one worker is required", elevationCfg.Workers))
+		}
+		elevationCfg.Retries = c.Int("retries")
+		if elevationCfg.Retries < 0 {
+			return Config{}, errors.New(fmt.Sprintf("invalid --retries=%d, must not be negative", elevationCfg.Retries))
+		}
+		partition := c.String("partition")
+		if partition == "" {
+			partition = string(types.TilePartition)
+		}
+		elevationCfg.Partition, ok = types.ElevationPartitionReverse[partition]
+		if !ok {
+			return Config{}, errors.New(fmt.Sprintf(
+				"invalid partition, --partition accepts only %s or %s",
+				types.TilePartition,
+				types.RangePartition,
+			))
+		}
+		coords := c.String("coords")
+		if coords == "" {
+			coords = string(types.XYCoordinates)
+		}
+		elevationCfg.Coordinates, ok = types.ElevationCoordinatesReverse[coords]
+		if !ok {
+			return Config{}, errors.New(fmt.Sprintf(
+				"invalid coordinates, --coords accepts only %s or %s",
+				types.XYCoordinates,
+				types.ShapeCoordinates,
+			))
+		}
+		if elevationCfg.Coordinates == types.ShapeCoordinates && elevationCfg.Partition == types.TilePartition {
+			// tile cells are cut on the x, y columns, which may be what is wrong
+			if c.IsSet("partition") {
+				return Config{}, errors.New(fmt.Sprintf("--coords %s requires --partition %s", types.ShapeCoordinates, types.RangePartition))
+			}
+			elevationCfg.Partition = types.RangePartition
+		}
+	}
+
+	if mode == types.Elevation {
+		var err error
+		elevationCfg.Overwrite = c.Bool("overwrite")
+		elevationCfg.All = c.Bool("all")
+		where := c.String("where")
+		if (where != "" || elevationCfg.All) && !elevationCfg.Overwrite {
+			return Config{}, errors.New("--where and --all select the points --overwrite resamples, they require --overwrite")
+		}
+		elevationCfg.Where, err = parseWhere(where)
+		if err != nil {
+			return Config{}, err
+		}
+		// every selected point is emptied before it is resampled
+		if elevationCfg.Overwrite && elevationCfg.Where.Empty() == !elevationCfg.All {
+			return Config{}, errors.New("--overwrite requires exactly one of --where or --all, a failed run leaves the points it selected empty")
+		}
+	}
+
+	if mode == types.ElevationQA {
+		qaCfg = QAConfig{
+			Radius:    c.Float64("radius"),
+			Threshold: c.Float64("threshold"),
+		}
+		if qaCfg.Radius <= 0 {
+			return Config{}, errors.New(fmt.Sprintf("invalid --radius=%g, must be positive", qaCfg.Radius))
+		}
+		if qaCfg.Threshold <= 0 {
+			return Config{}, errors.New(fmt.Sprintf("invalid --threshold=%g, must be positive", qaCfg.Threshold))
+		}
+	}
+
+	if mode == types.Raster {
+		rasterCfg = RasterConfig{
+			Column: c.String("column"),
+			Raster: c.Path("raster"),
+			Band:   c.Int("band"),
+		}
+		if rasterCfg.Column == "" {
+			return Config{}, errors.New("invalid column, --column should not be empty")
+		}
+		if rasterCfg.Raster == "" {
+			return Config{}, errors.New("invalid raster, --raster should not be empty")
+		}
+		if rasterCfg.Band < 1 {
+			return Config{}, errors.New(fmt.Sprintf("invalid --band=%d, bands are numbered from 1", rasterCfg.Band))
+		}
+		var err error
+		rasterCfg.Sampling, err = parseSampling(c.String("sampling"))
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	if mode == types.ElevationAssess {
+		assessCfg = AssessConfig{
+			Benchmarks: c.Path("benchmarks"),
+			Output:     c.Path("output"),
+		}
+		if assessCfg.Benchmarks == "" {
+			return Config{}, errors.New("invalid benchmarks, --benchmarks should not be empty")
+		}
+	}
+
+	if mode == types.ElevationWorker {
+		workerCfg.Idle = c.Duration("idle")
+		if workerCfg.Idle <= 0 {
+			return Config{}, errors.New(fmt.Sprintf("invalid --idle=%s, must be positive", workerCfg.Idle))
+		}
+	}
+
+	if mode == types.Enrich {
+		enrichCfg = EnrichConfig{
+			Polygons:  c.Path("polygons"),
+			Layer:     c.String("layer"),
+			Attribute: c.String("attribute"),
+			Column:    c.String("column"),
+		}
+		if enrichCfg.Polygons == "" {
+			return Config{}, errors.New("invalid polygons, --polygons should not be empty")
+		}
+		if enrichCfg.Attribute == "" {
+			return Config{}, errors.New("invalid attribute, --attribute should not be empty")
+		}
+		if enrichCfg.Column == "" {
+			return Config{}, errors.New("invalid column, --column should not be empty")
+		}
+	}
+
+	if mode == types.DemVerify {
+		demCfg.Repair = c.Bool("repair")
+	}
+	if mode == types.DemPrune {
+		maxSize, err := parseSize(c.String("maxSize"))
+		if err != nil {
+			return Config{}, err
+		}
+		demCfg.MaxSize = maxSize
+	}
+	if mode == types.DemPrefetch {
+		bbox := c.String("bbox")
+		if (bbox == "") == !prefetchDataset {
+			return Config{}, errors.New("dem prefetch requires exactly one of --dataset or --bbox")
+		}
+		if bbox != "" {
+			b, err := parseBBox(bbox)
+			if err != nil {
+				return Config{}, errors.New(fmt.Sprintf("invalid --bbox=%s, %s", bbox, err))
+			}
+			demCfg.BBox = []float64{b.MinX, b.MinY, b.MaxX, b.MaxY}
+		}
+	}
+
 	return Config{
 		Mode:            mode,
 		PathConfig:      pathCfg,
+		UploadConfig:    uploadCfg,
 		StoreConfig:     storeCfg,
 		AccessConfig:    accessCfg,
-		ElevationConfig: elevCfg,
+		DatasetConfig:   datasetCfg,
+		AdoptConfig:     adoptCfg,
+		ElevationConfig: elevationCfg,
+		DemConfig:       demCfg,
+		QAConfig:        qaCfg,
+		RasterConfig:    rasterCfg,
+		EnrichConfig:    enrichCfg,
+		AssessConfig:    assessCfg,
+		WorkerConfig:    workerCfg,
 	}, nil
 }
+
+// parseSize parses a byte count with an optional K, M, G or T suffix (powers of 1024)
+func parseSize(v string) (int64, error) {
+	units := map[string]int64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+	t := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(v)), "B")
+	unit := ""
+	if t != "" && strings.ContainsAny(t[len(t)-1:], "KMGT") {
+		unit = t[len(t)-1:]
+		t = t[:len(t)-1]
+	}
+	n, err := strconv.ParseFloat(t, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New(fmt.Sprintf("invalid size=%s, expected bytes or a number with K, M, G or T suffix, e.g. 20G", v))
+	}
+	return int64(n * float64(units[unit])), nil
+}
+
+// parseSampling defaults to nearest, the only method that keeps categories
+func parseSampling(s string) (types.SamplingMethod, error) {
+	if s == "" {
+		s = string(types.Nearest)
+	}
+	m, ok := types.SamplingMethodReverse[s]
+	if !ok {
+		return "", errors.New(fmt.Sprintf(
+			"invalid sampling method, --sampling accepts only %s, %s, or %s",
+			types.Nearest,
+			types.Bilinear,
+			types.Bicubic,
+		))
+	}
+	return m, nil
+}
+
+// parseProducts splits a comma separated list of National Map products, best first
+func parseProducts(s string) ([]types.ElevationProduct, error) {
+	if s == "" {
+		s = global.NATIONAL_MAP_PRODUCTS
+	}
+	var products []types.ElevationProduct
+	seen := map[types.ElevationProduct]bool{}
+	for _, v := range strings.Split(s, ",") {
+		p, ok := types.ElevationProductReverse[strings.TrimSpace(v)]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf(
+				"invalid product=%s, --products accepts a list of %s, %s, %s or %s",
+				v,
+				types.OneMeter,
+				types.NinthArcSecond,
+				types.ThirdArcSecond,
+				types.OneArcSecond,
+			))
+		}
+		if seen[p] {
+			return nil, errors.New(fmt.Sprintf("product=%s is listed more than once in --products", p))
+		}
+		seen[p] = true
+		products = append(products, p)
+	}
+	return products, nil
+}
+
+// parseBBox parses minX,minY,maxX,maxY
+func parseBBox(s string) (elevation.BoundingBox, error) {
+	var v []float64
+	for _, t := range strings.Split(s, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		if err != nil {
+			return elevation.BoundingBox{}, errors.New("expected minX,minY,maxX,maxY")
+		}
+		v = append(v, f)
+	}
+	if len(v) != 4 || v[0] >= v[2] || v[1] >= v[3] {
+		return elevation.BoundingBox{}, errors.New("expected minX,minY,maxX,maxY")
+	}
+	return elevation.BoundingBox{MinX: v[0], MinY: v[1], MaxX: v[2], MaxY: v[3]}, nil
+}
+
+// parseWhere parses the --where filter of an overwrite run, a ; separated list
+// of county=, state=, bbox=, reason= and product= conditions, each taking a
+// comma separated list of values (bbox takes minX,minY,maxX,maxY)
+func parseWhere(s string) (elevation.Filter, error) {
+	var f elevation.Filter
+	for _, cond := range strings.Split(s, ";") {
+		cond = strings.TrimSpace(cond)
+		if cond == "" {
+			continue
+		}
+		kv := strings.SplitN(cond, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[1]) == "" {
+			return elevation.Filter{}, errors.New(fmt.Sprintf("invalid --where condition=%s, expected key=value", cond))
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			values = append(values, strings.TrimSpace(v))
+		}
+		switch key {
+		case "county", "state":
+			n := 5
+			if key == "state" {
+				n = 2
+			}
+			for _, v := range values {
+				if _, err := strconv.Atoi(v); err != nil || len(v) != n {
+					return elevation.Filter{}, errors.New(fmt.Sprintf("invalid --where %s=%s, expected %d digit fips codes", key, v, n))
+				}
+			}
+			if key == "county" {
+				f.Counties = append(f.Counties, values...)
+			} else {
+				f.States = append(f.States, values...)
+			}
+		case "bbox":
+			b, err := parseBBox(value)
+			if err != nil {
+				return elevation.Filter{}, errors.New(fmt.Sprintf("invalid --where bbox=%s, %s", value, err))
+			}
+			f.BBox = &b
+		case "reason":
+			for _, v := range values {
+				r, ok := types.ElevationReasonReverse[v]
+				if !ok {
+					return elevation.Filter{}, errors.New(fmt.Sprintf("invalid --where reason=%s", v))
+				}
+				f.Reasons = append(f.Reasons, r)
+			}
+		case "product":
+			for _, v := range values {
+				p, ok := types.ElevationProductReverse[v]
+				if !ok {
+					return elevation.Filter{}, errors.New(fmt.Sprintf("invalid --where product=%s", v))
+				}
+				f.Products = append(f.Products, p)
+			}
+		default:
+			return elevation.Filter{}, errors.New(fmt.Sprintf(
+				"invalid --where key=%s, expected county, state, bbox, reason or product", key,
+			))
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,225 @@
+package core
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/config"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/elevation"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/global"
+)
+
+// benchmark is a surveyed point, the Point holds where it is sampled
+type benchmark struct {
+	Id        string
+	Region    string
+	Elevation float64 // surveyed, in the unit and datum ground_elev is written in
+	Point     *elevation.Point
+}
+
+// AssessElevation samples the elevation source mod elevation would use, with
+// the same products, sampling, units and datum, at surveyed benchmarks and
+// prints the RMSE, bias and percentiles of the differences by product and by
+// region. Benchmarks the source holds no value at are counted but not scored
+func AssessElevation(cfg config.Config) error {
+	benchmarks, err := readBenchmarks(cfg.AssessConfig.Benchmarks)
+	if err != nil {
+		return err
+	}
+	src, err := elevationSource(cfg)
+	if err != nil {
+		return err
+	}
+	for lo := 0; lo < len(benchmarks); lo += global.ELEVATION_BATCHSIZE {
+		hi := lo + global.ELEVATION_BATCHSIZE
+		if hi > len(benchmarks) {
+			hi = len(benchmarks)
+		}
+		var points elevation.Points
+		for _, b := range benchmarks[lo:hi] {
+			points = append(points, b.Point)
+		}
+		err = src.GetElevation(points)
+		if err != nil {
+			return err
+		}
+	}
+
+	byProduct := map[string][]benchmark{}
+	byRegion := map[string][]benchmark{}
+	for _, b := range benchmarks {
+		byProduct[benchmarkProduct(b)] = append(byProduct[benchmarkProduct(b)], b)
+		byRegion[b.Region] = append(byRegion[b.Region], b)
+	}
+	fmt.Printf("benchmarks:   %d from %s\n", len(benchmarks), cfg.AssessConfig.Benchmarks)
+	fmt.Printf("units:        %s %s\n", cfg.ElevationConfig.Units, cfg.ElevationConfig.Datum)
+	printAccuracy("all", map[string][]benchmark{"all": benchmarks})
+	printAccuracy("by product", byProduct)
+	printAccuracy("by region", byRegion)
+
+	if cfg.AssessConfig.Output != "" {
+		err = writeAssessment(cfg.AssessConfig.Output, benchmarks)
+		if err != nil {
+			return err
+		}
+		log.Printf("Wrote the difference at each benchmark to %s", cfg.AssessConfig.Output)
+	}
+	return nil
+}
+
+// readBenchmarks reads a csv with a header naming at least x, y (lon/lat) and
+// elevation columns, and optionally id and region. Benchmarks without a region
+// are grouped by the 1x1 degree National Map tile they fall in, e.g. n20w156
+func readBenchmarks(path string) ([]benchmark, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read the header of benchmarks=%s: %s", path, err))
+	}
+	idx := map[string]int{}
+	for i, h := range header {
+		idx[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, c := range []string{"x", "y", "elevation"} {
+		if _, ok := idx[c]; !ok {
+			return nil, errors.New(fmt.Sprintf("benchmarks=%s has no %s column, the header needs x, y and elevation", path, c))
+		}
+	}
+	var benchmarks []benchmark
+	for line := 2; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var v [3]float64
+		for i, c := range []string{"x", "y", "elevation"} {
+			v[i], err = strconv.ParseFloat(strings.TrimSpace(rec[idx[c]]), 64)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("invalid %s=%s on line %d of benchmarks=%s", c, rec[idx[c]], line, path))
+			}
+		}
+		if v[0] < -180 || v[0] > 180 || v[1] < -90 || v[1] > 90 {
+			return nil, errors.New(fmt.Sprintf("invalid x=%g y=%g on line %d of benchmarks=%s, use lon/lat", v[0], v[1], line, path))
+		}
+		b := benchmark{
+			Id:        strconv.Itoa(line - 1),
+			Elevation: v[2],
+			Point:     &elevation.Point{FdId: line - 1, X: v[0], Y: v[1]},
+		}
+		if i, ok := idx["id"]; ok {
+			b.Id = rec[i]
+		}
+		if i, ok := idx["region"]; ok {
+			b.Region = strings.TrimSpace(rec[i])
+		}
+		if b.Region == "" {
+			b.Region = degreeTile(v[0], v[1])
+		}
+		benchmarks = append(benchmarks, b)
+	}
+	if len(benchmarks) == 0 {
+		return nil, errors.New(fmt.Sprintf("benchmarks=%s holds no benchmarks", path))
+	}
+	return benchmarks, nil
+}
+
+// degreeTile names the 1x1 degree tile of x, y by its north west corner
+func degreeTile(x float64, y float64) string {
+	ns, ew := "n", "w"
+	lat, lon := math.Ceil(y), math.Floor(x)
+	if lat < 0 {
+		ns = "s"
+	}
+	if lon >= 0 {
+		ew = "e"
+	}
+	return fmt.Sprintf("%s%02.0f%s%03.0f", ns, math.Abs(lat), ew, math.Abs(lon))
+}
+
+// benchmarkProduct is the National Map product a benchmark was sampled from,
+// local for rasters of --demSource and none if it wasn't sampled
+func benchmarkProduct(b benchmark) string {
+	switch {
+	case b.Point.NilElevation():
+		return "none"
+	case b.Point.Product == "":
+		return "local"
+	}
+	return string(b.Point.Product)
+}
+
+func printAccuracy(title string, groups map[string][]benchmark) {
+	fmt.Printf("%s:\n", title)
+	fmt.Printf("    %-12s %8s %8s %8s %8s %8s %8s %8s\n", "", "count", "sampled", "rmse", "bias", "p50", "p90", "p95")
+	var keys []string
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var diffs []float64
+		for _, b := range groups[k] {
+			if !b.Point.NilElevation() {
+				diffs = append(diffs, *b.Point.Elevation-b.Elevation)
+			}
+		}
+		a := elevation.NewAccuracy(len(groups[k]), diffs)
+		fmt.Printf("    %-12s %8d %8d %8.3f %8.3f %8.3f %8.3f %8.3f\n", k, a.Count, a.Sampled, a.RMSE, a.Bias, a.P50, a.P90, a.P95)
+	}
+}
+
+// writeAssessment writes a row per benchmark with its sample and difference
+func writeAssessment(path string, benchmarks []benchmark) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	err = w.Write([]string{"id", "x", "y", "region", "elevation", "sampled", "difference", "product", "source", "reason"})
+	if err != nil {
+		return err
+	}
+	for _, b := range benchmarks {
+		p := b.Point
+		var sampled, diff string
+		if !p.NilElevation() {
+			sampled = strconv.FormatFloat(*p.Elevation, 'f', -1, 64)
+			diff = strconv.FormatFloat(*p.Elevation-b.Elevation, 'f', -1, 64)
+		}
+		err = w.Write([]string{
+			b.Id,
+			strconv.FormatFloat(p.X, 'f', -1, 64),
+			strconv.FormatFloat(p.Y, 'f', -1, 64),
+			b.Region,
+			strconv.FormatFloat(b.Elevation, 'f', -1, 64),
+			sampled,
+			diff,
+			benchmarkProduct(b),
+			p.Tile,
+			string(p.Reason),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
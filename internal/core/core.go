@@ -1,15 +1,16 @@
 package core
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/config"
 	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/elevation"
@@ -36,17 +37,58 @@ func Core(c *cli.Context, m types.Mode) error {
 		log.Fatal(err)
 	}
 
+	var st store.Store
+	if cfg.ConnStr != "" {
+		st, err = store.NewStore(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if cfg.Mode == types.Prep {
 		err = Prep(cfg)
 	}
 	if cfg.Mode == types.Upload {
-		err = Upload(cfg)
+		err = Upload(cfg, st)
 	}
 	if cfg.Mode == types.Access {
-		err = ChangeAccess(cfg)
+		err = ChangeAccess(cfg, st)
 	}
 	if cfg.Mode == types.Elevation {
-		err = AddElevation(cfg)
+		err = AddElevation(cfg, st)
+	}
+	if cfg.Mode == types.Show {
+		err = ShowDataset(cfg, st)
+	}
+	if cfg.Mode == types.Adopt {
+		err = Adopt(cfg, st)
+	}
+	if cfg.Mode == types.DemList {
+		err = DemList(cfg)
+	}
+	if cfg.Mode == types.DemVerify {
+		err = DemVerify(cfg)
+	}
+	if cfg.Mode == types.DemPrune {
+		err = DemPrune(cfg)
+	}
+	if cfg.Mode == types.DemPrefetch {
+		err = DemPrefetch(cfg, st)
+	}
+	if cfg.Mode == types.ElevationQA {
+		err = ElevationQA(cfg, st)
+	}
+	if cfg.Mode == types.Raster {
+		err = SampleRaster(cfg, st)
+	}
+	if cfg.Mode == types.Enrich {
+		err = EnrichInventory(cfg, st)
+	}
+	if cfg.Mode == types.ElevationAssess {
+		err = AssessElevation(cfg)
+	}
+	if cfg.Mode == types.ElevationWorker {
+		err = ElevationWorker(cfg, st, stopOnSignal())
 	}
 	if err != nil {
 		log.Fatal(err)
@@ -98,11 +140,7 @@ func Prep(cfg config.Config) error {
 }
 
 //// Upload populates metadata from the config xls and upload data from shp file
-func Upload(cfg config.Config) error {
-	st, err := store.NewStore(cfg)
-	if err != nil {
-		return err
-	}
+func Upload(cfg config.Config, st store.Store) error {
 	metaAccessor, err := ingest.NewMetaAccessor(cfg)
 	if err != nil {
 		return err
@@ -152,12 +190,30 @@ func Upload(cfg config.Config) error {
 	if err != nil {
 		return err
 	}
+	// shp field name -> OGR SQL type for columns coerced into an existing registry type
+	casts := map[string]string{}
 	for _, f := range fields {
 		// log.Printf("Retrieving id for unique field=%s type=%s\n", f.Name, f.Type)
 		err = st.GetFieldId(&f)
 		if err != nil {
 			return err
 		}
+		// no match on (name, type) -> check if the name is registered under another type
+		if f.Id == uuid.Nil {
+			existing, err := st.GetFieldsByName(f)
+			if err != nil {
+				return err
+			}
+			if len(existing) > 0 {
+				coerced, err := resolveFieldConflict(cfg.UploadConfig.FieldConflict, &f, existing)
+				if err != nil {
+					return err
+				}
+				if coerced {
+					casts[f.ShpName] = types.DatatypeOgrCast[f.Type]
+				}
+			}
+		}
 		// If no id -> field is not in db -> add field + add association to schema + domain
 		if f.Id == uuid.Nil {
 			// log.Printf("field=%s type=%s do not exists. Adding to field table...\n", f.Name, f.Type)
@@ -250,11 +306,8 @@ func Upload(cfg config.Config) error {
 	if err != nil {
 		return err
 	}
-	sqlArg := shape.GenerateSqlArg(shp2DbName, strings.TrimSuffix(
-		filepath.Base(cfg.ShpPath),
-		filepath.Ext(cfg.ShpPath),
-	))
-	var execStr string
+	var appendRows bool
+	var sinceFdId int // rows loaded by this run have fd_id > sinceFdId
 	if d.Id == uuid.Nil {
 		// creating new dataset
 		d.TableName = "inventory_" + strings.ReplaceAll(uuid.New().String(), "-", "_")
@@ -264,47 +317,39 @@ func Upload(cfg config.Config) error {
 		}
 		// create new table
 		log.Printf("Creating table=%s for dataset=%s", d.TableName, d.Name)
-		execStr = fmt.Sprintf(`ogr2ogr -f "PostgreSQL" PG:"%s" %s -lco precision=no -lco fid=fd_id -lco geometry_name=shape -nln %s.%s %s`,
-			strings.ReplaceAll(cfg.StoreConfig.ConnStr, "database=", "dbname="),
-			cfg.ShpPath, store.DbSchema, d.TableName, sqlArg,
-		)
 	} else {
 		// dataset already exists
 		flagDataInStore, err := st.ShpDataInStore(d, metaAccessor.S)
 		if err != nil {
 			return err
 		}
-		if !flagDataInStore { // data has not yet been added to store
-			log.Printf("table=%s exists for dataset=%s. Appending rows...", d.TableName, d.Name)
-			execStr = fmt.Sprintf(`ogr2ogr -append -update -f "PostgreSQL" PG:"%s" %s -lco precision=no -lco fid=fd_id -lco geometry_name=shape -nln %s.%s %s`,
-				strings.ReplaceAll(cfg.StoreConfig.ConnStr, "database=", "dbname="),
-				cfg.ShpPath, store.DbSchema, d.TableName, sqlArg,
-			)
-		} else {
+		if flagDataInStore {
 			return errors.New("Upload failed - shp file has already been uploaded")
 		}
+		// data has not yet been added to store
+		log.Printf("table=%s exists for dataset=%s. Appending rows...", d.TableName, d.Name)
+		appendRows = true
+		sinceFdId, err = st.GetMaxFdId(d)
+		if err != nil {
+			return err
+		}
 	}
-	// log.Print(execStr)
-	cmd := exec.Command(
-		"sh", "-c", execStr,
-	)
-	// setting up pipeline
-	stdout, err := cmd.StdoutPipe()
+	err = st.LoadShp(d, cfg.ShpPath, shp2DbName, casts, appendRows)
 	if err != nil {
 		return err
 	}
-	if err := cmd.Start(); err != nil {
+	err = updateDatasetStats(st, d, sinceFdId)
+	if err != nil {
 		return err
 	}
-	// read command's stdout line by line
-	in := bufio.NewScanner(stdout)
-	for in.Scan() {
-		log.Printf(in.Text()) // write each line to your log, or anything you need
-	}
-	if err := in.Err(); err != nil {
-		log.Printf("ogr2ogr error: %s", err)
+	var indexColumns []string
+	for _, f := range fields {
+		if f.IsIndexed {
+			indexColumns = append(indexColumns, f.DbName)
+		}
 	}
-	err = st.UpdateDatasetBBox(d)
+	log.Printf("Optimizing table=%s, indexing columns=%s", d.TableName, strings.Join(indexColumns, ", "))
+	err = st.OptimizeInventory(d, indexColumns, cfg.UploadConfig.Cluster)
 	if err != nil {
 		return err
 	}
@@ -312,17 +357,291 @@ func Upload(cfg config.Config) error {
 	return err
 }
 
-func ChangeAccess(cfg config.Config) error {
-	st, err := store.NewStore(cfg)
+// resolveFieldConflict applies the field conflict policy to a shp field whose
+// name is already registered under a different type. Returns true if the field
+// has been coerced into the type of an existing registry field
+func resolveFieldConflict(policy types.FieldConflict, f *model.Field, existing []model.Field) (bool, error) {
+	var registered []string
+	for _, e := range existing {
+		registered = append(registered, string(e.Type))
+	}
+	conflict := fmt.Sprintf(
+		"field.name=%s arrives as type=%s but is registered as type=%s",
+		f.DbName, f.Type, strings.Join(registered, ", "),
+	)
+	switch policy {
+	case types.Coerce:
+		if len(existing) > 1 {
+			return false, errors.New(fmt.Sprintf("Upload failed - %s, unable to pick a type to coerce into", conflict))
+		}
+		log.Printf("Field type conflict - %s. Coercing to type=%s", conflict, existing[0].Type)
+		f.Id = existing[0].Id
+		f.Type = existing[0].Type
+		return true, nil
+	case types.Create:
+		log.Printf("Field type conflict - %s. Registering new field type=%s", conflict, f.Type)
+		return false, nil
+	default:
+		return false, errors.New(fmt.Sprintf(
+			"Upload failed - field type conflict - %s. Rerun with --fieldConflict %s or %s",
+			conflict, types.Coerce, types.Create,
+		))
+	}
+}
+
+// tableNamePattern limits adopted table names to plain lowercase identifiers,
+// they are interpolated into the inventory statements
+var tableNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// Adopt registers an inventory table that already exists in the inventory
+// schema as a dataset, without copying any rows. Columns are checked against
+// the metadata and registered like an upload would. fd_id and shape are added
+// if the table lacks them
+func Adopt(cfg config.Config, st store.Store) error {
+	meta, err := ingest.NewTableMeta(cfg.AdoptConfig.MetaPath)
+	if err != nil {
+		return err
+	}
+	table := cfg.AdoptConfig.Table
+	if i := strings.Index(table, "."); i >= 0 {
+		if table[:i] != global.DB_SCHEMA {
+			return errors.New(fmt.Sprintf(
+				"Adopt failed - table=%s must be in schema=%s, move it with: alter table %s set schema %s",
+				table, global.DB_SCHEMA, table, global.DB_SCHEMA,
+			))
+		}
+		table = table[i+1:]
+	}
+	if !tableNamePattern.MatchString(table) {
+		return errors.New(fmt.Sprintf("Adopt failed - invalid table name=%s, expected lowercase letters, digits, and underscores", table))
+	}
+	if cfg.UploadConfig.FieldConflict == types.Coerce {
+		return errors.New(fmt.Sprintf(
+			"Adopt failed - --fieldConflict %s is not supported, adopted columns are never rewritten",
+			types.Coerce,
+		))
+	}
+	tableExists, err := st.TableExists(table)
+	if err != nil {
+		return err
+	}
+	if !tableExists {
+		return errors.New(fmt.Sprintf("Adopt failed - table=%s.%s does not exist", global.DB_SCHEMA, table))
+	}
+	d := model.Dataset{TableName: table}
+
+	/////////////////////////////////////////////////
+	//  COLUMNS
+	columns, err := st.GetInventoryColumns(d)
 	if err != nil {
 		return err
 	}
+	for _, c := range []string{"x", "y"} {
+		t, ok := types.DatatypePostgres[columns[c]]
+		if !ok || (t != types.Float && t != types.Number) {
+			return errors.New(fmt.Sprintf("Adopt failed - table=%s requires numeric column=%s", table, c))
+		}
+	}
+	fields, err := meta.GetFields(columns)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Adopt failed - %s", err))
+	}
+	var undescribed []string
+	for c := range columns {
+		described := false
+		for _, f := range fields {
+			described = described || f.DbName == c
+		}
+		if !described && c != "fd_id" && c != "shape" {
+			undescribed = append(undescribed, c)
+		}
+	}
+	if len(undescribed) > 0 {
+		sort.Strings(undescribed)
+		log.Printf("table=%s columns=%s are not described by the metadata and will not be registered", table, strings.Join(undescribed, ", "))
+	}
 
+	/////////////////////////////////////////////////
+	//  SCHEMA
+	s := meta.GetSchema()
+	err = st.GetSchemaId(&s)
+	if err != nil {
+		return err
+	}
+	if s.Id == uuid.Nil {
+		err = st.AddSchema(&s)
+		if err != nil {
+			return err
+		}
+	}
+
+	/////////////////////////////////////////////////
+	//  FIELD / DOMAIN / SCHEMA_FIELD_ASSOCIATION
+	for _, f := range fields {
+		err = st.GetFieldId(&f)
+		if err != nil {
+			return err
+		}
+		if f.Id == uuid.Nil {
+			existing, err := st.GetFieldsByName(f)
+			if err != nil {
+				return err
+			}
+			if len(existing) > 0 {
+				_, err = resolveFieldConflict(cfg.UploadConfig.FieldConflict, &f, existing)
+				if err != nil {
+					return err
+				}
+			}
+			err = st.AddField(&f)
+			if err != nil {
+				return err
+			}
+			// domain values are taken from the data since there is no shp to scan
+			if f.IsDomain {
+				values, err := st.GetDistinctValues(d, f.DbName)
+				if err != nil {
+					return err
+				}
+				for _, v := range values {
+					err = st.AddDomain(&model.Domain{
+						FieldId: f.Id,
+						Value:   v,
+					})
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+		sf := meta.GetSchemaFieldAssociation(s, f)
+		flagAssociation, err := st.SchemaFieldAssociationExists(sf)
+		if err != nil {
+			return err
+		}
+		if !flagAssociation {
+			err = st.AddSchemaFieldAssociation(sf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	/////////////////////////////////////////////////
+	//  QUALITY / GROUP
+	q := meta.GetQuality()
+	err = st.GetQuality(&q)
+	if err != nil {
+		return err
+	}
+	g := meta.GetGroup()
+	err = st.GetGroupId(&g)
+	if err != nil {
+		return err
+	}
+	if g.Id == uuid.Nil {
+		err = st.AddGroup(&g)
+		if err != nil {
+			return err
+		}
+	}
+
+	/////////////////////////////////////////////////
+	//  DATASET
+	d = meta.GetDataset(table, s, g, q)
+	existing := d
+	err = st.GetDataset(&existing)
+	if err != nil {
+		return err
+	}
+	if existing.Id != uuid.Nil {
+		return errors.New(fmt.Sprintf(
+			"Adopt failed - dataset=%s version=%s quality=%s already exists with table=%s",
+			d.Name, d.Version, q.Value, existing.TableName,
+		))
+	}
+	if _, ok := columns["fd_id"]; !ok {
+		log.Printf("Adding fd_id column to table=%s", table)
+		err = st.AddFdIdColumn(d)
+		if err != nil {
+			return err
+		}
+	}
+	if _, ok := columns["shape"]; !ok {
+		log.Printf("Adding shape column to table=%s from x, y", table)
+		err = st.AddShapeColumn(d)
+		if err != nil {
+			return err
+		}
+	}
+	err = st.AddDataset(&d)
+	if err != nil {
+		return err
+	}
+	err = updateDatasetStats(st, d, 0)
+	if err != nil {
+		return err
+	}
+	var indexColumns []string
+	for _, f := range fields {
+		if f.IsIndexed {
+			indexColumns = append(indexColumns, f.DbName)
+		}
+	}
+	log.Printf("Optimizing table=%s, indexing columns=%s", d.TableName, strings.Join(indexColumns, ", "))
+	err = st.OptimizeInventory(d, indexColumns, cfg.UploadConfig.Cluster)
+	if err != nil {
+		return err
+	}
+	log.Printf("table=%s adopted as dataset.name=%s", d.TableName, d.Name)
+	return nil
+}
+
+// updateDatasetStats merges the summary of rows loaded after sinceFdId into
+// the statistics record of the dataset
+func updateDatasetStats(st store.Store, d model.Dataset, sinceFdId int) error {
+	stats, err := st.GetDatasetStats(d)
+	if err != nil {
+		return err
+	}
+	delta, err := st.GetInventoryStats(d, sinceFdId)
+	if err != nil {
+		return err
+	}
+	if delta.RowCount > 0 {
+		if stats.RowCount == 0 {
+			stats.MinX, stats.MinY, stats.MaxX, stats.MaxY = delta.MinX, delta.MinY, delta.MaxX, delta.MaxY
+		} else {
+			stats.MinX = math.Min(stats.MinX, delta.MinX)
+			stats.MinY = math.Min(stats.MinY, delta.MinY)
+			stats.MaxX = math.Max(stats.MaxX, delta.MaxX)
+			stats.MaxY = math.Max(stats.MaxY, delta.MaxY)
+		}
+		stats.Srid = delta.Srid
+	}
+	stats.RowCount += delta.RowCount
+	for _, m := range []struct {
+		dest  map[string]int64
+		delta map[string]int64
+	}{
+		{stats.StateCounts, delta.StateCounts},
+		{stats.CountyCounts, delta.CountyCounts},
+		{stats.NullCounts, delta.NullCounts},
+	} {
+		for k, v := range m.delta {
+			m.dest[k] += v
+		}
+	}
+	stats.LastLoad = time.Now()
+	return st.SaveDatasetStats(stats)
+}
+
+func ChangeAccess(cfg config.Config, st store.Store) error {
 	// group
 	g := model.Group{
 		Name: cfg.AccessConfig.Group,
 	}
-	err = st.GetGroupId(&g)
+	err := st.GetGroupId(&g)
 	if err != nil {
 		return err
 	}
@@ -358,30 +677,113 @@ func ChangeAccess(cfg config.Config) error {
 	return err
 }
 
-func AddElevation(cfg config.Config) error {
-	st, err := store.NewStore(cfg)
-	if err != nil {
-		return err
-	}
+// getDataset looks up the dataset identified by DatasetConfig, failing if it does not exist
+func getDataset(cfg config.Config, st store.Store) (model.Dataset, error) {
 	q := model.Quality{
-		Value: cfg.ElevationConfig.Quality,
+		Value: cfg.DatasetConfig.Quality,
 	}
-	err = st.GetQualityId(&q)
+	err := st.GetQualityId(&q)
 	if err != nil {
-		return err
+		return model.Dataset{}, err
 	}
 	d := model.Dataset{
-		Name:      cfg.ElevationConfig.Dataset,
-		Version:   cfg.ElevationConfig.Version,
+		Name:      cfg.DatasetConfig.Dataset,
+		Version:   cfg.DatasetConfig.Version,
 		QualityId: q.Id,
 	}
 	err = st.GetDataset(&d)
 	if err != nil {
-		return err
+		return model.Dataset{}, err
 	}
 	if d.TableName == "" {
-		return errors.New(fmt.Sprintf("Unable to find dataset=%s version=%s quality=%s", d.Name, d.Version, q.Value))
+		return model.Dataset{}, errors.New(fmt.Sprintf("Unable to find dataset=%s version=%s quality=%s", d.Name, d.Version, q.Value))
+	}
+	return d, nil
+}
+
+// ShowDataset prints the catalog entry and statistics record of a dataset
+func ShowDataset(cfg config.Config, st store.Store) error {
+	d, err := getDataset(cfg, st)
+	if err != nil {
+		return err
+	}
+	s, err := st.GetDatasetStats(d)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("dataset:      %s\n", d.Name)
+	fmt.Printf("version:      %s\n", d.Version)
+	fmt.Printf("quality:      %s\n", cfg.DatasetConfig.Quality)
+	fmt.Printf("table:        %s.%s\n", store.DbSchema, d.TableName)
+	fmt.Printf("description:  %s\n", d.Description)
+	fmt.Printf("created:      %s by %s\n", d.DateCreated.Format("2006-01-02"), d.CreatedBy)
+	if s.LastLoad.IsZero() {
+		fmt.Println("statistics:   not yet recorded")
+		return nil
+	}
+	fmt.Printf("last load:    %s\n", s.LastLoad.Format(time.RFC3339))
+	fmt.Printf("rows:         %d\n", s.RowCount)
+	fmt.Printf("extent:       %f %f, %f %f (srid=%d)\n", s.MinX, s.MinY, s.MaxX, s.MaxY, s.Srid)
+	printCounts("states", s.StateCounts)
+	printCounts("counties", s.CountyCounts)
+	printCounts("null values", s.NullCounts)
+	runs, err := st.GetElevationRuns(d)
+	if err != nil {
+		return err
+	}
+	fmt.Println("elevation runs:")
+	for _, r := range runs {
+		ended := "unfinished"
+		if r.Ended != nil {
+			ended = r.Ended.Format(time.RFC3339)
+		}
+		fmt.Printf("    %s - %s  %s (%s, %s)  filled=%d failed=%d mismatched=%d\n",
+			r.Started.Format(time.RFC3339), ended, r.Product, r.Source, r.Sampling, r.Filled, r.Failed, r.Mismatched)
+		if r.Error != nil {
+			fmt.Printf("        error: %s\n", *r.Error)
+		}
+	}
+	reviews, err := st.GetElevationReviews(d)
+	if err != nil {
+		return err
+	}
+	checks := map[string]int64{}
+	for _, r := range reviews {
+		checks[string(r.Check)]++
+	}
+	printCounts("elevation review", checks)
+	return nil
+}
+
+// printCounts prints a count map sorted by key
+func printCounts(title string, m map[string]int64) {
+	fmt.Printf("%s:\n", title)
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("    %-12s %d\n", k, m[k])
+	}
+}
+
+func AddElevation(cfg config.Config, st store.Store) error {
+	d, err := getDataset(cfg, st)
+	if err != nil {
+		return err
 	}
+	src, err := elevationSource(cfg)
+	if err != nil {
+		return err
+	}
+	return fillElevation(cfg, st, src, d, nil)
+}
+
+// fillElevation samples src at the empty elevation points of d, or at the
+// points --where selects with --overwrite, and records the run. Closing stop
+// ends the run after the batches in progress
+func fillElevation(cfg config.Config, st store.Store, src elevation.Source, d model.Dataset, stop <-chan struct{}) error {
 	elevColumnExists, err := st.ElevationColumnExists(d)
 	if err != nil {
 		return err
@@ -392,66 +794,223 @@ func AddElevation(cfg config.Config) error {
 			return err
 		}
 	}
+	err = registerElevationField(cfg, st, d)
+	if err != nil {
+		return err
+	}
+	if cfg.ElevationConfig.Overwrite && cfg.ElevationConfig.Where.Empty() && !cfg.ElevationConfig.All {
+		return errors.New(fmt.Sprintf("overwrite of dataset=%s selects every point, it requires --all", d.Name))
+	}
 
-	// spin off goroutines to update
-	var wg sync.WaitGroup
-	for {
-		// check if there are still null vals in ground_elev
-		points, err := st.GetEmptyElevationPoints(d, 1, 0)
+	if cfg.ElevationConfig.Coordinates != types.ShapeCoordinates {
+		// the x, y partitions can't hold them, sampling from the shape can
+		count, err := st.MarkNullCoordinates(d)
 		if err != nil {
 			return err
 		}
-		if len(points) == 0 {
-			log.Print("Elevation data is completely populated for dataset=", d.Name)
-			break
-		}
-		for i := 0; i < global.ELEVATION_NO_PARALLEL_ROUTINES; i++ {
-			wg.Add(1)
-			go func(i int) {
-				// defer func() {
-				// 	log.Print("thread ", i, " finished")
-				// 	wg.Done()
-				// }()
-				err := addElevationToInventory(
-					st,
-					global.ELEVATION_BATCHSIZE,
-					i*global.ELEVATION_BATCHSIZE,
-					d,
-				)
-				if err != nil {
-					// there's no write conflict in addElevationToInventory, just a single writer,
-					// function fails on invalid read while another routine is writing to file
-					// this is chaosmonkey compliant, just start another process until done
-					log.Print(err)
-				}
-				log.Print("thread ", i, " finished")
-				wg.Done()
-			}(i)
+		if count > 0 {
+			log.Printf("Marked %d points of dataset=%s with a null x or y as %s", count, d.Name, types.NoGeometry)
 		}
-		wg.Wait()
-		// if err := <-errs; err != nil {
-		// 	return err
-		// }
 	}
-	return nil
-}
 
-func addElevationToInventory(s *store.PSStore, batchSize int, offset int, d model.Dataset) error {
-	points, err := s.GetEmptyElevationPoints(d, batchSize, offset)
+	// a long lived worker queries the National Map again for each run
+	elevation.ResetQueryCache()
+	sched := newElevationScheduler(st, src, d, cfg.ElevationConfig.Workers, cfg.ElevationConfig.Retries)
+	sched.geometry = cfg.ElevationConfig.Coordinates == types.ShapeCoordinates
+	sched.stop = stop
+	if cfg.ElevationConfig.Overwrite {
+		// points the run can't sample keep their elevation, an interrupted run loses nothing
+		where := cfg.ElevationConfig.Where
+		sched.where = &where
+		log.Printf("Resampling the elevations of dataset=%s selected by %s", d.Name, where)
+	}
+	parts, err := sched.partitions(cfg.ElevationConfig.Partition)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 && sched.where != nil {
+		log.Printf("No points of dataset=%s selected by %s", d.Name, *sched.where)
+		return nil
+	}
+	if len(parts) == 0 {
+		log.Print("Elevation data is completely populated for dataset=", d.Name)
+		return nil
+	}
+	log.Printf("Split the points to sample of dataset=%s into %d %s partitions", d.Name, len(parts), cfg.ElevationConfig.Partition)
+
+	run := model.ElevationRun{
+		DatasetId: d.Id,
+		Started:   time.Now(),
+		Source:    cfg.ElevationConfig.NationalMapURL,
+		Product:   joinProducts(cfg.ElevationConfig.Products),
+		Sampling:  cfg.ElevationConfig.Sampling,
+	}
+	if cfg.ElevationConfig.DemSource != "" {
+		run.Source = cfg.ElevationConfig.DemSource
+		run.Product = "local dem"
+	}
+	err = st.AddElevationRun(&run)
 	if err != nil {
 		return err
 	}
-	eStore, err := elevation.NewElevationAccessor(points.BoundingBox())
+	runErr := sched.run(parts)
+	ended := time.Now()
+	run.Ended = &ended
+	run.Filled = sched.filled
+	run.Failed = sched.noData + sched.noCoverage + sched.unresolved + sched.invalid
+	run.Mismatched = sched.mismatched
+	if runErr != nil {
+		msg := runErr.Error()
+		run.Error = &msg
+	}
+	err = st.UpdateElevationRun(run)
+	if err != nil {
+		log.Printf("Unable to record the end of elevation run=%s: %s", run.Id, err)
+	}
+	return runErr
+}
+
+// registerElevationField records the unit and vertical datum of ground_elev
+// in the field registry and associates the field with the schema of the
+// dataset. The registry holds one unit per field, runs in another unit or
+// datum are rejected so that ground_elev is never written in mixed units
+func registerElevationField(cfg config.Config, st store.Store, d model.Dataset) error {
+	unit := string(cfg.ElevationConfig.Units)
+	datum := cfg.ElevationConfig.Datum
+	f := model.Field{
+		DbName:        global.ELEVATION_COLUMN_NAME,
+		Type:          types.Float,
+		Description:   "ground elevation sampled at x, y",
+		Unit:          &unit,
+		VerticalDatum: &datum,
+	}
+	err := st.GetFieldId(&f)
 	if err != nil {
 		return err
 	}
-	err = eStore.GetElevation(points)
+	if f.Id == uuid.Nil {
+		err = st.AddField(&f)
+		if err != nil {
+			return err
+		}
+	} else {
+		existing, err := st.GetFieldsByName(f)
+		if err != nil {
+			return err
+		}
+		for _, e := range existing {
+			if e.Id != f.Id {
+				continue
+			}
+			if e.Unit == nil {
+				// registered by an upload, which doesn't know the unit
+				err = st.UpdateFieldUnit(f)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			err = elevationFieldMatches(e, unit, datum)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	sf := model.SchemaField{Id: d.SchemaId, NsiFieldId: f.Id}
+	exists, err := st.SchemaFieldAssociationExists(sf)
+	if err != nil || exists {
+		return err
+	}
+	return st.AddSchemaFieldAssociation(sf)
+}
+
+// checkElevationField fails unless ground_elev is registered in the unit and
+// vertical datum of cfg. Unlike registerElevationField it never writes the
+// registry, a review doesn't change what ground_elev was written in
+func checkElevationField(cfg config.Config, st store.Store) error {
+	unit := string(cfg.ElevationConfig.Units)
+	datum := cfg.ElevationConfig.Datum
+	f := model.Field{DbName: global.ELEVATION_COLUMN_NAME, Type: types.Float}
+	err := st.GetFieldId(&f)
 	if err != nil {
 		return err
 	}
-	err = s.UpdateElevationAtPoint(d, points)
+	if f.Id == uuid.Nil {
+		return errors.New(fmt.Sprintf("field=%s is not registered, run mod elevation first", f.DbName))
+	}
+	existing, err := st.GetFieldsByName(f)
 	if err != nil {
 		return err
 	}
+	for _, e := range existing {
+		if e.Id != f.Id {
+			continue
+		}
+		if e.Unit == nil {
+			return errors.New(fmt.Sprintf("field=%s has no registered unit, run mod elevation first", f.DbName))
+		}
+		err = elevationFieldMatches(e, unit, datum)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// elevationFieldMatches fails if the registered field e isn't in unit and datum
+func elevationFieldMatches(e model.Field, unit string, datum string) error {
+	if *e.Unit == unit && e.VerticalDatum != nil && *e.VerticalDatum == datum {
+		return nil
+	}
+	registered := *e.Unit
+	if e.VerticalDatum != nil {
+		registered += " " + *e.VerticalDatum
+	}
+	return errors.New(fmt.Sprintf(
+		"field=%s is registered in %s, rerun with --units and --datum matching the registry instead of %s %s",
+		e.DbName, registered, unit, datum,
+	))
+}
+
+// newElevationSource picks the local dem source if configured, otherwise the
+// National Map. Swapped out in tests to avoid sampling real rasters
+var newElevationSource = func(cfg config.Config) (elevation.Source, error) {
+	if cfg.ElevationConfig.DemSource != "" {
+		return elevation.NewLocalSource(cfg.ElevationConfig.DemSource, cfg.ElevationConfig.Sampling)
+	}
+	return elevation.NationalMapSource{
+		NationalMap: elevation.NationalMap{
+			BaseURL:     cfg.ElevationConfig.NationalMapURL,
+			CacheDir:    cfg.ElevationConfig.DemCache,
+			RequestRate: cfg.ElevationConfig.RequestRate,
+			Products:    cfg.ElevationConfig.Products,
+		},
+		Sampling: cfg.ElevationConfig.Sampling,
+	}, nil
+}
+
+// elevationSource converts the samples of the configured source to the unit and
+// datum ground_elev is written in
+func elevationSource(cfg config.Config) (elevation.Source, error) {
+	src, err := newElevationSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ElevationConfig.Units == types.Feet || cfg.ElevationConfig.Geoid != "" {
+		src = elevation.VerticalTransform{
+			Source: src,
+			Unit:   cfg.ElevationConfig.Units,
+			Geoid:  cfg.ElevationConfig.Geoid,
+		}
+	}
+	return src, nil
+}
+
+// joinProducts lists the products of a run in the order they were tried
+func joinProducts(products []types.ElevationProduct) string {
+	var s []string
+	for _, p := range products {
+		s = append(s, string(p))
+	}
+	return strings.Join(s, ",")
+}
@@ -1,12 +1,27 @@
 package core
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/config"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/elevation"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/global"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/model"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/store"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/tnmtest"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+	"github.com/google/uuid"
+	"github.com/jonas-p/go-shp"
 	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
 )
 
 // func TestCore(t *testing.T) {
@@ -43,3 +58,1130 @@ func TestPrep(t *testing.T) {
 	err = Prep(cfg)
 	assert.Nil(t, err)
 }
+
+// assetsDir is resolved before TestPrep changes the working directory
+var assetsDir, _ = filepath.Abs("../../assets")
+
+// nsiFields lists the shp fields described by assets/newmetadatatest.xlsx, in order
+var nsiFields = []string{
+	"BID", "CBFIPS2010", "ST_DAMCAT", "OCCTYPE", "NUM_STORY", "HEIGHT", "SQFT",
+	"FTPRNTSQFT", "FOUND_HT", "EXTWALL", "FNDTYPE", "BSMNT", "P_EXTWALL",
+	"P_FNDTYPE", "P_BSMNT", "TOTAL_ROOM", "BEDROOMS", "TOTAL_BATH", "P_GARAGE",
+	"PARKINGSP", "YRBUILT", "MED_YR_BLT", "NAICS", "BLDCOSTCAT", "VAL_STRUCT",
+	"VAL_CONT", "VAL_VEHIC", "NUMVEHIC", "FTPRNTID", "FTPRNTSRC", "SOURCE",
+	"RESUNITS", "EMPNUM", "STUDENTS", "SURPLUS", "OTHINSTPOP", "NURSGHMPOP",
+	"POP2AMU65", "POP2AMO65", "POP2PMU65", "POP2PMO65", "O65DISABLE",
+	"U65DISABLE", "X", "Y", "APN", "CENSREGION", "FIRMZONE", "FIRMDATE",
+}
+
+// writeTestShp writes a point shp file with the nsiFields attribute table.
+// VAL_STRUCT is written as a numeric field unless valStructChar is set
+func writeTestShp(t *testing.T, path string, points [][2]float64, valStructChar bool) {
+	w, err := shp.Create(path, shp.POINT)
+	assert.Nil(t, err)
+	defer func() {
+		w.Close()
+		// go-shp v0.1.1 drops the dot from the dbf extension on create
+		base := strings.TrimSuffix(path, filepath.Ext(path))
+		assert.Nil(t, os.Rename(base+"dbf", base+".dbf"))
+	}()
+	var fields []shp.Field
+	for _, f := range nsiFields {
+		switch {
+		case f == "X" || f == "Y":
+			fields = append(fields, shp.FloatField(f, 19, 6))
+		case f == "VAL_STRUCT" && !valStructChar:
+			fields = append(fields, shp.NumberField(f, 12))
+		default:
+			fields = append(fields, shp.StringField(f, 12))
+		}
+	}
+	assert.Nil(t, w.SetFields(fields))
+	for i, p := range points {
+		w.Write(&shp.Point{X: p[0], Y: p[1]})
+		for j, f := range nsiFields {
+			var v interface{} = fmt.Sprintf("%s%d", f[:1], i)
+			switch f {
+			case "X":
+				v = p[0]
+			case "Y":
+				v = p[1]
+			}
+			if (f == "X" || f == "Y") && math.IsNaN(p[0]) {
+				// NaN coordinates are written as null
+				v = strings.Repeat(" ", 19)
+			}
+			switch f {
+			case "VAL_STRUCT":
+				v = "100"
+			}
+			assert.Nil(t, w.WriteAttribute(i, j, v))
+		}
+	}
+}
+
+// writeTestMetadata fills in the dataset sheet of the test metadata workbook
+func writeTestMetadata(t *testing.T, path string) {
+	f, err := excelize.OpenFile(filepath.Join(assetsDir, "newmetadatatest.xlsx"))
+	assert.Nil(t, err)
+	defer f.Close()
+	for cell, v := range map[string]string{
+		"C4": "testing",
+		"C5": "core_test",
+		"C6": "high",
+		"C7": "nsidev",
+	} {
+		assert.Nil(t, f.SetCellValue("dataset", cell, v))
+	}
+	assert.Nil(t, f.SaveAs(path))
+}
+
+func uploadConfig(shpPath string, xlsPath string, policy types.FieldConflict) config.Config {
+	return config.Config{
+		Mode: types.Upload,
+		PathConfig: config.PathConfig{
+			ShpPath: shpPath,
+			XlsPath: xlsPath,
+		},
+		UploadConfig: config.UploadConfig{
+			FieldConflict: policy,
+		},
+	}
+}
+
+func elevationConfig() config.Config {
+	return config.Config{
+		Mode: types.Elevation,
+		DatasetConfig: config.DatasetConfig{
+			Dataset: "nsiDevTest2",
+			Version: "0.0.9",
+			Quality: types.High,
+		},
+		ElevationConfig: config.ElevationConfig{
+			Workers:   2,
+			Partition: types.TilePartition,
+			Retries:   2,
+			Products:  []types.ElevationProduct{types.OneMeter, types.NinthArcSecond, types.ThirdArcSecond, types.OneArcSecond},
+			Units:     types.Meters,
+			Datum:     global.NATIONAL_MAP_VERTICAL_DATUM,
+		},
+	}
+}
+
+// emptyElevationPoints returns every point without an elevation or a reason
+func emptyElevationPoints(t *testing.T, st store.Store, d model.Dataset) elevation.Points {
+	points, err := st.GetEmptyElevationPoints(d, elevation.Partition{MaxFdId: math.MaxInt32}, 0, 100)
+	assert.Nil(t, err)
+	return points
+}
+
+func testDataset(t *testing.T, st store.Store) model.Dataset {
+	q := model.Quality{Value: types.High}
+	assert.Nil(t, st.GetQualityId(&q))
+	d := model.Dataset{Name: "nsiDevTest2", Version: "0.0.9", QualityId: q.Id}
+	assert.Nil(t, st.GetDataset(&d))
+	return d
+}
+
+// fakeElevation derives elevation from coordinates instead of querying the National Map
+type fakeElevation struct{}
+
+func (fakeElevation) GetElevation(p elevation.Points) error {
+	for _, point := range p {
+		if point.Resolved() {
+			continue
+		}
+		v := point.X + point.Y
+		point.Elevation = &v
+	}
+	return nil
+}
+
+func TestUpload(t *testing.T) {
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpA := filepath.Join(dir, "15001.shp")
+	writeTestShp(t, shpA, [][2]float64{{-155.1, 19.7}, {-155.2, 19.8}, {-155.3, 19.9}}, false)
+	shpB := filepath.Join(dir, "15003.shp")
+	writeTestShp(t, shpB, [][2]float64{{-157.8, 21.3}, {-157.9, 21.4}}, false)
+
+	st := store.NewMemStore()
+	err := Upload(uploadConfig(shpA, xlsPath, types.Fail), st)
+	assert.Nil(t, err)
+	d := testDataset(t, st)
+	assert.NotEqual(t, uuid.Nil, d.Id)
+	assert.Equal(t, 3, st.InventoryCount(d))
+	assert.Equal(t, []string{"bid", "cbfips2010", "occtype"}, st.InventoryIndexes(d))
+
+	// same shp file is rejected
+	err = Upload(uploadConfig(shpA, xlsPath, types.Fail), st)
+	assert.Contains(t, fmt.Sprint(err), "already been uploaded")
+	assert.Equal(t, 3, st.InventoryCount(d))
+
+	// new shp file is appended to the same dataset
+	err = Upload(uploadConfig(shpB, xlsPath, types.Fail), st)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, st.InventoryCount(d))
+
+	// statistics are merged across loads
+	stats, err := st.GetDatasetStats(d)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), stats.RowCount)
+	assert.Equal(t, -157.9, stats.MinX)
+	assert.Equal(t, -155.1, stats.MaxX)
+	assert.Equal(t, 19.7, stats.MinY)
+	assert.Equal(t, 21.4, stats.MaxY)
+	assert.False(t, stats.LastLoad.IsZero())
+}
+
+func TestUploadFieldConflict(t *testing.T) {
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpN := filepath.Join(dir, "15001.shp")
+	writeTestShp(t, shpN, [][2]float64{{-155.1, 19.7}}, false)
+	shpC := filepath.Join(dir, "15003.shp")
+	writeTestShp(t, shpC, [][2]float64{{-157.8, 21.3}}, true)
+	valStruct := model.Field{DbName: "val_struct"}
+
+	st := store.NewMemStore()
+	assert.Nil(t, Upload(uploadConfig(shpN, xlsPath, types.Fail), st))
+
+	err := Upload(uploadConfig(shpC, xlsPath, types.Fail), st)
+	assert.Contains(t, fmt.Sprint(err), "field type conflict")
+
+	assert.Nil(t, Upload(uploadConfig(shpC, xlsPath, types.Coerce), st))
+	fs, err := st.GetFieldsByName(valStruct)
+	assert.Nil(t, err)
+	assert.Len(t, fs, 1)
+	assert.Equal(t, types.Datatype(types.Number), fs[0].Type)
+
+	shpC2 := filepath.Join(dir, "15005.shp")
+	writeTestShp(t, shpC2, [][2]float64{{-156.3, 20.8}}, true)
+	assert.Nil(t, Upload(uploadConfig(shpC2, xlsPath, types.Create), st))
+	fs, err = st.GetFieldsByName(valStruct)
+	assert.Nil(t, err)
+	assert.Len(t, fs, 2)
+}
+
+func TestChangeAccess(t *testing.T) {
+	st := store.NewMemStore()
+	cfg := config.Config{
+		Mode: types.Access,
+		AccessConfig: config.AccessConfig{
+			Group:  "nsidev",
+			Role:   types.User,
+			UserId: "tester",
+		},
+	}
+	err := ChangeAccess(cfg, st)
+	assert.Contains(t, fmt.Sprint(err), "does not exists")
+
+	g := model.Group{Name: "nsidev"}
+	assert.Nil(t, st.AddGroup(&g))
+	assert.Nil(t, ChangeAccess(cfg, st))
+	m := model.Member{GroupId: g.Id, UserId: "tester"}
+	assert.Nil(t, st.GetMemberId(&m))
+	stored, ok := st.Member(m)
+	assert.True(t, ok)
+	assert.Equal(t, types.Role(types.User), stored.Role)
+
+	cfg.AccessConfig.Role = types.Admin
+	assert.Nil(t, ChangeAccess(cfg, st))
+	stored, ok = st.Member(m)
+	assert.True(t, ok)
+	assert.Equal(t, types.Role(types.Admin), stored.Role)
+}
+
+func TestAddElevation(t *testing.T) {
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpA := filepath.Join(dir, "15001.shp")
+	writeTestShp(t, shpA, [][2]float64{{-155.1, 19.7}, {-155.2, 19.8}, {-155.3, 19.9}}, false)
+
+	st := store.NewMemStore()
+	err := AddElevation(elevationConfig(), st)
+	assert.Contains(t, fmt.Sprint(err), "Unable to find dataset")
+
+	assert.Nil(t, Upload(uploadConfig(shpA, xlsPath, types.Fail), st))
+	newElevationSource = func(cfg config.Config) (elevation.Source, error) {
+		return fakeElevation{}, nil
+	}
+	assert.Nil(t, AddElevation(elevationConfig(), st))
+
+	d := testDataset(t, st)
+	assert.Len(t, emptyElevationPoints(t, st, d), 0)
+}
+
+func TestAddElevationNullCoordinates(t *testing.T) {
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpA := filepath.Join(dir, "15001.shp")
+	writeTestShp(t, shpA, [][2]float64{{-155.1, 19.7}, {math.NaN(), math.NaN()}, {-155.3, 19.9}}, false)
+
+	newElevationSource = func(cfg config.Config) (elevation.Source, error) {
+		return fakeElevation{}, nil
+	}
+	for _, partition := range []types.ElevationPartition{types.TilePartition, types.RangePartition} {
+		st := store.NewMemStore()
+		assert.Nil(t, Upload(uploadConfig(shpA, xlsPath, types.Fail), st))
+		cfg := elevationConfig()
+		cfg.ElevationConfig.Partition = partition
+		assert.Nil(t, AddElevation(cfg, st), partition)
+
+		// the point without x, y is marked instead of being picked up by every run
+		d := testDataset(t, st)
+		assert.Len(t, emptyElevationPoints(t, st, d), 0, partition)
+		points := st.InventoryPoints(d)
+		assert.Equal(t, types.ElevationReason(types.NoGeometry), points[1].Reason, partition)
+		assert.True(t, points[1].NilElevation(), partition)
+		assert.False(t, points[2].NilElevation(), partition)
+		empty, err := hasEmptyElevation(st, d)
+		assert.Nil(t, err)
+		assert.False(t, empty, partition)
+	}
+}
+
+func TestAddElevationUnits(t *testing.T) {
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpA := filepath.Join(dir, "15001.shp")
+	writeTestShp(t, shpA, [][2]float64{{-155.1, 19.7}, {-155.2, 19.8}, {-157.8, 21.3}}, false)
+	// a grid shifting NAVD88 down by half a meter over the first two points only
+	geoid := filepath.Join(dir, "geoid.tif")
+	f, err := os.Create(geoid)
+	assert.Nil(t, err)
+	grid := tnmtest.NewRaster(-156, -155, 19, 20, 4, 4, func(x, y float64) float64 { return -0.5 })
+	assert.Nil(t, grid.WriteGeoTIFF(f))
+	assert.Nil(t, f.Close())
+
+	st := store.NewMemStore()
+	assert.Nil(t, Upload(uploadConfig(shpA, xlsPath, types.Fail), st))
+	newElevationSource = func(cfg config.Config) (elevation.Source, error) {
+		return fakeElevation{}, nil
+	}
+	cfg := elevationConfig()
+	cfg.ElevationConfig.Units = types.Feet
+	cfg.ElevationConfig.Geoid = geoid
+	cfg.ElevationConfig.Datum = "LMSL"
+	assert.Nil(t, AddElevation(cfg, st))
+
+	d := testDataset(t, st)
+	for _, p := range st.InventoryPoints(d) {
+		if p.X == -157.8 {
+			// outside of the grid
+			assert.True(t, p.NilElevation())
+			assert.Equal(t, types.ElevationReason(types.NoDatumShift), p.Reason)
+			continue
+		}
+		assert.False(t, p.NilElevation())
+		assert.InDelta(t, (p.X+p.Y-0.5)/0.3048, *p.Elevation, 1e-9)
+	}
+
+	// the unit and datum are recorded in the field registry
+	fields, err := st.GetFieldsByName(model.Field{DbName: global.ELEVATION_COLUMN_NAME})
+	assert.Nil(t, err)
+	assert.Len(t, fields, 1)
+	assert.Equal(t, "feet", *fields[0].Unit)
+	assert.Equal(t, "LMSL", *fields[0].VerticalDatum)
+	exists, err := st.SchemaFieldAssociationExists(model.SchemaField{Id: d.SchemaId, NsiFieldId: fields[0].Id})
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	// ground_elev is never written in mixed units
+	err = AddElevation(elevationConfig(), st)
+	assert.Contains(t, fmt.Sprint(err), "registered in feet LMSL")
+}
+
+func TestAddElevationShape(t *testing.T) {
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpA := filepath.Join(dir, "15001.shp")
+	writeTestShp(t, shpA, [][2]float64{{-155.1, 19.7}, {-155.2, 19.8}, {-155.3, 19.9}, {-157.8, 21.3}}, false)
+
+	st := store.NewMemStore()
+	assert.Nil(t, Upload(uploadConfig(shpA, xlsPath, types.Fail), st))
+	d := testDataset(t, st)
+	// web mercator shape in the same place as x, y
+	const r = 6378137.0
+	mx := -155.1 * math.Pi / 180 * r
+	my := math.Log(math.Tan(math.Pi/4+19.7*math.Pi/360)) * r
+	assert.Nil(t, st.SetShape(d, 1, mx, my, 3857))
+	// shape about a kilometer off x, y
+	assert.Nil(t, st.SetShape(d, 2, -155.21, 19.8, 4326))
+	assert.Nil(t, st.SetShape(d, 3, 0, 0, elevation.NullShapeSRID))
+	assert.Nil(t, st.SetShape(d, 4, 0, 0, 999999))
+
+	newElevationSource = func(cfg config.Config) (elevation.Source, error) {
+		return fakeElevation{}, nil
+	}
+	cfg := elevationConfig()
+	cfg.ElevationConfig.Coordinates = types.ShapeCoordinates
+	cfg.ElevationConfig.Partition = types.RangePartition
+	assert.Nil(t, AddElevation(cfg, st))
+
+	points := st.InventoryPoints(d)
+	assert.Len(t, points, 4)
+	assert.InDelta(t, -155.1+19.7, *points[0].Elevation, 1e-6)
+	// sampled at the shape, not at x, y
+	assert.InDelta(t, -155.21+19.8, *points[1].Elevation, 1e-6)
+	assert.Equal(t, types.ElevationReason(types.NoGeometry), points[2].Reason)
+	assert.Equal(t, types.ElevationReason(types.InvalidSRID), points[3].Reason)
+
+	runs, err := st.GetElevationRuns(d)
+	assert.Nil(t, err)
+	assert.Len(t, runs, 1)
+	assert.Equal(t, int64(1), runs[0].Mismatched)
+	assert.Equal(t, int64(2), runs[0].Filled)
+	assert.Equal(t, int64(2), runs[0].Failed)
+}
+
+const testAdoptMeta = `schema:
+  name: legacySchema
+  version: 1.0.0
+dataset:
+  name: legacy
+  version: 2.0.0
+  createdBy: core_test
+  quality: high
+  group: nsidev
+fields:
+  - name: occtype
+    isDomain: true
+    index: true
+  - name: val_struct
+  - name: x
+  - name: y
+`
+
+func TestAdopt(t *testing.T) {
+	metaPath := filepath.Join(t.TempDir(), "legacy.yaml")
+	assert.Nil(t, os.WriteFile(metaPath, []byte(testAdoptMeta), 0644))
+	cfg := config.Config{
+		Mode: types.Adopt,
+		AdoptConfig: config.AdoptConfig{
+			Table:    global.DB_SCHEMA + ".legacy_inventory",
+			MetaPath: metaPath,
+		},
+		UploadConfig: config.UploadConfig{FieldConflict: types.Fail},
+	}
+	columns := map[string]string{
+		"occtype":    "text",
+		"val_struct": "double precision",
+		"x":          "double precision",
+		"y":          "double precision",
+		"comment":    "text",
+	}
+	rows := []map[string]string{
+		{"occtype": "RES1", "val_struct": "100", "x": "-155.1", "y": "19.7"},
+		{"occtype": "COM1", "val_struct": "200", "x": "-157.8", "y": "21.3"},
+		{"occtype": "RES1", "val_struct": "", "x": "-156.3", "y": "20.8"},
+	}
+
+	st := store.NewMemStore()
+	err := Adopt(cfg, st)
+	assert.Contains(t, fmt.Sprint(err), "does not exist")
+
+	assert.Nil(t, st.AddTable("legacy_inventory", columns, rows))
+	other := cfg
+	other.AdoptConfig.Table = "public.legacy_inventory"
+	err = Adopt(other, st)
+	assert.Contains(t, fmt.Sprint(err), "set schema")
+
+	assert.Nil(t, Adopt(cfg, st))
+	q := model.Quality{Value: types.High}
+	assert.Nil(t, st.GetQualityId(&q))
+	d := model.Dataset{Name: "legacy", Version: "2.0.0", QualityId: q.Id}
+	assert.Nil(t, st.GetDataset(&d))
+	assert.Equal(t, "legacy_inventory", d.TableName)
+	assert.Equal(t, 3, st.InventoryCount(d))
+	assert.Equal(t, []string{"occtype"}, st.InventoryIndexes(d))
+
+	adopted, err := st.GetInventoryColumns(d)
+	assert.Nil(t, err)
+	assert.Contains(t, adopted, "fd_id")
+	assert.Contains(t, adopted, "shape")
+	maxFdId, err := st.GetMaxFdId(d)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, maxFdId)
+
+	fs, err := st.GetFieldsByName(model.Field{DbName: "occtype"})
+	assert.Nil(t, err)
+	assert.Len(t, fs, 1)
+	assert.Equal(t, []string{"COM1", "RES1"}, st.Domains(fs[0]))
+	fs, err = st.GetFieldsByName(model.Field{DbName: "val_struct"})
+	assert.Nil(t, err)
+	assert.Equal(t, types.Datatype(types.Float), fs[0].Type)
+
+	stats, err := st.GetDatasetStats(d)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), stats.RowCount)
+	assert.Equal(t, int64(1), stats.NullCounts["val_struct"])
+	assert.Equal(t, -157.8, stats.MinX)
+
+	err = Adopt(cfg, st)
+	assert.Contains(t, fmt.Sprint(err), "already exists")
+}
+
+func TestAdoptMissingColumn(t *testing.T) {
+	metaPath := filepath.Join(t.TempDir(), "legacy.yaml")
+	assert.Nil(t, os.WriteFile(metaPath, []byte(testAdoptMeta), 0644))
+	st := store.NewMemStore()
+	assert.Nil(t, st.AddTable("legacy_inventory", map[string]string{
+		"occtype": "text",
+		"x":       "double precision",
+		"y":       "double precision",
+	}, nil))
+	err := Adopt(config.Config{
+		AdoptConfig:  config.AdoptConfig{Table: "legacy_inventory", MetaPath: metaPath},
+		UploadConfig: config.UploadConfig{FieldConflict: types.Fail},
+	}, st)
+	assert.Contains(t, fmt.Sprint(err), "fields=val_struct")
+}
+
+// partialElevation only covers points within b
+type partialElevation struct {
+	b elevation.BoundingBox
+}
+
+func (e partialElevation) GetElevation(p elevation.Points) error {
+	return fakeElevation{}.GetElevation(e.b.Intersect(p))
+}
+
+func TestAddElevationPartialCoverage(t *testing.T) {
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpA := filepath.Join(dir, "15001.shp")
+	writeTestShp(t, shpA, [][2]float64{{-155.1, 19.7}, {-157.8, 21.3}}, false)
+
+	st := store.NewMemStore()
+	assert.Nil(t, Upload(uploadConfig(shpA, xlsPath, types.Fail), st))
+	newElevationSource = func(cfg config.Config) (elevation.Source, error) {
+		return partialElevation{elevation.BoundingBox{MinX: -156, MaxX: -155, MinY: 19, MaxY: 20}}, nil
+	}
+	// must terminate although one point can never be filled
+	assert.Nil(t, AddElevation(elevationConfig(), st))
+
+	d := testDataset(t, st)
+	assert.Len(t, emptyElevationPoints(t, st, d), 0)
+	for _, p := range st.InventoryPoints(d) {
+		if p.X == -157.8 {
+			assert.True(t, p.NilElevation())
+			assert.Equal(t, types.ElevationReason(types.Unresolved), p.Reason)
+		} else {
+			assert.False(t, p.NilElevation())
+		}
+	}
+}
+
+// offsetElevation is fakeElevation raised by offset
+type offsetElevation float64
+
+func (e offsetElevation) GetElevation(p elevation.Points) error {
+	err := fakeElevation{}.GetElevation(p)
+	for _, point := range p {
+		if !point.NilElevation() {
+			v := *point.Elevation + float64(e)
+			point.Elevation = &v
+		}
+	}
+	return err
+}
+
+// boxedElevation is offsetElevation within b and no coverage outside of it
+type boxedElevation struct {
+	b      elevation.BoundingBox
+	offset float64
+}
+
+func (e boxedElevation) GetElevation(p elevation.Points) error {
+	err := offsetElevation(e.offset).GetElevation(e.b.Intersect(p))
+	for _, point := range p {
+		if !point.Resolved() {
+			point.Reason = types.NoCoverage
+		}
+	}
+	return err
+}
+
+func TestAddElevationOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpA := filepath.Join(dir, "15001.shp")
+	writeTestShp(t, shpA, [][2]float64{{-155.1, 19.7}, {-155.2, 19.8}, {-157.8, 21.3}}, false)
+
+	st := store.NewMemStore()
+	assert.Nil(t, Upload(uploadConfig(shpA, xlsPath, types.Fail), st))
+	newElevationSource = func(cfg config.Config) (elevation.Source, error) {
+		return partialElevation{elevation.BoundingBox{MinX: -156, MaxX: -155, MinY: 19, MaxY: 20}}, nil
+	}
+	assert.Nil(t, AddElevation(elevationConfig(), st))
+
+	// without --overwrite nothing is resampled
+	newElevationSource = func(cfg config.Config) (elevation.Source, error) {
+		return offsetElevation(100), nil
+	}
+	assert.Nil(t, AddElevation(elevationConfig(), st))
+	d := testDataset(t, st)
+	points := st.InventoryPoints(d)
+	assert.InDelta(t, -155.1+19.7, *points[0].Elevation, 1e-9)
+	assert.Equal(t, types.ElevationReason(types.Unresolved), points[2].Reason)
+
+	// only the unresolved point
+	cfg := elevationConfig()
+	cfg.ElevationConfig.Overwrite = true
+	cfg.ElevationConfig.Where = elevation.Filter{Reasons: []types.ElevationReason{types.Unresolved}}
+	assert.Nil(t, AddElevation(cfg, st))
+	points = st.InventoryPoints(d)
+	assert.InDelta(t, -155.1+19.7, *points[0].Elevation, 1e-9)
+	assert.InDelta(t, -157.8+21.3+100, *points[2].Elevation, 1e-9)
+	assert.Equal(t, types.ElevationReason(""), points[2].Reason)
+
+	// only the point in the box
+	cfg.ElevationConfig.Where = elevation.Filter{BBox: &elevation.BoundingBox{MinX: -155.15, MaxX: -155.05, MinY: 19.65, MaxY: 19.75}}
+	assert.Nil(t, AddElevation(cfg, st))
+	points = st.InventoryPoints(d)
+	assert.InDelta(t, -155.1+19.7+100, *points[0].Elevation, 1e-9)
+	assert.InDelta(t, -155.2+19.8, *points[1].Elevation, 1e-9)
+
+	runs, err := st.GetElevationRuns(d)
+	assert.Nil(t, err)
+	assert.Len(t, runs, 3)
+	assert.Equal(t, int64(1), runs[2].Filled)
+
+	// every point only with --all
+	cfg.ElevationConfig.Where = elevation.Filter{}
+	err = AddElevation(cfg, st)
+	assert.Contains(t, fmt.Sprint(err), "requires --all")
+	points = st.InventoryPoints(d)
+	assert.InDelta(t, -155.2+19.8, *points[1].Elevation, 1e-9)
+	cfg.ElevationConfig.All = true
+	assert.Nil(t, AddElevation(cfg, st))
+	points = st.InventoryPoints(d)
+	assert.InDelta(t, -155.2+19.8+100, *points[1].Elevation, 1e-9)
+
+	// points the new source doesn't cover keep their elevation and reason
+	newElevationSource = func(cfg config.Config) (elevation.Source, error) {
+		return boxedElevation{b: elevation.BoundingBox{MinX: -156, MaxX: -155, MinY: 19, MaxY: 20}, offset: 200}, nil
+	}
+	assert.Nil(t, AddElevation(cfg, st))
+	points = st.InventoryPoints(d)
+	assert.InDelta(t, -155.1+19.7+200, *points[0].Elevation, 1e-9)
+	assert.InDelta(t, -155.2+19.8+200, *points[1].Elevation, 1e-9)
+	assert.InDelta(t, -157.8+21.3+100, *points[2].Elevation, 1e-9)
+	assert.Equal(t, types.ElevationReason(""), points[2].Reason)
+	runs, err = st.GetElevationRuns(d)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), runs[len(runs)-1].Filled)
+	assert.Equal(t, int64(1), runs[len(runs)-1].Failed)
+}
+
+// qaElevation is a flat DEM at 100 with a 60 terrace from x=-156 to -155.0008
+type qaElevation struct{}
+
+func (qaElevation) GetElevation(p elevation.Points) error {
+	for _, point := range p {
+		v := 100.0
+		if point.X > -156 && point.X < -155.0008 {
+			v = 60
+		}
+		point.Elevation = &v
+	}
+	return nil
+}
+
+func TestElevationQA(t *testing.T) {
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpA := filepath.Join(dir, "15001.shp")
+	// a block of structures about 50 m apart and an isolated one
+	// and one without x, y sampled at its shape
+	coords := [][2]float64{{-155.0, 19.5}, {-155.0005, 19.5}, {-155.001, 19.5}, {-155.0, 19.5005}, {-155.0005, 19.5005}, {-157.8, 21.3}, {math.NaN(), math.NaN()}}
+	writeTestShp(t, shpA, coords, false)
+
+	st := store.NewMemStore()
+	assert.Nil(t, Upload(uploadConfig(shpA, xlsPath, types.Fail), st))
+	d := testDataset(t, st)
+	assert.Nil(t, st.AddElevationColumn(d))
+	var points elevation.Points
+	for i, e := range []float64{100, 101, 99, 100, 150, 105, 500} {
+		v := e
+		points = append(points, &elevation.Point{FdId: i + 1, X: coords[i][0], Y: coords[i][1], Elevation: &v})
+	}
+	assert.Nil(t, st.UpdateElevationAtPoint(d, points))
+
+	newElevationSource = func(cfg config.Config) (elevation.Source, error) {
+		return qaElevation{}, nil
+	}
+	cfg := elevationConfig()
+	cfg.Mode = types.ElevationQA
+	cfg.QAConfig = config.QAConfig{Radius: 250, Threshold: 10}
+	cells, err := st.GetElevationCells(d, global.ELEVATION_TILE_SIZE)
+	assert.Nil(t, err)
+	assert.Len(t, cells, 3)
+	// the review reads the unit of ground_elev from the registry, never writes it
+	assert.NotNil(t, ElevationQA(cfg, st))
+	f := model.Field{DbName: global.ELEVATION_COLUMN_NAME, Type: types.Float}
+	assert.Nil(t, st.GetFieldId(&f))
+	assert.Equal(t, uuid.Nil, f.Id)
+	assert.Nil(t, registerElevationField(cfg, st, d))
+	feet := cfg
+	feet.ElevationConfig.Units = types.Feet
+	assert.NotNil(t, ElevationQA(feet, st))
+	for i := 0; i < 2; i++ {
+		// a rerun replaces the review rows
+		assert.Nil(t, ElevationQA(cfg, st))
+		reviews, err := st.GetElevationReviews(d)
+		assert.Nil(t, err)
+		assert.Len(t, reviews, 3)
+		// on the terrace
+		assert.Equal(t, 3, reviews[0].FdId)
+		assert.Equal(t, types.ElevationCheck(types.DemCheck), reviews[0].Check)
+		assert.InDelta(t, 39, reviews[0].Difference, 1e-9)
+		// off its neighbors and the DEM
+		assert.Equal(t, 5, reviews[1].FdId)
+		assert.Equal(t, types.ElevationCheck(types.DemCheck), reviews[1].Check)
+		assert.Equal(t, 5, reviews[2].FdId)
+		assert.Equal(t, types.ElevationCheck(types.NeighborCheck), reviews[2].Check)
+		assert.InDelta(t, 100, reviews[2].Expected, 1e-9)
+		assert.Equal(t, 4, reviews[2].Samples)
+	}
+}
+
+func TestSampleRaster(t *testing.T) {
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpA := filepath.Join(dir, "15001.shp")
+	// the last point has no x, y and is skipped
+	writeTestShp(t, shpA, [][2]float64{{-155.1, 19.7}, {-155.2, 19.8}, {-157.8, 21.3}, {math.NaN(), math.NaN()}}, false)
+	st := store.NewMemStore()
+	assert.Nil(t, Upload(uploadConfig(shpA, xlsPath, types.Fail), st))
+	d := testDataset(t, st)
+	skipped, err := st.CountNullCoordinates(d)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, skipped)
+
+	writeGrid := func(v float64) string {
+		path := filepath.Join(dir, "depth.tif")
+		f, err := os.Create(path)
+		assert.Nil(t, err)
+		grid := tnmtest.NewRaster(-156, -155, 19, 20, 10, 10, func(x, y float64) float64 { return v })
+		assert.Nil(t, grid.WriteGeoTIFF(f))
+		assert.Nil(t, f.Close())
+		return path
+	}
+	cfg := config.Config{
+		Mode:          types.Raster,
+		DatasetConfig: elevationConfig().DatasetConfig,
+		RasterConfig: config.RasterConfig{
+			Column:   "flood_depth",
+			Raster:   writeGrid(2.5),
+			Band:     1,
+			Sampling: types.Nearest,
+		},
+	}
+	assert.Nil(t, SampleRaster(cfg, st))
+	// outside of the grid
+	assert.Equal(t, []string{"2.5", "2.5", "", ""}, st.InventoryValues(d, "flood_depth"))
+	fields, err := st.GetFieldsByName(model.Field{DbName: "flood_depth"})
+	assert.Nil(t, err)
+	assert.Len(t, fields, 1)
+	assert.Equal(t, types.Datatype(types.Float), fields[0].Type)
+
+	// a rerun replaces the values
+	cfg.RasterConfig.Raster = writeGrid(3)
+	assert.Nil(t, SampleRaster(cfg, st))
+	assert.Equal(t, []string{"3", "3", "", ""}, st.InventoryValues(d, "flood_depth"))
+
+	cfg.RasterConfig.Band = 2
+	assert.Contains(t, fmt.Sprint(SampleRaster(cfg, st)), "unable to sample band=2")
+	cfg.RasterConfig.Band = 1
+	cfg.RasterConfig.Column = global.ELEVATION_COLUMN_NAME
+	assert.Contains(t, fmt.Sprint(SampleRaster(cfg, st)), "maintained by sael")
+}
+
+// writeTestPolygons writes a polygon shp file of squares with a FLD_ZONE attribute
+func writeTestPolygons(t *testing.T, path string, squares [][4]float64, zones []string) {
+	w, err := shp.Create(path, shp.POLYGON)
+	assert.Nil(t, err)
+	defer func() {
+		w.Close()
+		base := strings.TrimSuffix(path, filepath.Ext(path))
+		assert.Nil(t, os.Rename(base+"dbf", base+".dbf"))
+	}()
+	assert.Nil(t, w.SetFields([]shp.Field{shp.StringField("FLD_ZONE", 8)}))
+	for i, s := range squares {
+		minX, minY, maxX, maxY := s[0], s[1], s[2], s[3]
+		p := shp.Polygon(*shp.NewPolyLine([][]shp.Point{{
+			{X: minX, Y: minY}, {X: minX, Y: maxY}, {X: maxX, Y: maxY}, {X: maxX, Y: minY}, {X: minX, Y: minY},
+		}}))
+		n := w.Write(&p)
+		assert.Nil(t, w.WriteAttribute(int(n), 0, zones[i]))
+	}
+}
+
+func TestEnrichInventory(t *testing.T) {
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpA := filepath.Join(dir, "15001.shp")
+	writeTestShp(t, shpA, [][2]float64{{-155.1, 19.7}, {-155.2, 19.8}, {-157.8, 21.3}, {-155.5, 19.5}}, false)
+	st := store.NewMemStore()
+	assert.Nil(t, Upload(uploadConfig(shpA, xlsPath, types.Fail), st))
+	d := testDataset(t, st)
+
+	polygons := filepath.Join(dir, "firm.shp")
+	writeTestPolygons(t, polygons, [][4]float64{
+		{-155.3, 19.6, -155.0, 19.9},
+		{-155.25, 19.75, -155.15, 19.85}, // agrees with the first
+		{-155.6, 19.4, -155.4, 19.6},
+		{-155.55, 19.45, -155.45, 19.55}, // disagrees with the third
+	}, []string{"AE", "AE", "X", "VE"})
+
+	cfg := config.Config{
+		Mode:          types.Enrich,
+		DatasetConfig: elevationConfig().DatasetConfig,
+		EnrichConfig: config.EnrichConfig{
+			Polygons:  polygons,
+			Attribute: "FLD_ZONE",
+			Column:    "flood_zone",
+		},
+	}
+	assert.Nil(t, EnrichInventory(cfg, st))
+	assert.Equal(t, []string{"AE", "AE", "", ""}, st.InventoryValues(d, "flood_zone"))
+	r, err := st.EnrichFromPolygons(d, model.PolygonLayer{Path: polygons, Attribute: "FLD_ZONE"}, "flood_zone", "text")
+	assert.Nil(t, err)
+	assert.Equal(t, model.EnrichResult{
+		Updated:     2,
+		NoMatch:     1,
+		Multiple:    2,
+		Conflicting: 1,
+		NoMatchIds:  []int{3},
+		MultipleIds: []int{2, 4},
+	}, r)
+	fields, err := st.GetFieldsByName(model.Field{DbName: "flood_zone"})
+	assert.Nil(t, err)
+	assert.Len(t, fields, 1)
+	assert.Equal(t, types.Char, fields[0].Type)
+
+	// an existing text column is overwritten where a polygon matches
+	cfg.EnrichConfig.Column = "firmzone"
+	assert.Nil(t, EnrichInventory(cfg, st))
+	assert.Equal(t, []string{"AE", "AE", "F2", "F3"}, st.InventoryValues(d, "firmzone"))
+
+	cfg.EnrichConfig.Column = "x"
+	assert.Contains(t, fmt.Sprint(EnrichInventory(cfg, st)), "maintained by sael")
+	cfg.EnrichConfig.Column = "flood_zone"
+	cfg.EnrichConfig.Attribute = "FLD ZONE"
+	assert.Contains(t, fmt.Sprint(EnrichInventory(cfg, st)), "invalid attribute")
+}
+
+// benchmarkElevation samples 101 from the 1m product east of -156 and
+// nodata west of it
+type benchmarkElevation struct{}
+
+func (benchmarkElevation) GetElevation(p elevation.Points) error {
+	for _, point := range p {
+		if point.X < -156 {
+			point.Reason = types.NoData
+			continue
+		}
+		v := 101.0
+		point.Elevation = &v
+		point.Product = types.OneMeter
+		point.Tile = "USGS_1M_test.tif"
+	}
+	return nil
+}
+
+func TestAssessElevation(t *testing.T) {
+	dir := t.TempDir()
+	benchmarks := filepath.Join(dir, "benchmarks.csv")
+	assert.Nil(t, os.WriteFile(benchmarks, []byte(
+		"id,x,y,elevation,region\n"+
+			"a,-155.1,19.7,100,hilo\n"+
+			"b,-155.2,19.8,100,hilo\n"+
+			"c,-155.3,19.9,104,\n"+
+			"d,-157.8,21.3,50,\n"), 0644))
+
+	b, err := readBenchmarks(benchmarks)
+	assert.Nil(t, err)
+	assert.Len(t, b, 4)
+	assert.Equal(t, "hilo", b[0].Region)
+	assert.Equal(t, "n20w156", b[2].Region)
+	assert.Equal(t, "n22w158", b[3].Region)
+
+	newElevationSource = func(cfg config.Config) (elevation.Source, error) {
+		return benchmarkElevation{}, nil
+	}
+	output := filepath.Join(dir, "assessment.csv")
+	cfg := elevationConfig()
+	cfg.Mode = types.ElevationAssess
+	cfg.AssessConfig = config.AssessConfig{Benchmarks: benchmarks, Output: output}
+	assert.Nil(t, AssessElevation(cfg))
+	out, err := os.ReadFile(output)
+	assert.Nil(t, err)
+	assert.Equal(t, "id,x,y,region,elevation,sampled,difference,product,source,reason\n"+
+		"a,-155.1,19.7,hilo,100,101,1,1m,USGS_1M_test.tif,\n"+
+		"b,-155.2,19.8,hilo,100,101,1,1m,USGS_1M_test.tif,\n"+
+		"c,-155.3,19.9,n20w156,104,101,-3,1m,USGS_1M_test.tif,\n"+
+		"d,-157.8,21.3,n22w158,50,,,none,,nodata\n", string(out))
+
+	a := elevation.NewAccuracy(4, []float64{1, 1, -3})
+	assert.Equal(t, 3, a.Sampled)
+	assert.InDelta(t, -1.0/3, a.Bias, 1e-9)
+	assert.InDelta(t, math.Sqrt(11.0/3), a.RMSE, 1e-9)
+	assert.InDelta(t, 1, a.P50, 1e-9)
+	assert.InDelta(t, 2.6, a.P90, 1e-9)
+
+	assert.Nil(t, os.WriteFile(benchmarks, []byte("id,lon,lat,elevation\na,-155.1,19.7,100\n"), 0644))
+	_, err = readBenchmarks(benchmarks)
+	assert.Contains(t, fmt.Sprint(err), "has no x column")
+	assert.Nil(t, os.WriteFile(benchmarks, []byte("x,y,elevation\n19.7,-155.1,100\n"), 0644))
+	_, err = readBenchmarks(benchmarks)
+	assert.Contains(t, fmt.Sprint(err), "use lon/lat")
+}
+
+// flakyElevation fails the first n calls
+type flakyElevation struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (e *flakyElevation) GetElevation(p elevation.Points) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.n > 0 {
+		e.n--
+		return errors.New("dem tile unavailable")
+	}
+	return fakeElevation{}.GetElevation(p)
+}
+
+func TestAddElevationRetry(t *testing.T) {
+	elevationRetryBackoff = time.Millisecond
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpA := filepath.Join(dir, "15001.shp")
+	writeTestShp(t, shpA, [][2]float64{{-155.1, 19.7}, {-157.8, 21.3}, {-157.9, 21.4}}, false)
+
+	for _, partition := range []types.ElevationPartition{types.TilePartition, types.RangePartition} {
+		st := store.NewMemStore()
+		assert.Nil(t, Upload(uploadConfig(shpA, xlsPath, types.Fail), st))
+		d := testDataset(t, st)
+
+		// failures within the budget are retried
+		cfg := elevationConfig()
+		cfg.ElevationConfig.Partition = partition
+		newElevationSource = func(cfg config.Config) (elevation.Source, error) {
+			return &flakyElevation{n: 2}, nil
+		}
+		assert.Nil(t, AddElevation(cfg, st), partition)
+		assert.Len(t, emptyElevationPoints(t, st, d), 0, partition)
+
+		// failures past the budget end the run with the error
+		st = store.NewMemStore()
+		assert.Nil(t, Upload(uploadConfig(shpA, xlsPath, types.Fail), st))
+		d = testDataset(t, st)
+		newElevationSource = func(cfg config.Config) (elevation.Source, error) {
+			return &flakyElevation{n: 3}, nil
+		}
+		err := AddElevation(cfg, st)
+		assert.Contains(t, fmt.Sprint(err), "retry budget exhausted", partition)
+		assert.Contains(t, fmt.Sprint(err), "dem tile unavailable", partition)
+		runs, err := st.GetElevationRuns(d)
+		assert.Nil(t, err)
+		assert.Len(t, runs, 1)
+		assert.Contains(t, *runs[0].Error, "retry budget exhausted", partition)
+	}
+}
+
+// defaultElevationSource keeps the production factory, tests above swap it out
+var defaultElevationSource = newElevationSource
+
+func TestAddElevationNationalMap(t *testing.T) {
+	srv, err := tnmtest.NewServer(filepath.Join(assetsDir, "tnmtest", "products.json"))
+	assert.Nil(t, err)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpA := filepath.Join(dir, "15001.shp")
+	writeTestShp(t, shpA, [][2]float64{{-155.1, 19.7}, {-155.6, 19.2}, {-157.8, 21.3}, {-155.5, 19.5}, {-149.5, 60.5}}, false)
+
+	st := store.NewMemStore()
+	assert.Nil(t, Upload(uploadConfig(shpA, xlsPath, types.Fail), st))
+	newElevationSource = defaultElevationSource
+	cfg := elevationConfig()
+	cfg.ElevationConfig.NationalMapURL = srv.URL
+	cfg.ElevationConfig.DemCache = filepath.Join(dir, "dem")
+	assert.Nil(t, AddElevation(cfg, st))
+
+	d := testDataset(t, st)
+	assert.Len(t, emptyElevationPoints(t, st, d), 0)
+	// nearest pixel center is at most half a pixel away
+	r := tnmtest.NewRaster(0, 1, 0, 1, srv.Width, srv.Height, tnmtest.Elevation)
+	sx, sy := r.PixelSize()
+	tolerance := 10*sx/2 + 20*sy/2
+	// each point is sampled from the best product covering it
+	products := map[float64]types.ElevationProduct{-155.5: types.OneMeter, -149.5: types.OneArcSecond}
+	for _, p := range st.InventoryPoints(d) {
+		assert.False(t, p.NilElevation())
+		assert.InDelta(t, tnmtest.Elevation(p.X, p.Y), *p.Elevation, tolerance)
+		// provenance
+		product, ok := products[p.X]
+		if !ok {
+			product = types.ThirdArcSecond
+			assert.Contains(t, p.Tile, "USGS_13_")
+			assert.InDelta(t, sy*111320, *p.Resolution, 1)
+		}
+		assert.Equal(t, product, p.Product)
+	}
+	runs, err := st.GetElevationRuns(d)
+	assert.Nil(t, err)
+	assert.Len(t, runs, 1)
+	assert.Equal(t, "1m,1/9,1/3,1", runs[0].Product)
+	assert.Equal(t, int64(5), runs[0].Filled)
+	assert.Equal(t, int64(0), runs[0].Failed)
+	assert.NotNil(t, runs[0].Ended)
+	assert.Nil(t, runs[0].Error)
+	assert.Equal(t, 1, srv.Hits(tnmtest.TiffPath+"USGS_13_n20w156_20130911.tif"))
+	assert.Equal(t, 1, srv.Hits(tnmtest.TiffPath+"USGS_13_n22w158_20130911.tif"))
+	assert.Equal(t, 1, srv.Hits(tnmtest.TiffPath+"USGS_1M_5_x24y216_HI_Hawaii_2017.tif"))
+	assert.Equal(t, 1, srv.Hits(tnmtest.TiffPath+"USGS_1_n61w150_20130911.tif"))
+	assert.Equal(t, 0, srv.Hits(tnmtest.TiffPath+"USGS_1_n20w156_20130911.tif"))
+
+	// a second run queries the National Map again and reuses the cached tiles
+	queries := srv.Hits(tnmtest.ProductsPath)
+	cfg.ElevationConfig.Overwrite = true
+	cfg.ElevationConfig.All = true
+	assert.Nil(t, AddElevation(cfg, st))
+	assert.Len(t, emptyElevationPoints(t, st, d), 0)
+	assert.Greater(t, srv.Hits(tnmtest.ProductsPath), queries)
+	assert.Equal(t, 1, srv.Hits(tnmtest.TiffPath+"USGS_13_n20w156_20130911.tif"))
+}
+
+func TestDemPrefetch(t *testing.T) {
+	srv, err := tnmtest.NewServer(filepath.Join(assetsDir, "tnmtest", "products.json"))
+	assert.Nil(t, err)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpA := filepath.Join(dir, "15001.shp")
+	writeTestShp(t, shpA, [][2]float64{{-155.1, 19.7}, {-157.8, 21.3}, {math.NaN(), math.NaN()}}, false)
+
+	st := store.NewMemStore()
+	assert.Nil(t, Upload(uploadConfig(shpA, xlsPath, types.Fail), st))
+	cfg := elevationConfig()
+	cfg.Mode = types.DemPrefetch
+	cfg.ElevationConfig.NationalMapURL = srv.URL
+	cfg.ElevationConfig.DemCache = filepath.Join(dir, "dem")
+	cfg.ElevationConfig.Products = []types.ElevationProduct{types.ThirdArcSecond}
+	assert.Nil(t, DemPrefetch(cfg, st))
+
+	// a dataset never sampled gets the tiles of all its points and is left as is
+	d := testDataset(t, st)
+	exists, err := st.ElevationColumnExists(d)
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, 1, srv.Hits(tnmtest.TiffPath+"USGS_13_n20w156_20130911.tif"))
+	assert.Equal(t, 1, srv.Hits(tnmtest.TiffPath+"USGS_13_n22w158_20130911.tif"))
+}
+
+func TestElevationWorker(t *testing.T) {
+	dir := t.TempDir()
+	xlsPath := filepath.Join(dir, "metadata.xlsx")
+	writeTestMetadata(t, xlsPath)
+	shpA := filepath.Join(dir, "15001.shp")
+	writeTestShp(t, shpA, [][2]float64{{-155.1, 19.7}, {-155.2, 19.8}}, false)
+	shpB := filepath.Join(dir, "15003.shp")
+	writeTestShp(t, shpB, [][2]float64{{-157.8, 21.3}, {-157.9, 21.4}}, false)
+
+	st := store.NewMemStore()
+	assert.Nil(t, Upload(uploadConfig(shpA, xlsPath, types.Fail), st))
+	first := testDataset(t, st)
+	// a dataset loaded without metadata, elevation is filled across the catalog
+	addDataset := func(name string) model.Dataset {
+		d := model.Dataset{Name: name, Version: "0.0.1", SchemaId: first.SchemaId, QualityId: first.QualityId, TableName: "inventory_" + name}
+		assert.Nil(t, st.AddDataset(&d))
+		assert.Nil(t, st.LoadShp(d, shpB, map[string]string{"X": "x", "Y": "y"}, nil, false))
+		return d
+	}
+	second := addDataset("worker2")
+	newElevationSource = func(cfg config.Config) (elevation.Source, error) {
+		return fakeElevation{}, nil
+	}
+	cfg := elevationConfig()
+	cfg.Mode = types.ElevationWorker
+	cfg.DatasetConfig = config.DatasetConfig{}
+	cfg.WorkerConfig.Idle = 5 * time.Millisecond
+	src, err := elevationSource(cfg)
+	assert.Nil(t, err)
+	empty := func(d model.Dataset) bool {
+		e, err := hasEmptyElevation(st, d)
+		assert.Nil(t, err)
+		return e
+	}
+
+	// datasets locked by another worker are skipped
+	locked, err := st.TryLockElevation(first)
+	assert.Nil(t, err)
+	assert.True(t, locked)
+	filled, err := elevationWorkerPass(cfg, st, src, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, filled)
+	assert.True(t, empty(first))
+	assert.False(t, empty(second))
+	assert.Nil(t, st.UnlockElevation(first))
+	filled, err = elevationWorkerPass(cfg, st, src, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, filled)
+	assert.False(t, empty(first))
+	filled, err = elevationWorkerPass(cfg, st, src, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, filled)
+
+	// the worker picks up datasets loaded while it sleeps and returns once stopped
+	stop := make(chan struct{})
+	stopped := make(chan error)
+	go func() {
+		stopped <- ElevationWorker(cfg, st, stop)
+	}()
+	third := addDataset("worker3")
+	for i := 0; i < 200 && empty(third); i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.False(t, empty(third))
+	close(stop)
+	select {
+	case err = <-stopped:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("elevation worker did not stop")
+	}
+	locked, err = st.TryLockElevation(third)
+	assert.Nil(t, err)
+	assert.True(t, locked)
+
+	// a run stopped midway is recorded as such, its empty points are left to the next one
+	fourth := addDataset("worker4")
+	err = fillElevation(cfg, st, src, fourth, stop)
+	assert.Equal(t, errElevationStopped, err)
+	runs, err := st.GetElevationRuns(fourth)
+	assert.Nil(t, err)
+	assert.Len(t, runs, 1)
+	assert.Equal(t, errElevationStopped.Error(), *runs[0].Error)
+}
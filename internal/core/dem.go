@@ -0,0 +1,122 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/config"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/elevation"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/global"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/store"
+)
+
+// DemList prints the tiles of the dem cache
+func DemList(cfg config.Config) error {
+	c := elevation.DemCache{Dir: cfg.ElevationConfig.DemCache}
+	tiles, err := c.Tiles()
+	if err != nil {
+		return err
+	}
+	var total int64
+	fmt.Printf("%-36s %-40s %-20s %-10s %12s  %s\n", "tile", "extent", "product", "published", "size", "last used")
+	for _, t := range tiles {
+		extent, published := "unknown", "unknown"
+		if t.Item != nil {
+			b := t.Item.BoundingBox
+			extent = fmt.Sprintf("%g %g, %g %g", b.MinX, b.MinY, b.MaxX, b.MaxY)
+			published = t.Item.PublicationDate
+		}
+		fmt.Printf(
+			"%-36s %-40s %-20s %-10s %12d  %s\n",
+			t.Name(),
+			extent,
+			t.Product(),
+			published,
+			t.Size,
+			t.LastUsed.Format("2006-01-02 15:04"),
+		)
+		total += t.Size
+	}
+	fmt.Printf("%d tiles, %d bytes in %s\n", len(tiles), total, c.Dir)
+	return nil
+}
+
+// DemVerify reads every tile of the dem cache and reports corrupt or
+// truncated ones, removing them if requested so that they are downloaded again
+func DemVerify(cfg config.Config) error {
+	c := elevation.DemCache{Dir: cfg.ElevationConfig.DemCache}
+	problems, err := c.Verify(cfg.DemConfig.Repair)
+	for _, p := range problems {
+		if cfg.DemConfig.Repair {
+			log.Printf("Removed invalid tile=%s: %s", p.Tile.Name(), p.Err)
+		} else {
+			log.Printf("Invalid tile=%s: %s", p.Tile.Name(), p.Err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 && !cfg.DemConfig.Repair {
+		return errors.New(fmt.Sprintf("%d invalid tiles in %s, rerun with --repair to remove them", len(problems), c.Dir))
+	}
+	log.Printf("Verified dem cache=%s", c.Dir)
+	return nil
+}
+
+// DemPrune evicts the least recently used tiles down to --maxSize
+func DemPrune(cfg config.Config) error {
+	c := elevation.DemCache{Dir: cfg.ElevationConfig.DemCache}
+	evicted, err := c.Prune(cfg.DemConfig.MaxSize)
+	var freed int64
+	for _, t := range evicted {
+		log.Printf("Evicted tile=%s last used %s", t.Name(), t.LastUsed.Format("2006-01-02 15:04"))
+		freed += t.Size
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Pruned %d tiles, %d bytes from dem cache=%s", len(evicted), freed, c.Dir)
+	return nil
+}
+
+// DemPrefetch downloads the tiles covering --bbox, or the tiles covering the
+// empty elevation points of --dataset, or all of its points if it has never
+// been sampled
+func DemPrefetch(cfg config.Config, st store.Store) error {
+	var boxes []elevation.BoundingBox
+	if cfg.DemConfig.BBox != nil {
+		b := cfg.DemConfig.BBox
+		boxes = append(boxes, elevation.BoundingBox{MinX: b[0], MinY: b[1], MaxX: b[2], MaxY: b[3]})
+	} else {
+		d, err := getDataset(cfg, st)
+		if err != nil {
+			return err
+		}
+		elevColumnExists, err := st.ElevationColumnExists(d)
+		if err != nil {
+			return err
+		}
+		// prefetch only reads the inventory, a dataset never sampled needs every point
+		if elevColumnExists {
+			boxes, err = st.GetEmptyElevationCells(d, global.ELEVATION_TILE_SIZE)
+		} else {
+			boxes, err = st.GetPointCells(d, global.ELEVATION_TILE_SIZE)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	nm := elevation.NationalMap{
+		BaseURL:     cfg.ElevationConfig.NationalMapURL,
+		CacheDir:    cfg.ElevationConfig.DemCache,
+		RequestRate: cfg.ElevationConfig.RequestRate,
+		Products:    cfg.ElevationConfig.Products,
+	}
+	downloaded, cached, err := elevation.Prefetch(nm, boxes)
+	if err != nil {
+		return err
+	}
+	log.Printf("Prefetched %d tiles into dem cache=%s, %d already cached", downloaded, nm.CacheDir, cached)
+	return nil
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/config"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/model"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/store"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+)
+
+// attributePattern matches the polygon attributes mod enrich reads, which keep
+// the case of the source layer
+var attributePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// EnrichInventory writes the attribute of the polygons each point of a dataset
+// falls in, e.g. the flood zone of a FIRM, into a column registered with the
+// schema of the dataset. A missing column is added as text. Points in no
+// polygon or in polygons that disagree on the attribute keep their value and
+// are reported
+func EnrichInventory(cfg config.Config, st store.Store) error {
+	ec := cfg.EnrichConfig
+	if !tableNamePattern.MatchString(ec.Column) {
+		return errors.New(fmt.Sprintf("invalid column=%s, use a lowercase identifier", ec.Column))
+	}
+	for _, c := range reservedColumns {
+		if c == ec.Column {
+			return errors.New(fmt.Sprintf("column=%s is maintained by sael, pick another --column", ec.Column))
+		}
+	}
+	if !attributePattern.MatchString(ec.Attribute) {
+		return errors.New(fmt.Sprintf("invalid attribute=%s", ec.Attribute))
+	}
+	if _, err := os.Stat(ec.Polygons); err != nil {
+		return errors.New(fmt.Sprintf("unable to read polygons=%s: %s", ec.Polygons, err))
+	}
+	d, err := getDataset(cfg, st)
+	if err != nil {
+		return err
+	}
+	columns, err := st.GetInventoryColumns(d)
+	if err != nil {
+		return err
+	}
+	columnType := "text"
+	fieldType := types.Char
+	if t, ok := columns[ec.Column]; ok {
+		fieldType, ok = types.DatatypePostgres[t]
+		if !ok {
+			return errors.New(fmt.Sprintf("column=%s of table=%s holds %s, pick another --column", ec.Column, d.TableName, t))
+		}
+		columnType = t
+	}
+	err = registerField(st, d, model.Field{
+		DbName:      ec.Column,
+		Type:        fieldType,
+		Description: fmt.Sprintf("%s of the polygons of %s", ec.Attribute, filepath.Base(ec.Polygons)),
+	})
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	r, err := st.EnrichFromPolygons(d, model.PolygonLayer{
+		Path:      ec.Polygons,
+		Layer:     ec.Layer,
+		Attribute: ec.Attribute,
+	}, ec.Column, columnType)
+	if err != nil {
+		return err
+	}
+	log.Printf(
+		"Enriched column=%s of dataset=%s from %s of polygons=%s in %s: updated=%d no_polygon=%d several_polygons=%d conflicting=%d",
+		ec.Column,
+		d.Name,
+		ec.Attribute,
+		ec.Polygons,
+		time.Since(start).Round(time.Millisecond),
+		r.Updated,
+		r.NoMatch,
+		r.Multiple,
+		r.Conflicting,
+	)
+	if r.NoMatch > 0 {
+		log.Printf("fd_ids in no polygon: %v", r.NoMatchIds)
+	}
+	if r.Multiple > 0 {
+		log.Printf("fd_ids in several polygons, left unchanged where they disagree: %v", r.MultipleIds)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/config"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/elevation"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/global"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/model"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/store"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+)
+
+// ElevationQA flags the ground elevations of a dataset that differ by more
+// than --threshold from the median of the structures within --radius, or from
+// the median of the DEM at and around the structure, and replaces the review
+// rows of the dataset with them. Points are read a DEM tile sized cell at a
+// time, with the neighbors of points near the cell edge read from a buffer
+func ElevationQA(cfg config.Config, st store.Store) error {
+	d, err := getDataset(cfg, st)
+	if err != nil {
+		return err
+	}
+	elevColumnExists, err := st.ElevationColumnExists(d)
+	if err != nil {
+		return err
+	}
+	if !elevColumnExists {
+		return errors.New(fmt.Sprintf("dataset=%s has no elevation to review, run mod elevation first", d.Name))
+	}
+	// the DEM is compared in the unit and datum ground_elev was written in
+	err = checkElevationField(cfg, st)
+	if err != nil {
+		return err
+	}
+	src, err := elevationSource(cfg)
+	if err != nil {
+		return err
+	}
+	cells, err := st.GetElevationCells(d, global.ELEVATION_TILE_SIZE)
+	if err != nil {
+		return err
+	}
+
+	radius, threshold := cfg.QAConfig.Radius, cfg.QAConfig.Threshold
+	now := time.Now()
+	var reviews []model.ElevationReview
+	var checked int
+	flag := func(p *elevation.Point, check types.ElevationCheck, expected float64, samples int) {
+		diff := *p.Elevation - expected
+		if samples < global.ELEVATION_QA_MIN_SAMPLES || math.Abs(diff) <= threshold {
+			return
+		}
+		reviews = append(reviews, model.ElevationReview{
+			DatasetId:  d.Id,
+			FdId:       p.FdId,
+			X:          p.X,
+			Y:          p.Y,
+			Elevation:  *p.Elevation,
+			Check:      check,
+			Expected:   expected,
+			Difference: diff,
+			Samples:    samples,
+			Created:    now,
+		})
+	}
+	for _, cell := range cells {
+		all, err := st.GetElevationPoints(d, cell.Buffer(radius))
+		if err != nil {
+			return err
+		}
+		var points elevation.Points
+		for _, p := range all {
+			if p.X >= cell.MinX && p.X < cell.MaxX && p.Y >= cell.MinY && p.Y < cell.MaxY {
+				points = append(points, p)
+			}
+		}
+		checked += len(points)
+
+		medians, counts := elevation.NeighborMedians(points, all, radius)
+		for i, p := range points {
+			flag(p, types.NeighborCheck, medians[i], counts[i])
+		}
+
+		medians, counts, err = demMedians(src, points)
+		if err != nil {
+			return err
+		}
+		for i, p := range points {
+			flag(p, types.DemCheck, medians[i], counts[i])
+		}
+	}
+
+	err = st.SaveElevationReviews(d, reviews)
+	if err != nil {
+		return err
+	}
+	log.Printf(
+		"Checked %d elevations of dataset=%s, flagged %d for review over %g within %g meters",
+		checked, d.Name, len(reviews), threshold, radius,
+	)
+	return nil
+}
+
+// demMedians samples the DEM at each point and at ELEVATION_QA_DEM_RADIUS
+// meters around it, and returns the median of the samples with a value and
+// their count
+func demMedians(src elevation.Source, points elevation.Points) ([]float64, []int, error) {
+	medians := make([]float64, len(points))
+	counts := make([]int, len(points))
+	const perPoint = 9
+	step := global.ELEVATION_BATCHSIZE / perPoint
+	for lo := 0; lo < len(points); lo += step {
+		hi := lo + step
+		if hi > len(points) {
+			hi = len(points)
+		}
+		var samples elevation.Points
+		for _, p := range points[lo:hi] {
+			samples = append(samples, &elevation.Point{FdId: p.FdId, X: p.X, Y: p.Y})
+			samples = append(samples, elevation.Ring(*p, global.ELEVATION_QA_DEM_RADIUS)...)
+		}
+		err := src.GetElevation(samples)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := lo; i < hi; i++ {
+			var v []float64
+			for _, s := range samples[(i-lo)*perPoint : (i-lo+1)*perPoint] {
+				if !s.NilElevation() {
+					v = append(v, *s.Elevation)
+				}
+			}
+			medians[i] = elevation.Median(v)
+			counts[i] = len(v)
+		}
+	}
+	return medians, counts, nil
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/config"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/elevation"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/global"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/model"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/store"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+	"github.com/google/uuid"
+)
+
+// reservedColumns are maintained by upload, adopt and mod elevation
+var reservedColumns = []string{
+	"fd_id", "x", "y", "shape",
+	global.ELEVATION_COLUMN_NAME,
+	global.ELEVATION_REASON_COLUMN_NAME,
+	global.ELEVATION_SOURCE_COLUMN_NAME,
+	global.ELEVATION_RES_COLUMN_NAME,
+	global.ELEVATION_PRODUCT_COLUMN_NAME,
+}
+
+// numericColumnTypes are the postgres types a raster column may already hold
+var numericColumnTypes = []string{"double precision", "real", "numeric", "integer", "bigint", "smallint"}
+
+// SampleRaster samples a band of a local raster, e.g. a flood depth grid, land
+// cover or a hazard surface, at the x, y of every point of a dataset into a
+// double precision column registered as a float field. Points outside the
+// raster or on nodata are set to null, so a rerun replaces every value of the
+// points with x, y. Points without x, y are skipped and counted
+func SampleRaster(cfg config.Config, st store.Store) error {
+	column := cfg.RasterConfig.Column
+	if !tableNamePattern.MatchString(column) {
+		return errors.New(fmt.Sprintf("invalid column=%s, use a lowercase identifier", column))
+	}
+	for _, c := range reservedColumns {
+		if c == column {
+			return errors.New(fmt.Sprintf("column=%s is maintained by sael, pick another --column", column))
+		}
+	}
+	d, err := getDataset(cfg, st)
+	if err != nil {
+		return err
+	}
+	columns, err := st.GetInventoryColumns(d)
+	if err != nil {
+		return err
+	}
+	if t, ok := columns[column]; ok && !numericColumn(t) {
+		return errors.New(fmt.Sprintf("column=%s of table=%s holds %s, pick another --column", column, d.TableName, t))
+	}
+	src, err := elevation.NewLocalBandSource(cfg.RasterConfig.Raster, cfg.RasterConfig.Band, cfg.RasterConfig.Sampling)
+	if err != nil {
+		return err
+	}
+	err = registerField(st, d, model.Field{
+		DbName:      column,
+		Type:        types.Float,
+		Description: fmt.Sprintf("sampled from band %d of %s", cfg.RasterConfig.Band, filepath.Base(cfg.RasterConfig.Raster)),
+	})
+	if err != nil {
+		return err
+	}
+	err = st.AddRasterColumn(d, column)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	skipped, err := st.CountNullCoordinates(d)
+	if err != nil {
+		return err
+	}
+	var filled, empty int
+	after := 0
+	for {
+		points, err := st.GetPoints(d, after, global.ELEVATION_BATCHSIZE)
+		if err != nil {
+			return err
+		}
+		if len(points) == 0 {
+			break
+		}
+		after = points[len(points)-1].FdId
+		err = src.GetElevation(points)
+		if err != nil {
+			return err
+		}
+		for _, p := range points {
+			if p.NilElevation() {
+				empty++
+			} else {
+				filled++
+			}
+		}
+		err = st.UpdateColumnAtPoint(d, column, points)
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf(
+		"Sampled band=%d of raster=%s into column=%s of dataset=%s in %s: filled=%d null=%d skipped=%d (no x, y)",
+		cfg.RasterConfig.Band,
+		cfg.RasterConfig.Raster,
+		column,
+		d.Name,
+		time.Since(start).Round(time.Millisecond),
+		filled,
+		empty,
+		skipped,
+	)
+	return nil
+}
+
+// registerField registers a column written by sael as a field of the schema of
+// the dataset. A field of the same name under another type is rejected like
+// the fail field conflict policy of uploads
+func registerField(st store.Store, d model.Dataset, f model.Field) error {
+	err := st.GetFieldId(&f)
+	if err != nil {
+		return err
+	}
+	if f.Id == uuid.Nil {
+		existing, err := st.GetFieldsByName(f)
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			return errors.New(fmt.Sprintf(
+				"field=%s is registered as %s, pick another --column for a %s column",
+				f.DbName, existing[0].Type, f.Type,
+			))
+		}
+		err = st.AddField(&f)
+		if err != nil {
+			return err
+		}
+	}
+	sf := model.SchemaField{Id: d.SchemaId, NsiFieldId: f.Id}
+	exists, err := st.SchemaFieldAssociationExists(sf)
+	if err != nil || exists {
+		return err
+	}
+	return st.AddSchemaFieldAssociation(sf)
+}
+
+func numericColumn(t string) bool {
+	for _, n := range numericColumnTypes {
+		if strings.EqualFold(t, n) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,315 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/elevation"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/global"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/model"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/store"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+)
+
+// elevationRetryBackoff is the wait before the first retry of a failed batch,
+// doubled on every further retry of the same batch. Shortened in tests
+var elevationRetryBackoff = time.Second
+
+// errElevationStopped ends a run whose stop channel was closed, the points it
+// didn't reach are left empty for the next run
+var errElevationStopped = errors.New("elevation run stopped")
+
+// maxReportedMismatches caps the points logged with a shape that disagrees
+// with their x, y columns, all of them are counted
+const maxReportedMismatches = 20
+
+// elevationScheduler fills the empty elevation points of a dataset partition
+// by partition with a bounded number of workers. Each partition is paged by
+// fd_id, so a batch is never read twice and every point read is written back
+// with either an elevation or a reason, which guarantees that a run ends.
+// Failed batches are retried from a budget shared by all workers, the first
+// error past the budget stops the run. With geometry set points are read
+// from their shape and reprojected to lon/lat before sampling. Closing stop
+// ends the run like an error once the batches in progress are written. With
+// where set the points it selects are resampled instead of the empty points,
+// only new elevations are written and the other points keep their values
+type elevationScheduler struct {
+	st        store.Store
+	src       elevation.Source
+	d         model.Dataset
+	batchSize int
+	workers   int
+	retries   int64 // remaining retry budget, shared by all workers
+	geometry  bool
+	where     *elevation.Filter
+	stop      <-chan struct{}
+
+	filled     int64
+	noData     int64
+	noCoverage int64
+	unresolved int64
+	invalid    int64 // no datum shift, no geometry or an invalid srid
+	mismatched int64
+}
+
+func newElevationScheduler(st store.Store, src elevation.Source, d model.Dataset, workers int, retries int) *elevationScheduler {
+	return &elevationScheduler{
+		st:        st,
+		src:       src,
+		d:         d,
+		batchSize: global.ELEVATION_BATCHSIZE,
+		workers:   workers,
+		retries:   int64(retries),
+	}
+}
+
+// partitions splits the empty or selected points of the dataset into DEM
+// tile cells or fd_id ranges of one batch each
+func (s *elevationScheduler) partitions(p types.ElevationPartition) ([]elevation.Partition, error) {
+	var parts []elevation.Partition
+	if p == types.TilePartition {
+		var cells []elevation.BoundingBox
+		var err error
+		if s.where != nil {
+			cells, err = s.st.GetSelectedElevationCells(s.d, global.ELEVATION_TILE_SIZE, *s.where)
+		} else {
+			cells, err = s.st.GetEmptyElevationCells(s.d, global.ELEVATION_TILE_SIZE)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := range cells {
+			parts = append(parts, elevation.Partition{Cell: &cells[i]})
+		}
+		return parts, nil
+	}
+	var count, minFdId, maxFdId int
+	var err error
+	if s.where != nil {
+		count, minFdId, maxFdId, err = s.st.GetSelectedElevationFdIdRange(s.d, *s.where)
+	} else {
+		count, minFdId, maxFdId, err = s.st.GetEmptyElevationFdIdRange(s.d)
+	}
+	if err != nil || count == 0 {
+		return nil, err
+	}
+	for lo := minFdId; lo <= maxFdId; lo += s.batchSize {
+		parts = append(parts, elevation.Partition{MinFdId: lo, MaxFdId: lo + s.batchSize})
+	}
+	return parts, nil
+}
+
+// run processes the partitions and returns the first error that exhausted the
+// retry budget, or errElevationStopped. Workers stop picking up partitions once
+// an error occurred
+func (s *elevationScheduler) run(parts []elevation.Partition) error {
+	start := time.Now()
+	work := make(chan elevation.Partition)
+	done := make(chan struct{})
+	var once sync.Once
+	var runErr error
+	fail := func(err error) {
+		once.Do(func() {
+			runErr = err
+			close(done)
+		})
+	}
+	finished := make(chan struct{})
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		select {
+		case <-s.stop:
+			fail(errElevationStopped)
+		case <-finished:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range work {
+				err := s.runPartition(part, done)
+				if err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+feed:
+	for _, part := range parts {
+		select {
+		case work <- part:
+		case <-done:
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	close(finished)
+	<-watched
+
+	elapsed := time.Since(start)
+	total := s.filled + s.noData + s.noCoverage + s.unresolved + s.invalid
+	log.Printf(
+		"Elevation run for dataset=%s over %d partitions in %s (%.0f points/s): filled=%d %s=%d %s=%d %s=%d invalid=%d mismatched=%d",
+		s.d.Name,
+		len(parts),
+		elapsed.Round(time.Millisecond),
+		float64(total)/elapsed.Seconds(),
+		s.filled,
+		types.NoData,
+		s.noData,
+		types.NoCoverage,
+		s.noCoverage,
+		types.Unresolved,
+		s.unresolved,
+		s.invalid,
+		s.mismatched,
+	)
+	return runErr
+}
+
+// runPartition pages through the empty or selected points of a partition in
+// fd_id order
+func (s *elevationScheduler) runPartition(part elevation.Partition, done <-chan struct{}) error {
+	after := part.MinFdId - 1
+	for {
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+		var points elevation.Points
+		err := s.retry(part, "read", func() error {
+			var err error
+			switch {
+			case s.where != nil && s.geometry:
+				points, err = s.st.GetSelectedElevationGeometries(s.d, *s.where, part, after, s.batchSize)
+			case s.where != nil:
+				points, err = s.st.GetSelectedElevationPoints(s.d, *s.where, part, after, s.batchSize)
+			case s.geometry:
+				points, err = s.st.GetEmptyElevationGeometries(s.d, part, after, s.batchSize)
+			default:
+				points, err = s.st.GetEmptyElevationPoints(s.d, part, after, s.batchSize)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(points) == 0 {
+			return nil
+		}
+		after = points[len(points)-1].FdId
+		err = s.resolveBatch(part, points)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// resolveBatch samples and writes a batch. Points the source returns neither
+// a value nor a reason for are recorded as unresolved so that they are not
+// picked up again. Resampled points are written only if they got a value
+func (s *elevationScheduler) resolveBatch(part elevation.Partition, points elevation.Points) error {
+	if s.where != nil {
+		// sampled afresh, the stored values stay until a new one is written
+		for _, p := range points {
+			p.Elevation = nil
+			p.Reason = ""
+			p.Tile = ""
+			p.Resolution = nil
+			p.Product = ""
+		}
+	}
+	if s.geometry {
+		elevation.Geographic(points)
+		s.checkCoordinates(points)
+	}
+	err := s.retry(part, "sampling", func() error {
+		return s.src.GetElevation(points)
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		if !p.Resolved() {
+			p.Reason = types.Unresolved
+		}
+	}
+	write := points
+	if s.where != nil {
+		write = nil
+		for _, p := range points {
+			if !p.NilElevation() {
+				write = append(write, p)
+			}
+		}
+	}
+	err = s.retry(part, "update", func() error {
+		return s.st.UpdateElevationAtPoint(s.d, write)
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		switch {
+		case !p.NilElevation():
+			atomic.AddInt64(&s.filled, 1)
+		case p.Reason == types.NoData:
+			atomic.AddInt64(&s.noData, 1)
+		case p.Reason == types.NoCoverage:
+			atomic.AddInt64(&s.noCoverage, 1)
+		case p.Reason == types.Unresolved:
+			atomic.AddInt64(&s.unresolved, 1)
+		default:
+			atomic.AddInt64(&s.invalid, 1)
+		}
+	}
+	return nil
+}
+
+// checkCoordinates reports points whose shape is more than
+// ELEVATION_COORD_TOLERANCE meters off their x, y columns
+func (s *elevationScheduler) checkCoordinates(points elevation.Points) {
+	for _, p := range points {
+		off := p.Disagreement()
+		if off <= global.ELEVATION_COORD_TOLERANCE {
+			continue
+		}
+		if atomic.AddInt64(&s.mismatched, 1) <= maxReportedMismatches {
+			log.Printf(
+				"fd_id=%d of dataset=%s has x, y=%g, %g but its shape is at %g, %g, %.0f meters away",
+				p.FdId, s.d.Name, *p.AttrX, *p.AttrY, p.X, p.Y, off,
+			)
+		}
+	}
+}
+
+// retry calls f until it succeeds or the retry budget is exhausted
+func (s *elevationScheduler) retry(part elevation.Partition, op string, f func() error) error {
+	backoff := elevationRetryBackoff
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if atomic.AddInt64(&s.retries, -1) < 0 {
+			return errors.New(fmt.Sprintf("elevation %s failed for dataset=%s %s, retry budget exhausted: %s", op, s.d.Name, part, err))
+		}
+		log.Printf("Elevation %s failed for dataset=%s %s, retrying in %s: %s", op, s.d.Name, part, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-s.stop:
+			return errElevationStopped
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/config"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/elevation"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/model"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/store"
+)
+
+// ElevationWorker fills the empty elevation points of every dataset in the
+// catalog until stop is closed. A dataset is filled by one worker at a time,
+// the others skip it while its advisory lock is held. Once a pass over the
+// catalog leaves nothing to fill the worker sleeps for --idle. Failures are
+// logged and the dataset is tried again on the next pass
+func ElevationWorker(cfg config.Config, st store.Store, stop <-chan struct{}) error {
+	src, err := elevationSource(cfg)
+	if err != nil {
+		return err
+	}
+	log.Printf("Elevation worker started, polling the catalog every %s once it is filled", cfg.WorkerConfig.Idle)
+	for {
+		filled, err := elevationWorkerPass(cfg, st, src, stop)
+		if err == errElevationStopped {
+			break
+		}
+		if err != nil {
+			log.Printf("Elevation worker pass failed: %s", err)
+		}
+		// datasets loaded during a pass that filled some are picked up right away
+		if err == nil && filled > 0 {
+			continue
+		}
+		stopped := false
+		select {
+		case <-stop:
+			stopped = true
+		case <-time.After(cfg.WorkerConfig.Idle):
+		}
+		if stopped {
+			break
+		}
+	}
+	log.Print("Elevation worker stopped")
+	return nil
+}
+
+// elevationWorkerPass fills the datasets with empty elevation points that no
+// other worker holds and returns how many were filled without an error
+func elevationWorkerPass(cfg config.Config, st store.Store, src elevation.Source, stop <-chan struct{}) (int, error) {
+	datasets, err := st.GetDatasets()
+	if err != nil {
+		return 0, err
+	}
+	filled := 0
+	for _, d := range datasets {
+		select {
+		case <-stop:
+			return filled, errElevationStopped
+		default:
+		}
+		empty, err := hasEmptyElevation(st, d)
+		if err != nil {
+			log.Printf("Unable to check the elevation of dataset=%s version=%s: %s", d.Name, d.Version, err)
+			continue
+		}
+		if !empty {
+			continue
+		}
+		locked, err := st.TryLockElevation(d)
+		if err != nil {
+			log.Printf("Unable to lock dataset=%s version=%s: %s", d.Name, d.Version, err)
+			continue
+		}
+		if !locked {
+			continue
+		}
+		log.Printf("Elevation worker filling dataset=%s version=%s table=%s", d.Name, d.Version, d.TableName)
+		err = fillElevation(cfg, st, src, d, stop)
+		unlockErr := st.UnlockElevation(d)
+		if unlockErr != nil {
+			log.Printf("Unable to unlock dataset=%s version=%s: %s", d.Name, d.Version, unlockErr)
+		}
+		if err == errElevationStopped {
+			return filled, err
+		}
+		if err != nil {
+			log.Printf("Elevation worker failed to fill dataset=%s version=%s: %s", d.Name, d.Version, err)
+			continue
+		}
+		filled++
+	}
+	return filled, nil
+}
+
+// hasEmptyElevation is true if d has points without an elevation or a reason,
+// or has never been sampled
+func hasEmptyElevation(st store.Store, d model.Dataset) (bool, error) {
+	exists, err := st.ElevationColumnExists(d)
+	if err != nil || !exists {
+		return !exists, err
+	}
+	count, _, _, err := st.GetEmptyElevationFdIdRange(d)
+	return count > 0, err
+}
+
+// stopOnSignal returns a channel closed on the first SIGTERM or interrupt, a
+// second one kills the process
+func stopOnSignal() <-chan struct{} {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	stop := make(chan struct{})
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		log.Printf("Received %s, stopping once the batches in progress are written", sig)
+		close(stop)
+	}()
+	return stop
+}
@@ -1,199 +1,149 @@
 package elevation
 
 import (
-	"errors"
-	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"math"
 	"os"
-	"strings"
+	"path/filepath"
 
 	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/global"
-	"github.com/usace/filestore"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
 )
 
-type cacheItem filestore.FileStoreResultObject
-
-// TODO maybe spin this out into a general lib, seems useful
-
 // ElevationAccessor acts as a caching service around the National Map API and
-// the local filestore. The accessor query the National Map service for a list
-// of relevant files based on a BoundingBox generated from a set of Points.
-// If the files are already available in the localCache, then it uses that data.
-// Otherwise, the accessor downloads to localCache before loading.
+// the local tile cache. Points are looked up in the persistent tile index of
+// the cache first, the National Map is only queried for points that no cached
+// tile covers, and the tiles it returns are downloaded and indexed.
 type ElevationAccessor struct {
-	queryResult QueryResult
-	localCache  filestore.FileStore
-	cacheObjs   *[]cacheItem // this wrangling is so convoluted TODO maybe refactor
+	nationalMap NationalMap
+	sampling    types.SamplingMethod
+	index       *tileIndex
 }
 
-func NewElevationAccessor(b BoundingBox) (ElevationAccessor, error) {
-	mq := NewNationalMapQuery()
-	q, err := mq.QueryBoundingBox(b)
-	if err != nil {
-		return ElevationAccessor{}, err
-	}
-	localFS, err := filestore.NewFileStore(filestore.BlockFSConfig{})
+func NewElevationAccessor(nm NationalMap, sampling types.SamplingMethod) (ElevationAccessor, error) {
+	err := os.MkdirAll(nm.cacheDir(), 0755)
 	if err != nil {
 		return ElevationAccessor{}, err
 	}
-	e := ElevationAccessor{
-		queryResult: q,
-		localCache:  localFS,
-	}
-	err = e.refreshCacheObjs()
+	index, err := openTileIndex(nm.CacheDir)
 	if err != nil {
 		return ElevationAccessor{}, err
 	}
-	return e, nil
+	return ElevationAccessor{
+		nationalMap: nm,
+		sampling:    sampling,
+		index:       index,
+	}, nil
 }
 
-// GetElevation fills the nil Elevation field for each point
+// GetElevation fills the nil Elevation field for each point from the first
+// product of the National Map that has a value for it. Points that are nodata
+// in every product covering them are marked with the NoData reason, points
+// outside of every tile of every product with the NoCoverage reason
 func (e *ElevationAccessor) GetElevation(p Points) error {
-	// loop through all available items and download relevant file to local cache
-	for _, i := range e.queryResult.Items {
-		// filter for only USGS 1/3 arc-second dataset
-		if strings.Contains(i.Title, "USGS 13 arc-second") {
-			existsInCache, err := e.cacheContains(i)
+	var pending Points
+	for _, point := range p {
+		if !point.Resolved() {
+			pending = append(pending, point)
+		}
+	}
+	noData := map[*Point]bool{}
+	cache := DemCache{Dir: e.nationalMap.CacheDir}
+	for _, product := range e.nationalMap.products() {
+		if len(pending) == 0 {
+			break
+		}
+		_, uncovered := e.index.rasters(pending, product)
+		if len(uncovered) > 0 {
+			err := e.downloadData(uncovered, product)
 			if err != nil {
 				return err
 			}
-			if p.IsIntersecting(i) && !existsInCache {
-				log.Print("Downloading data from: " + i.DownloadURL)
-				// errs <- e.downloadData(i)
-				err := e.downloadData(i)
-				if err != nil {
-					return err
-				}
-			}
 		}
-	}
-	// loop through all cachedItem TIFF
-	for _, cacheItem := range *e.cacheObjs {
-		i, err := e.getItemFromCacheItem(cacheItem)
-		if err != nil {
-			return err
-		}
-		// intersect points relevant for each cacheItem TIFF
-		intersectedPoints := i.BoundingBox.Intersect(p)
-		cachedKey, err := i.cacheKey()
-		if err != nil {
-			return err
+		// sample the tiles of the batch together so that tile overlaps are resolved
+		rasters, _ := e.index.rasters(pending, product)
+		for _, r := range rasters {
+			// stamp the tiles of the batch for least recently used eviction
+			cache.touch(r.path)
 		}
-		g, err := newGDALAccessor(cachedKey)
+		err := sampleRasters(rasters, 1, pending, e.sampling)
 		if err != nil {
 			return err
 		}
-		defer g.close()
-		// populate elevation data for each point
-		for _, point := range intersectedPoints {
-			if point.NilElevation() {
-				// boxed pointer - a trick from rust
-				err = g.calculateElevation(i.BoundingBox, point)
-				if err != nil {
-					return err
-				}
+		var next Points
+		for _, point := range pending {
+			switch {
+			case !point.NilElevation():
+				point.Product = product
+			case point.Reason == types.NoData:
+				// void in this product, try the next one
+				point.Product = product
+				point.Reason = ""
+				noData[point] = true
+				next = append(next, point)
+			default:
+				next = append(next, point)
 			}
 		}
+		pending = next
+	}
+	for _, point := range pending {
+		if noData[point] {
+			point.Reason = types.NoData
+		} else {
+			point.Reason = types.NoCoverage
+		}
 	}
 	return nil
 }
 
-// getItemFromCacheItem finds the corresponding Item obj from cacheItem
-func (e *ElevationAccessor) getItemFromCacheItem(c cacheItem) (Item, error) {
-	// TODO refactor this func
-	for _, i := range e.queryResult.Items {
-		cachedKey, err := i.cacheKey()
-		if err != nil {
-			return Item{}, err
+// downloadData queries the National Map for the points no cached tile of a
+// product covers and downloads the tiles of the product holding them to the cache. Queries are sent
+// per tile sized cell so that later batches in the same cell reuse the cached
+// query result
+func (e *ElevationAccessor) downloadData(p Points, product types.ElevationProduct) error {
+	cells := map[[2]float64]Points{}
+	var keys [][2]float64
+	for _, point := range p {
+		k := [2]float64{
+			math.Floor(point.X/global.ELEVATION_TILE_SIZE) * global.ELEVATION_TILE_SIZE,
+			math.Floor(point.Y/global.ELEVATION_TILE_SIZE) * global.ELEVATION_TILE_SIZE,
 		}
-		if cachedKey == (c.Path + c.Name) {
-			return i, nil
+		if _, ok := cells[k]; !ok {
+			keys = append(keys, k)
 		}
+		cells[k] = append(cells[k], point)
 	}
-	// if not found in current QueryResult, requery
-	mq := NewNationalMapQuery()
-	tokens := strings.Split(c.Name, "_")
-	q, err := mq.QueryName(tokens[len(tokens)-2])
-	if err != nil {
-		return Item{}, err
-	}
-	for _, i := range q.Items {
-		cachedKey, err := i.cacheKey()
+	for _, k := range keys {
+		mq, err := NewNationalMapQuery(e.nationalMap, product)
 		if err != nil {
-			return Item{}, err
+			return err
 		}
-		if cachedKey == (c.Path + c.Name) {
-			return i, nil
+		q, err := mq.QueryBoundingBox(BoundingBox{
+			MinX: k[0],
+			MaxX: k[0] + global.ELEVATION_TILE_SIZE,
+			MinY: k[1],
+			MaxY: k[1] + global.ELEVATION_TILE_SIZE,
+		})
+		if err != nil {
+			return err
+		}
+		for _, i := range q.Items {
+			// the API returns items of related datasets as well
+			if i.Product() != product || !cells[k].IsIntersecting(i) {
+				continue
+			}
+			cachedKey, err := i.cacheKey(e.nationalMap)
+			if err != nil {
+				return err
+			}
+			if e.index.contains(filepath.Base(cachedKey)) {
+				continue
+			}
+			err = e.nationalMap.download(i)
+			if err != nil {
+				return err
+			}
 		}
-	}
-	return Item{}, errors.New(fmt.Sprintf("No QueryResult Item located at: %s", c.Path))
-}
-
-// refreshCacheObjs keeps an index cache in memory during app lifetime
-// rather than querying everytime there's a need and slowing down performance
-func (e *ElevationAccessor) refreshCacheObjs() error {
-	o, err := e.localCache.GetDir(global.NATIONAL_MAP_CACHE_BASEPATH, false)
-	if err != nil {
-		return err
-	}
-	var flush []cacheItem
-	for _, i := range *o {
-		// coerce to the new alias type
-		coerced := cacheItem(i)
-		flush = append(flush, coerced)
-	}
-	e.cacheObjs = &flush
-	if err != nil {
-		return err
-	}
-	return err
-}
-
-// downloadData sends out a get request to the National Map API
-// and download data to localCache
-func (e *ElevationAccessor) downloadData(i Item) error {
-	cachedKey, err := i.cacheKey()
-	if err != nil {
-		return err
-	}
-	out, err := os.Create(cachedKey)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-	resp, err := http.Get(i.DownloadURL)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return err
-	}
-	// s, err := os.Stat(cachedKey)
-	if err != nil {
-		return err
-	}
-	// if s.Size() != int64(i.SizeInBytes) {
-	// 	return errors.New("File download unsuccessfully")
-	// }
-	err = e.refreshCacheObjs()
-	if err != nil {
-		return err
 	}
 	return nil
 }
-
-// cacheContains returns true if item is already downloaded and in local cache
-func (e *ElevationAccessor) cacheContains(i Item) (bool, error) {
-	cachedKey, err := i.cacheKey()
-	if err != nil {
-		return false, err
-	}
-	if _, err := os.Stat(cachedKey); errors.Is(err, os.ErrNotExist) {
-		return false, nil
-	}
-	return true, nil
-}
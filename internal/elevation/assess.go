@@ -0,0 +1,54 @@
+package elevation
+
+import (
+	"math"
+	"sort"
+)
+
+// Accuracy summarizes the differences of sampled from surveyed elevations
+type Accuracy struct {
+	Count   int     // benchmarks
+	Sampled int     // benchmarks the source holds a value at
+	RMSE    float64 // root mean square of the differences
+	Bias    float64 // mean of the differences, positive if the source reads high
+	P50     float64 // percentiles of the absolute differences
+	P90     float64
+	P95     float64
+}
+
+// NewAccuracy summarizes the differences sampled minus surveyed of count
+// benchmarks, benchmarks without a sample have no difference
+func NewAccuracy(count int, diffs []float64) Accuracy {
+	a := Accuracy{Count: count, Sampled: len(diffs)}
+	if len(diffs) == 0 {
+		a.RMSE, a.Bias, a.P50, a.P90, a.P95 = math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN()
+		return a
+	}
+	abs := make([]float64, len(diffs))
+	var sum, sumSq float64
+	for i, d := range diffs {
+		sum += d
+		sumSq += d * d
+		abs[i] = math.Abs(d)
+	}
+	a.Bias = sum / float64(len(diffs))
+	a.RMSE = math.Sqrt(sumSq / float64(len(diffs)))
+	sort.Float64s(abs)
+	a.P50 = Percentile(abs, 50)
+	a.P90 = Percentile(abs, 90)
+	a.P95 = Percentile(abs, 95)
+	return a
+}
+
+// Percentile p of sorted, interpolated linearly between the closest ranks
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	r := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(r))
+	if lo >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lo] + (r-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
@@ -0,0 +1,297 @@
+package elevation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/global"
+)
+
+// tileMetaExt is appended to the path of a cached tile for the sidecar file
+// holding the TNM item the tile was downloaded for
+const tileMetaExt = ".json"
+
+// DemCache is the directory National Map tiles are downloaded to. The
+// sidecars of the tiles are stamped with the time the tile was last sampled
+// (their modification time), which is the order tiles are evicted in. The
+// tiles themselves are left untouched so that open handles stay valid
+type DemCache struct {
+	Dir string
+}
+
+// CachedTile is a raster in the DemCache
+type CachedTile struct {
+	Path     string
+	Size     int64
+	LastUsed time.Time
+	Item     *Item // nil if the sidecar is missing or unreadable
+}
+
+func (t CachedTile) Name() string {
+	return filepath.Base(t.Path)
+}
+
+// Product is the elevation product of the tile, e.g. 1/3
+func (t CachedTile) Product() string {
+	if t.Item == nil || t.Item.Product() == "" {
+		return "unknown"
+	}
+	return string(t.Item.Product())
+}
+
+// TileProblem is a tile that failed verification
+type TileProblem struct {
+	Tile CachedTile
+	Err  error
+}
+
+// Tiles lists the rasters in the cache ordered by name. Partial downloads are skipped
+func (c DemCache) Tiles() ([]CachedTile, error) {
+	entries, err := os.ReadDir(c.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tiles []CachedTile
+	for _, e := range entries {
+		if e.IsDir() || !isLocalRaster(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		t := CachedTile{
+			Path:     filepath.Join(c.Dir, e.Name()),
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+		}
+		if meta, err := os.Stat(t.Path + tileMetaExt); err == nil {
+			t.LastUsed = meta.ModTime()
+		}
+		t.Item, err = readTileMeta(t.Path)
+		if err != nil {
+			log.Printf("Ignoring metadata of tile=%s: %s", t.Name(), err)
+		}
+		tiles = append(tiles, t)
+	}
+	return tiles, nil
+}
+
+// Verify opens and reads every tile in full. Tiles that can't be read or
+// whose size differs from the TNM item are reported, and removed with repair
+func (c DemCache) Verify(repair bool) ([]TileProblem, error) {
+	tiles, err := c.Tiles()
+	if err != nil {
+		return nil, err
+	}
+	var problems []TileProblem
+	for _, t := range tiles {
+		err := verifyTile(t)
+		if err == nil {
+			continue
+		}
+		problems = append(problems, TileProblem{Tile: t, Err: err})
+		if repair {
+			err = c.remove(t)
+			if err != nil {
+				return problems, err
+			}
+		}
+	}
+	return problems, nil
+}
+
+// Prune evicts the least recently used tiles until the cache holds at most
+// maxSize bytes, returns the evicted tiles
+func (c DemCache) Prune(maxSize int64) ([]CachedTile, error) {
+	tiles, err := c.Tiles()
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, t := range tiles {
+		total += t.Size
+	}
+	sort.SliceStable(tiles, func(i, j int) bool { return tiles[i].LastUsed.Before(tiles[j].LastUsed) })
+	var evicted []CachedTile
+	for _, t := range tiles {
+		if total <= maxSize {
+			break
+		}
+		err = c.remove(t)
+		if err != nil {
+			return evicted, err
+		}
+		total -= t.Size
+		evicted = append(evicted, t)
+	}
+	return evicted, nil
+}
+
+// remove deletes a tile, its sidecar and its index entry
+func (c DemCache) remove(t CachedTile) error {
+	index, err := openTileIndex(c.Dir)
+	if err != nil {
+		return err
+	}
+	err = index.remove(t.Name())
+	if err != nil {
+		return err
+	}
+	handles.evict(t.Path)
+	err = os.Remove(t.Path)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(t.Path + tileMetaExt)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// touch records that a tile was sampled on its sidecar, tiles without one
+// keep the time they were downloaded
+func (c DemCache) touch(path string) {
+	now := time.Now()
+	err := os.Chtimes(path+tileMetaExt, now, now)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to update last use of tile=%s: %s", path, err)
+	}
+}
+
+// saveTileMeta writes the sidecar of a downloaded tile unless it exists
+func saveTileMeta(path string, i Item) error {
+	metaPath := path + tileMetaExt
+	if _, err := os.Stat(metaPath); err == nil {
+		return nil
+	}
+	b, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(metaPath)+".*.part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), metaPath)
+}
+
+func readTileMeta(path string) (*Item, error) {
+	b, err := os.ReadFile(path + tileMetaExt)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var i Item
+	err = json.Unmarshal(b, &i)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// verifyTile reads every row of the first band, GDAL opens truncated
+// GeoTIFFs and only fails on the missing blocks
+func verifyTile(t CachedTile) error {
+	if t.Item != nil && t.Item.SizeInBytes > 0 && t.Size != int64(t.Item.SizeInBytes) {
+		return errors.New(fmt.Sprintf("size=%d bytes, expected %d", t.Size, t.Item.SizeInBytes))
+	}
+	r, err := openRaster(t.Path, 1)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, h := r.Size()
+	buf := make([]float32, w)
+	for row := 0; row < h; row++ {
+		err = r.Read(0, row, w, 1, buf)
+		if err != nil {
+			return errors.New(fmt.Sprintf("unable to read row=%d: %s", row, err))
+		}
+	}
+	return nil
+}
+
+// Prefetch downloads the tiles overlapping each of the boxes. Boxes are split
+// into tile sized cells, each cell gets the tiles of the products in order
+// until a single tile holds all of it, so that the products points fall back
+// to are cached as well. Returns the number of tiles downloaded and already cached
+func Prefetch(nm NationalMap, boxes []BoundingBox) (int, int, error) {
+	err := os.MkdirAll(nm.cacheDir(), 0755)
+	if err != nil {
+		return 0, 0, err
+	}
+	index, err := openTileIndex(nm.CacheDir)
+	if err != nil {
+		return 0, 0, err
+	}
+	seen := map[string]bool{}
+	var downloaded, cached int
+	for _, b := range boxes {
+		for _, cell := range b.cells(global.ELEVATION_TILE_SIZE) {
+			for _, product := range nm.products() {
+				q, err := NewNationalMapQuery(nm, product)
+				if err != nil {
+					return downloaded, cached, err
+				}
+				r, err := q.QueryBoundingBox(cell)
+				if err != nil {
+					return downloaded, cached, err
+				}
+				complete := false
+				for _, i := range r.Items {
+					if i.Product() != product || !i.BoundingBox.Overlaps(cell) {
+						continue
+					}
+					complete = complete || i.BoundingBox.ContainsBox(cell)
+					key, err := i.cacheKey(nm)
+					if err != nil {
+						return downloaded, cached, err
+					}
+					if seen[key] {
+						continue
+					}
+					seen[key] = true
+					if index.contains(filepath.Base(key)) {
+						cached++
+						continue
+					}
+					err = nm.download(i)
+					if err != nil {
+						return downloaded, cached, err
+					}
+					downloaded++
+				}
+				if complete {
+					break
+				}
+			}
+		}
+	}
+	return downloaded, cached, nil
+}
@@ -0,0 +1,198 @@
+package elevation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/tnmtest"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDemCache(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	nm := NationalMap{
+		BaseURL:  srv.URL,
+		CacheDir: filepath.Join(t.TempDir(), "dem"),
+		Products: []types.ElevationProduct{types.ThirdArcSecond},
+	}
+	c := DemCache{Dir: nm.CacheDir}
+
+	// only the 1/3 arc-second tiles overlapping the boxes are downloaded
+	boxes := []BoundingBox{
+		{MinX: -155.9, MaxX: -155.1, MinY: 19.1, MaxY: 19.9},
+		{MinX: -157.9, MaxX: -157.1, MinY: 21.1, MaxY: 21.9},
+	}
+	downloaded, cached, err := Prefetch(nm, boxes)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, downloaded)
+	assert.Equal(t, 0, cached)
+	downloaded, cached, err = Prefetch(nm, boxes[:1])
+	assert.Nil(t, err)
+	assert.Equal(t, 0, downloaded)
+	assert.Equal(t, 1, cached)
+
+	tiles, err := c.Tiles()
+	assert.Nil(t, err)
+	assert.Len(t, tiles, 2)
+	for _, tile := range tiles {
+		assert.Equal(t, "1/3", tile.Product())
+		assert.NotNil(t, tile.Item)
+	}
+	problems, err := c.Verify(false)
+	assert.Nil(t, err)
+	assert.Len(t, problems, 0)
+
+	// truncate a tile
+	assert.Nil(t, os.Truncate(tiles[0].Path, tiles[0].Size/2))
+	problems, err = c.Verify(true)
+	assert.Nil(t, err)
+	assert.Len(t, problems, 1)
+	assert.NoFileExists(t, tiles[0].Path)
+	assert.NoFileExists(t, tiles[0].Path+tileMetaExt)
+
+	// the least recently used tile is evicted first
+	downloaded, _, err = Prefetch(nm, boxes)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, downloaded)
+	old := time.Now().Add(-time.Hour)
+	assert.Nil(t, os.Chtimes(tiles[1].Path+tileMetaExt, old, old))
+	evicted, err := c.Prune(tiles[0].Size)
+	assert.Nil(t, err)
+	assert.Len(t, evicted, 1)
+	assert.Equal(t, tiles[1].Name(), evicted[0].Name())
+	tiles, err = c.Tiles()
+	assert.Nil(t, err)
+	assert.Len(t, tiles, 1)
+}
+
+func TestElevationAccessorIndex(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	dir := filepath.Join(t.TempDir(), "dem")
+	src := NationalMapSource{NationalMap: NationalMap{
+		BaseURL:  srv.URL,
+		CacheDir: dir,
+		Products: []types.ElevationProduct{types.ThirdArcSecond},
+	}}
+
+	p := Points{{X: -155.5, Y: 19.5}, {X: -150, Y: 30}}
+	assert.Nil(t, src.GetElevation(p))
+	assert.False(t, p[0].NilElevation())
+	assert.Equal(t, types.ElevationReason(types.NoCoverage), p[1].Reason)
+	assert.Equal(t, 2, srv.Hits(tnmtest.ProductsPath))
+
+	// cached tiles are found without querying the National Map, also after a restart
+	delete(tileIndexes.m, filepath.Clean(dir))
+	p = Points{{X: -155.2, Y: 19.2}, {X: -155.8, Y: 19.8}}
+	assert.Nil(t, src.GetElevation(p))
+	for _, point := range p {
+		assert.False(t, point.NilElevation())
+	}
+	assert.Equal(t, 2, srv.Hits(tnmtest.ProductsPath))
+	assert.Equal(t, 1, srv.Hits(tnmtest.TiffPath+testTile))
+	assert.FileExists(t, filepath.Join(dir, tileIndexName))
+
+	// a tile deleted by another process, e.g. dem prune, is downloaded again
+	assert.Nil(t, os.Remove(filepath.Join(dir, testTile)))
+	p = Points{{X: -155.5, Y: 19.5}}
+	assert.Nil(t, src.GetElevation(p))
+	assert.False(t, p[0].NilElevation())
+	assert.Equal(t, 2, srv.Hits(tnmtest.TiffPath+testTile))
+	assert.FileExists(t, filepath.Join(dir, testTile))
+	handles.evict(filepath.Join(dir, testTile))
+}
+
+func TestElevationAccessorHandles(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	dir := filepath.Join(t.TempDir(), "dem")
+	src := NationalMapSource{NationalMap: NationalMap{
+		BaseURL:  srv.URL,
+		CacheDir: dir,
+		Products: []types.ElevationProduct{types.ThirdArcSecond},
+	}}
+	key := handleKey{path: filepath.Join(dir, testTile), band: 1}
+
+	// batches sampling the same tile share its handle, stamping the last use
+	// of the tile doesn't reopen it
+	assert.Nil(t, src.GetElevation(Points{{X: -155.5, Y: 19.5}}))
+	handles.mu.Lock()
+	e, ok := handles.entries[key]
+	handles.mu.Unlock()
+	assert.True(t, ok)
+	first := e.Value.(*handle)
+	tiles, err := DemCache{Dir: dir}.Tiles()
+	assert.Nil(t, err)
+	assert.Len(t, tiles, 1)
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, src.GetElevation(Points{{X: -155.2, Y: 19.2}}))
+	handles.mu.Lock()
+	e, ok = handles.entries[key]
+	handles.mu.Unlock()
+	assert.True(t, ok)
+	assert.Same(t, first, e.Value.(*handle))
+	again, err := DemCache{Dir: dir}.Tiles()
+	assert.Nil(t, err)
+	assert.True(t, again[0].LastUsed.After(tiles[0].LastUsed))
+	handles.evict(key.path)
+}
+
+func TestElevationAccessorProducts(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	dir := filepath.Join(t.TempDir(), "dem")
+	src := NationalMapSource{NationalMap: NationalMap{BaseURL: srv.URL, CacheDir: dir}}
+
+	// 1 m lidar, 1/3 arc-second around it, 1 arc-second only in Alaska
+	p := Points{{X: -155.5, Y: 19.5}, {X: -155.2, Y: 19.2}, {X: -149.5, Y: 60.5}, {X: -150, Y: 30}}
+	assert.Nil(t, src.GetElevation(p))
+	for i, product := range []types.ElevationProduct{types.OneMeter, types.ThirdArcSecond, types.OneArcSecond} {
+		assert.False(t, p[i].NilElevation())
+		assert.Equal(t, product, p[i].Product)
+	}
+	assert.Equal(t, "USGS_1M_5_x24y216_HI_Hawaii_2017.tif", p[0].Tile)
+	assert.Equal(t, types.ElevationReason(types.NoCoverage), p[3].Reason)
+	assert.Equal(t, types.ElevationProduct(""), p[3].Product)
+	assert.Equal(t, 0, srv.Hits(tnmtest.TiffPath+"USGS_1_n20w156_20130911.tif"))
+
+	// prefetch caches the fallback products of cells the best product covers in part
+	nm := NationalMap{BaseURL: srv.URL, CacheDir: filepath.Join(t.TempDir(), "prefetch")}
+	downloaded, _, err := Prefetch(nm, []BoundingBox{{MinX: -155.9, MaxX: -155.1, MinY: 19.1, MaxY: 19.9}})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, downloaded)
+	tiles, err := DemCache{Dir: nm.CacheDir}.Tiles()
+	assert.Nil(t, err)
+	var products []string
+	for _, tile := range tiles {
+		products = append(products, tile.Product())
+	}
+	assert.ElementsMatch(t, []string{"1m", "1/3"}, products)
+}
+
+func TestNationalMapQueryPaging(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	srv.MaxPageSize = 1
+	q, err := NewNationalMapQuery(NationalMap{BaseURL: srv.URL, RequestRate: 20}, types.ThirdArcSecond)
+	assert.Nil(t, err)
+
+	start := time.Now()
+	b := BoundingBox{MinX: -158, MaxX: -155, MinY: 19, MaxY: 22}
+	r, err := q.QueryBoundingBox(b)
+	assert.Nil(t, err)
+	assert.Len(t, r.Items, 2)
+	assert.Equal(t, 2, r.Total)
+	assert.Equal(t, 2, srv.Hits(tnmtest.ProductsPath))
+	// two pages at 20 requests per second
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// the result is cached for the run
+	r, err = q.QueryBoundingBox(b)
+	assert.Nil(t, err)
+	assert.Len(t, r.Items, 2)
+	assert.Equal(t, 2, srv.Hits(tnmtest.ProductsPath))
+}
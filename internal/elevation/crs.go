@@ -0,0 +1,96 @@
+package elevation
+
+import (
+	"math"
+	"strings"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+)
+
+// GeographicSRID is the CRS points are sampled, indexed and queried in
+const GeographicSRID = 4326
+
+// NullShapeSRID is the SRID of points read from a null shape
+const NullShapeSRID = -1
+
+// geographicSRIDs hold lon/lat coordinates that are used as is, NAD83 and
+// WGS84 differ by less than the pixel size of any DEM
+var geographicSRIDs = []int{0, GeographicSRID, 4269}
+
+// isGeographicWKT checks whether a raster projection is lon/lat, rasters
+// without a projection are assumed to be
+func isGeographicWKT(wkt string) bool {
+	wkt = strings.TrimSpace(wkt)
+	return wkt == "" || strings.HasPrefix(wkt, "GEOGCS") || strings.HasPrefix(wkt, "GEOGCRS")
+}
+
+// Geographic reprojects points read from a projected geometry to lon/lat.
+// Points whose SRID can't be transformed get the InvalidSRID reason, points
+// with a null shape the NoGeometry reason
+func Geographic(p Points) {
+	bySRID := map[int]Points{}
+	for _, point := range p {
+		switch {
+		case point.Resolved():
+		case point.SRID == NullShapeSRID:
+			point.Reason = types.NoGeometry
+		case !isGeographicSRID(point.SRID):
+			bySRID[point.SRID] = append(bySRID[point.SRID], point)
+		}
+	}
+	for srid, points := range bySRID {
+		err := reproject(points, srid, GeographicSRID)
+		if err != nil {
+			for _, point := range points {
+				point.Reason = types.InvalidSRID
+			}
+			continue
+		}
+		for _, point := range points {
+			point.SRID = GeographicSRID
+		}
+	}
+}
+
+func isGeographicSRID(srid int) bool {
+	for _, s := range geographicSRIDs {
+		if s == srid {
+			return true
+		}
+	}
+	return false
+}
+
+// reproject transforms the coordinates of points from one SRID to another
+func reproject(p Points, from int, to int) error {
+	t, err := newTransformer(from, "", to, "")
+	if err != nil {
+		return err
+	}
+	defer t.close()
+	x := make([]float64, len(p))
+	y := make([]float64, len(p))
+	for i, point := range p {
+		x[i], y[i] = point.X, point.Y
+	}
+	err = t.transform(x, y)
+	if err != nil {
+		return err
+	}
+	for i, point := range p {
+		point.X, point.Y = x[i], y[i]
+	}
+	return nil
+}
+
+// Disagreement is the distance in meters between the geometry of a point and
+// its x, y columns once reprojected to lon/lat, 0 if the point wasn't read
+// from its geometry, couldn't be reprojected or has no x, y
+func (p Point) Disagreement() float64 {
+	if p.AttrX == nil || p.AttrY == nil || !isGeographicSRID(p.SRID) {
+		return 0
+	}
+	dx := (*p.AttrX - p.X) * metersPerDegree * math.Cos(p.Y*math.Pi/180)
+	dy := (*p.AttrY - p.Y) * metersPerDegree
+	return math.Hypot(dx, dy)
+}
@@ -0,0 +1,64 @@
+//go:build !purego
+
+package elevation
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lukeroth/gdal"
+)
+
+// newSpatialReference creates a spatial reference with x, y in lon/lat order
+func newSpatialReference(srid int, wkt string) (gdal.SpatialReference, error) {
+	sr := gdal.CreateSpatialReference(wkt)
+	if wkt == "" {
+		err := sr.FromEPSG(srid)
+		if err != nil {
+			sr.Destroy()
+			return gdal.SpatialReference{}, errors.New(fmt.Sprintf("unknown srid=%d: %s", srid, err))
+		}
+	}
+	sr.SetAxisMappingStrategy(gdal.OAMS_TraditionalGisOrder)
+	return sr, nil
+}
+
+// transformer reprojects coordinates between two CRSs with GDAL/OSR
+type transformer struct {
+	src gdal.SpatialReference
+	dst gdal.SpatialReference
+	ct  gdal.CoordinateTransform
+}
+
+// newTransformer reprojects from the CRS of srcSRID or srcWKT to the CRS of
+// dstSRID or dstWKT. WKT takes precedence when set
+func newTransformer(srcSRID int, srcWKT string, dstSRID int, dstWKT string) (*transformer, error) {
+	src, err := newSpatialReference(srcSRID, srcWKT)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := newSpatialReference(dstSRID, dstWKT)
+	if err != nil {
+		src.Destroy()
+		return nil, err
+	}
+	return &transformer{src: src, dst: dst, ct: gdal.CreateCoordinateTransform(src, dst)}, nil
+}
+
+// transform reprojects x, y in place
+func (t *transformer) transform(x []float64, y []float64) error {
+	if len(x) == 0 {
+		return nil
+	}
+	z := make([]float64, len(x))
+	if !t.ct.Transform(len(x), x, y, z) {
+		return errors.New("coordinate transformation failed")
+	}
+	return nil
+}
+
+func (t *transformer) close() {
+	t.ct.Destroy()
+	t.dst.Destroy()
+	t.src.Destroy()
+}
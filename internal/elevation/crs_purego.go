@@ -0,0 +1,50 @@
+//go:build purego
+
+package elevation
+
+import (
+	"errors"
+	"fmt"
+)
+
+// transformer reprojects coordinates between two CRSs in pure Go, limited to
+// the CRSs projectionOf knows
+type transformer struct {
+	src projection
+	dst projection
+}
+
+// newTransformer reprojects from the CRS of srcSRID or srcWKT to the CRS of
+// dstSRID or dstWKT. WKT takes precedence when set
+func newTransformer(srcSRID int, srcWKT string, dstSRID int, dstWKT string) (*transformer, error) {
+	src, err := wktProjection(srcSRID, srcWKT)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := wktProjection(dstSRID, dstWKT)
+	if err != nil {
+		return nil, err
+	}
+	return &transformer{src: src, dst: dst}, nil
+}
+
+func wktProjection(srid int, wkt string) (projection, error) {
+	if wkt != "" {
+		srid = wktSRID(wkt)
+		if srid == 0 {
+			return nil, errors.New(fmt.Sprintf("unable to read an EPSG code from wkt=%s", wkt))
+		}
+	}
+	return projectionOf(srid)
+}
+
+// transform reprojects x, y in place
+func (t *transformer) transform(x []float64, y []float64) error {
+	for i := range x {
+		lon, lat := t.src.inverse(x[i], y[i])
+		x[i], y[i] = t.dst.forward(lon, lat)
+	}
+	return nil
+}
+
+func (t *transformer) close() {}
@@ -0,0 +1,129 @@
+package elevation
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// downloadRetries is the number of attempts per tile before a download fails
+var downloadRetries = 4
+
+// downloadBackoff is the wait after the first failed attempt, doubled on
+// every further attempt. Shortened in tests
+var downloadBackoff = time.Second
+
+// tileDownloads de-duplicates concurrent downloads of the same tile. All
+// callers of a tile that is being downloaded wait for and share the result
+var tileDownloads = downloadGroup{calls: map[string]*downloadCall{}}
+
+type downloadCall struct {
+	wg  sync.WaitGroup
+	err error
+}
+
+type downloadGroup struct {
+	mu    sync.Mutex
+	calls map[string]*downloadCall
+}
+
+// do runs f once for every dest that is not already being downloaded
+func (g *downloadGroup) do(dest string, f func() error) error {
+	g.mu.Lock()
+	if c, ok := g.calls[dest]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.err
+	}
+	c := &downloadCall{}
+	c.wg.Add(1)
+	g.calls[dest] = c
+	g.mu.Unlock()
+
+	c.err = f()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, dest)
+	g.mu.Unlock()
+	return c.err
+}
+
+// downloadTile downloads url to dest unless dest already exists. size is the
+// expected file size in bytes, 0 if unknown
+func downloadTile(url string, dest string, size int) error {
+	return tileDownloads.do(dest, func() error {
+		if _, err := os.Stat(dest); err == nil {
+			// downloaded by a previous caller or another process
+			return nil
+		}
+		var err error
+		backoff := downloadBackoff
+		for attempt := 1; attempt <= downloadRetries; attempt++ {
+			log.Print("Downloading data from: " + url)
+			err = downloadFile(url, dest, size)
+			if err == nil {
+				return nil
+			}
+			if attempt < downloadRetries {
+				log.Printf("Download of %s failed, retrying in %s: %s", url, backoff, err)
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+		}
+		return errors.New(fmt.Sprintf("unable to download %s after %d attempts: %s", url, downloadRetries, err))
+	})
+}
+
+// downloadFile writes the response body to a temp file next to dest and
+// renames it into place once verified, so that readers never see a partial
+// tile. The temp file name does not end in a raster extension and is skipped
+// by directory listings of the cache
+func downloadFile(url string, dest string, size int) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("status=%s", resp.Status))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".*.part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	n, err := io.Copy(tmp, resp.Body)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	if cl := resp.Header.Get("Content-Length"); cl != "" {
+		expected, err := strconv.ParseInt(cl, 10, 64)
+		if err == nil && n != expected {
+			return errors.New(fmt.Sprintf("truncated download, received %d of %d bytes", n, expected))
+		}
+	}
+	if size > 0 && n != int64(size) {
+		return errors.New(fmt.Sprintf("size mismatch, received %d bytes, expected %d", n, size))
+	}
+	// a tile GDAL can't read is as good as a truncated one
+	_, err = rasterBoundingBox(tmp.Name())
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
@@ -0,0 +1,66 @@
+package elevation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/tnmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+const testTile = "USGS_13_n20w156_20130911.tif"
+
+func newTestServer(t *testing.T) *tnmtest.Server {
+	srv, err := tnmtest.NewServer(filepath.Join("..", "..", "assets", "tnmtest", "products.json"))
+	assert.Nil(t, err)
+	return srv
+}
+
+func TestDownloadTileRetry(t *testing.T) {
+	downloadBackoff = time.Millisecond
+	srv := newTestServer(t)
+	defer srv.Close()
+	dir := t.TempDir()
+	dest := filepath.Join(dir, testTile)
+
+	srv.FailTiffs = downloadRetries - 1
+	assert.Nil(t, downloadTile(srv.URL+tnmtest.TiffPath+testTile, dest, 0))
+	_, err := rasterBoundingBox(dest)
+	assert.Nil(t, err)
+	assert.Equal(t, downloadRetries, srv.Hits(tnmtest.TiffPath+testTile))
+
+	// a failed download leaves neither the tile nor a partial file behind
+	assert.Nil(t, os.Remove(dest))
+	srv.FailTiffs = downloadRetries
+	err = downloadTile(srv.URL+tnmtest.TiffPath+testTile, dest, 0)
+	assert.Contains(t, fmt.Sprint(err), "503")
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 0)
+
+	// size mismatch
+	err = downloadTile(srv.URL+tnmtest.TiffPath+testTile, dest, 1)
+	assert.Contains(t, fmt.Sprint(err), "size mismatch")
+	assert.NoFileExists(t, dest)
+}
+
+func TestDownloadTileOnce(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	dest := filepath.Join(t.TempDir(), testTile)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, downloadTile(srv.URL+tnmtest.TiffPath+testTile, dest, 0))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, srv.Hits(tnmtest.TiffPath+testTile))
+}
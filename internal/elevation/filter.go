@@ -0,0 +1,95 @@
+package elevation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+)
+
+// Filter selects the points an overwrite run resamples. Conditions are
+// combined with and, a zero Filter selects every point
+type Filter struct {
+	Counties []string // 5 digit county fips, matched on the start of cbfips2010
+	States   []string // 2 digit state fips, matched on the start of cbfips2010
+	BBox     *BoundingBox
+	Reasons  []types.ElevationReason  // points left null for one of the reasons
+	Products []types.ElevationProduct // points sampled from one of the products
+}
+
+// Empty checks whether the Filter selects every point
+func (f Filter) Empty() bool {
+	return len(f.Counties) == 0 && len(f.States) == 0 && f.BBox == nil && len(f.Reasons) == 0 && len(f.Products) == 0
+}
+
+// Match checks whether a point with the cbfips2010 block fips is selected
+func (f Filter) Match(p Point, fips string) bool {
+	if len(f.Counties) > 0 && !hasPrefix(fips, f.Counties) {
+		return false
+	}
+	if len(f.States) > 0 && !hasPrefix(fips, f.States) {
+		return false
+	}
+	if f.BBox != nil && !f.BBox.Contains(p) {
+		return false
+	}
+	if len(f.Reasons) > 0 {
+		found := false
+		for _, r := range f.Reasons {
+			found = found || (p.Reason != "" && p.Reason == r)
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Products) > 0 {
+		found := false
+		for _, pr := range f.Products {
+			found = found || (p.Product != "" && p.Product == pr)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (f Filter) String() string {
+	var s []string
+	if len(f.Counties) > 0 {
+		s = append(s, "county="+strings.Join(f.Counties, ","))
+	}
+	if len(f.States) > 0 {
+		s = append(s, "state="+strings.Join(f.States, ","))
+	}
+	if f.BBox != nil {
+		s = append(s, fmt.Sprintf("bbox=%g,%g,%g,%g", f.BBox.MinX, f.BBox.MinY, f.BBox.MaxX, f.BBox.MaxY))
+	}
+	if len(f.Reasons) > 0 {
+		var r []string
+		for _, v := range f.Reasons {
+			r = append(r, string(v))
+		}
+		s = append(s, "reason="+strings.Join(r, ","))
+	}
+	if len(f.Products) > 0 {
+		var p []string
+		for _, v := range f.Products {
+			p = append(p, string(v))
+		}
+		s = append(s, "product="+strings.Join(p, ","))
+	}
+	if len(s) == 0 {
+		return "all points"
+	}
+	return strings.Join(s, ";")
+}
+
+func hasPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,486 @@
+package elevation
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/tiff/lzw"
+)
+
+// tiff tags read by the GeoTIFF reader
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffPlanarConfig    = 284
+	tiffPredictor       = 317
+	tiffTileWidth       = 322
+	tiffTileLength      = 323
+	tiffTileOffsets     = 324
+	tiffTileByteCounts  = 325
+	tiffSampleFormat    = 339
+	tiffModelPixelScale = 33550
+	tiffModelTiepoint   = 33922
+	tiffModelTransform  = 34264
+	tiffGeoKeyDirectory = 34735
+	tiffGdalNoData      = 42113
+)
+
+// geokeys read by the GeoTIFF reader
+const (
+	geoModelType      = 1024
+	geoRasterType     = 1025
+	geoGeographicType = 2048
+	geoProjectedType  = 3072
+)
+
+// geoTIFF is a pure Go reader of single image float32 GeoTIFFs, stripped or
+// tiled, uncompressed, LZW or deflate compressed, with or without the
+// horizontal or floating point predictor, as the National Map publishes its
+// DEMs. BigTIFF is read as well. Decoded blocks are not cached, sampling
+// reads block aligned windows
+type geoTIFF struct {
+	f           *os.File
+	order       binary.ByteOrder
+	width       int
+	height      int
+	blockW      int // tile width or image width for strips
+	blockH      int // tile length or rows per strip
+	tiled       bool
+	offsets     []uint64
+	counts      []uint64
+	bands       int
+	band        int // 0 based
+	planar      bool
+	compression uint64
+	predictor   uint64
+	gt          [6]float64
+	wkt         string
+	noData      *float64
+}
+
+// tiffEntry is a tag of an ifd with its payload
+type tiffEntry struct {
+	typ   uint16
+	count uint64
+	data  []byte
+}
+
+// openGeoTIFF opens band of path, bands are numbered from 1
+func openGeoTIFF(path string, band int) (raster, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	g, err := readGeoTIFF(f, band)
+	if err != nil {
+		f.Close()
+		return nil, errors.New(fmt.Sprintf("unable to read raster=%s: %s", path, err))
+	}
+	if band < 1 || band > g.bands {
+		f.Close()
+		return nil, errors.New(fmt.Sprintf("raster=%s has %d bands, unable to sample band=%d", path, g.bands, band))
+	}
+	return g, nil
+}
+
+func readGeoTIFF(f *os.File, band int) (*geoTIFF, error) {
+	header := make([]byte, 16)
+	_, err := io.ReadFull(f, header[:8])
+	if err != nil {
+		return nil, err
+	}
+	g := geoTIFF{f: f, band: band - 1}
+	switch string(header[:2]) {
+	case "II":
+		g.order = binary.LittleEndian
+	case "MM":
+		g.order = binary.BigEndian
+	default:
+		return nil, errors.New("not a tiff")
+	}
+	var entries map[uint16]tiffEntry
+	switch g.order.Uint16(header[2:]) {
+	case 42:
+		entries, err = g.readIFD(uint64(g.order.Uint32(header[4:])), false)
+	case 43:
+		_, err = io.ReadFull(f, header[8:])
+		if err != nil {
+			return nil, err
+		}
+		entries, err = g.readIFD(g.order.Uint64(header[8:]), true)
+	default:
+		return nil, errors.New("not a tiff")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &g, g.parse(entries)
+}
+
+// readIFD reads the first image file directory at offset
+func (g *geoTIFF) readIFD(offset uint64, big bool) (map[uint16]tiffEntry, error) {
+	countSize, entrySize, valueSize := 2, 12, 4
+	if big {
+		countSize, entrySize, valueSize = 8, 20, 8
+	}
+	b := make([]byte, countSize)
+	_, err := g.f.ReadAt(b, int64(offset))
+	if err != nil {
+		return nil, err
+	}
+	var n uint64
+	if big {
+		n = g.order.Uint64(b)
+	} else {
+		n = uint64(g.order.Uint16(b))
+	}
+	b = make([]byte, int(n)*entrySize)
+	_, err = g.f.ReadAt(b, int64(offset)+int64(countSize))
+	if err != nil {
+		return nil, err
+	}
+	entries := map[uint16]tiffEntry{}
+	for i := 0; i < int(n); i++ {
+		e := b[i*entrySize:]
+		tag := g.order.Uint16(e)
+		entry := tiffEntry{typ: g.order.Uint16(e[2:])}
+		value := e[8 : 8+valueSize]
+		if big {
+			entry.count = g.order.Uint64(e[4:])
+			value = e[12:20]
+		} else {
+			entry.count = uint64(g.order.Uint32(e[4:]))
+		}
+		size := tiffTypeSize(entry.typ) * entry.count
+		if size <= uint64(valueSize) {
+			entry.data = value[:size]
+		} else {
+			var at uint64
+			if big {
+				at = g.order.Uint64(value)
+			} else {
+				at = uint64(g.order.Uint32(value))
+			}
+			entry.data = make([]byte, size)
+			_, err = g.f.ReadAt(entry.data, int64(at))
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("unable to read tag=%d: %s", tag, err))
+			}
+		}
+		entries[tag] = entry
+	}
+	return entries, nil
+}
+
+func tiffTypeSize(typ uint16) uint64 {
+	switch typ {
+	case 1, 2, 6, 7: // byte, ascii, sbyte, undefined
+		return 1
+	case 3, 8: // short, sshort
+		return 2
+	case 4, 9, 11, 13: // long, slong, float, ifd
+		return 4
+	default: // rational, srational, double, long8, slong8, ifd8
+		return 8
+	}
+}
+
+// ints reads the integer values of an entry
+func (g *geoTIFF) ints(e tiffEntry) []uint64 {
+	v := make([]uint64, e.count)
+	for i := range v {
+		switch e.typ {
+		case 1, 6, 7:
+			v[i] = uint64(e.data[i])
+		case 3, 8:
+			v[i] = uint64(g.order.Uint16(e.data[2*i:]))
+		case 4, 9, 13:
+			v[i] = uint64(g.order.Uint32(e.data[4*i:]))
+		case 16, 17, 18:
+			v[i] = g.order.Uint64(e.data[8*i:])
+		}
+	}
+	return v
+}
+
+// floats reads the double values of an entry
+func (g *geoTIFF) floats(e tiffEntry) []float64 {
+	v := make([]float64, e.count)
+	for i := range v {
+		if e.typ == 12 {
+			v[i] = math.Float64frombits(g.order.Uint64(e.data[8*i:]))
+		}
+	}
+	return v
+}
+
+// parse validates the layout of the image and reads its georeferencing
+func (g *geoTIFF) parse(entries map[uint16]tiffEntry) error {
+	first := func(tag uint16, def uint64) uint64 {
+		e, ok := entries[tag]
+		if !ok || e.count == 0 {
+			return def
+		}
+		return g.ints(e)[0]
+	}
+	g.width = int(first(tiffImageWidth, 0))
+	g.height = int(first(tiffImageLength, 0))
+	if g.width == 0 || g.height == 0 {
+		return errors.New("no image size")
+	}
+	g.bands = int(first(tiffSamplesPerPixel, 1))
+	g.planar = first(tiffPlanarConfig, 1) == 2
+	if bits, format := first(tiffBitsPerSample, 1), first(tiffSampleFormat, 1); bits != 32 || format != 3 {
+		return errors.New(fmt.Sprintf("%d bit samples of format=%d, only float32 is supported", bits, format))
+	}
+	g.compression = first(tiffCompression, 1)
+	switch g.compression {
+	case 1, 5, 8, 32946:
+	default:
+		return errors.New(fmt.Sprintf("compression=%d, only none, lzw and deflate are supported", g.compression))
+	}
+	g.predictor = first(tiffPredictor, 1)
+	if g.predictor < 1 || g.predictor > 3 {
+		return errors.New(fmt.Sprintf("predictor=%d is not supported for float samples", g.predictor))
+	}
+
+	offsetTag, countTag := uint16(tiffStripOffsets), uint16(tiffStripByteCounts)
+	if _, ok := entries[tiffTileOffsets]; ok {
+		g.tiled = true
+		offsetTag, countTag = tiffTileOffsets, tiffTileByteCounts
+		g.blockW = int(first(tiffTileWidth, 0))
+		g.blockH = int(first(tiffTileLength, 0))
+	} else {
+		g.blockW = g.width
+		g.blockH = int(first(tiffRowsPerStrip, uint64(g.height)))
+		if g.blockH > g.height {
+			g.blockH = g.height
+		}
+	}
+	if g.blockW == 0 || g.blockH == 0 {
+		return errors.New("no block size")
+	}
+	g.offsets = g.ints(entries[offsetTag])
+	g.counts = g.ints(entries[countTag])
+	blocks := g.blocksAcross() * g.blocksDown()
+	if g.planar {
+		blocks *= g.bands
+	}
+	if len(g.offsets) < blocks || len(g.counts) < blocks {
+		return errors.New(fmt.Sprintf("%d blocks listed, expected %d", len(g.offsets), blocks))
+	}
+
+	switch {
+	case entries[tiffModelTransform].count >= 16:
+		m := g.floats(entries[tiffModelTransform])
+		g.gt = [6]float64{m[3], m[0], m[1], m[7], m[4], m[5]}
+	case entries[tiffModelPixelScale].count >= 2 && entries[tiffModelTiepoint].count >= 6:
+		s := g.floats(entries[tiffModelPixelScale])
+		t := g.floats(entries[tiffModelTiepoint])
+		g.gt = [6]float64{t[3] - t[0]*s[0], s[0], 0, t[4] + t[1]*s[1], 0, -s[1]}
+	default:
+		return errors.New("no georeferencing")
+	}
+	keys := g.geoKeys(entries[tiffGeoKeyDirectory])
+	if keys[geoRasterType] == 2 {
+		// pixel is point, GDAL shifts the origin to the corner of the pixel
+		g.gt[0] -= 0.5 * g.gt[1]
+		g.gt[3] -= 0.5 * g.gt[5]
+	}
+	switch keys[geoModelType] {
+	case 1:
+		g.wkt = fmt.Sprintf(`PROJCS["EPSG:%[1]d",AUTHORITY["EPSG","%[1]d"]]`, keys[geoProjectedType])
+	case 2:
+		g.wkt = fmt.Sprintf(`GEOGCS["EPSG:%[1]d",AUTHORITY["EPSG","%[1]d"]]`, keys[geoGeographicType])
+	}
+	if e, ok := entries[tiffGdalNoData]; ok {
+		v, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimRight(string(e.data), "\x00")), 64)
+		if err == nil {
+			g.noData = &v
+		}
+	}
+	return nil
+}
+
+// geoKeys reads the short valued keys of the geokey directory
+func (g *geoTIFF) geoKeys(e tiffEntry) map[int]int {
+	keys := map[int]int{}
+	if e.count < 4 {
+		return keys
+	}
+	v := g.ints(e)
+	for i := 4; i+3 < len(v); i += 4 {
+		if v[i+1] == 0 {
+			keys[int(v[i])] = int(v[i+3])
+		}
+	}
+	return keys
+}
+
+func (g *geoTIFF) blocksAcross() int {
+	return (g.width + g.blockW - 1) / g.blockW
+}
+
+func (g *geoTIFF) blocksDown() int {
+	return (g.height + g.blockH - 1) / g.blockH
+}
+
+func (g *geoTIFF) Size() (int, int) {
+	return g.width, g.height
+}
+
+func (g *geoTIFF) BlockSize() (int, int) {
+	return g.blockW, g.blockH
+}
+
+func (g *geoTIFF) NoDataValue() (float64, bool) {
+	if g.noData == nil {
+		return 0, false
+	}
+	return *g.noData, true
+}
+
+func (g *geoTIFF) GeoTransform() [6]float64 {
+	return g.gt
+}
+
+func (g *geoTIFF) Projection() string {
+	return g.wkt
+}
+
+// Read copies a window from the blocks intersecting it, each decoded once
+func (g *geoTIFF) Read(col int, row int, w int, h int, buf []float32) error {
+	if col < 0 || row < 0 || w < 1 || h < 1 || col+w > g.width || row+h > g.height {
+		return errors.New(fmt.Sprintf("window %d,%d %dx%d is outside of the %dx%d raster", col, row, w, h, g.width, g.height))
+	}
+	for by := row / g.blockH; by <= (row+h-1)/g.blockH; by++ {
+		for bx := col / g.blockW; bx <= (col+w-1)/g.blockW; bx++ {
+			block, err := g.block(bx, by)
+			if err != nil {
+				return err
+			}
+			for r := max(row, by*g.blockH); r < min(row+h, (by+1)*g.blockH); r++ {
+				for c := max(col, bx*g.blockW); c < min(col+w, (bx+1)*g.blockW); c++ {
+					buf[(r-row)*w+c-col] = block[(r-by*g.blockH)*g.blockW+c-bx*g.blockW]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// block decodes the samples of the band in block bx, by, row major over the
+// full block size
+func (g *geoTIFF) block(bx int, by int) ([]float32, error) {
+	i := by*g.blocksAcross() + bx
+	samples := 1
+	if g.planar {
+		i += g.band * g.blocksAcross() * g.blocksDown()
+	} else {
+		samples = g.bands
+	}
+	raw := make([]byte, g.counts[i])
+	_, err := g.f.ReadAt(raw, int64(g.offsets[i]))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read block=%d: %s", i, err))
+	}
+	var r io.ReadCloser
+	switch g.compression {
+	case 5:
+		r = lzw.NewReader(bytes.NewReader(raw), lzw.MSB, 8)
+	case 8, 32946:
+		r, err = zlib.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to inflate block=%d: %s", i, err))
+		}
+	default:
+		r = io.NopCloser(bytes.NewReader(raw))
+	}
+	defer r.Close()
+	// strips at the bottom of the image are short, tiles are padded
+	rows := g.blockH
+	if !g.tiled && (by+1)*g.blockH > g.height {
+		rows = g.height - by*g.blockH
+	}
+	rowSize := g.blockW * samples * 4
+	data := make([]byte, rows*rowSize)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to decompress block=%d: %s", i, err))
+	}
+
+	out := make([]float32, g.blockW*g.blockH)
+	n := g.blockW * samples
+	tmp := make([]byte, rowSize)
+	for y := 0; y < rows; y++ {
+		line := data[y*rowSize : (y+1)*rowSize]
+		if g.predictor == 3 {
+			// undo the byte differencing, which steps over the samples of a
+			// pixel, then gather the byte planes, most significant first,
+			// back into samples
+			for j := samples; j < len(line); j++ {
+				line[j] += line[j-samples]
+			}
+			for s := 0; s < n; s++ {
+				for b := 0; b < 4; b++ {
+					tmp[4*s+b] = line[b*n+s]
+				}
+			}
+			for x := 0; x < g.blockW; x++ {
+				out[y*g.blockW+x] = math.Float32frombits(binary.BigEndian.Uint32(tmp[4*(x*samples+g.sampleIdx()):]))
+			}
+			continue
+		}
+		if g.predictor == 2 {
+			// undo the differencing of each 32 bit word with the word of the
+			// same band in the previous pixel
+			for j := samples; j < n; j++ {
+				g.order.PutUint32(line[4*j:], g.order.Uint32(line[4*j:])+g.order.Uint32(line[4*(j-samples):]))
+			}
+		}
+		for x := 0; x < g.blockW; x++ {
+			out[y*g.blockW+x] = math.Float32frombits(g.order.Uint32(line[4*(x*samples+g.sampleIdx()):]))
+		}
+	}
+	return out, nil
+}
+
+// sampleIdx is the position of the band among the interleaved samples of a pixel
+func (g *geoTIFF) sampleIdx() int {
+	if g.planar {
+		return 0
+	}
+	return g.band
+}
+
+func (g *geoTIFF) Close() {
+	g.f.Close()
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,127 @@
+package elevation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/tnmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeoTIFF(t *testing.T) {
+	dir := t.TempDir()
+	noData := -9999.0
+	r := tnmtest.NewRaster(-156, -155, 19, 20, 300, 200, tnmtest.Elevation)
+	r.NoData = &noData
+	r.Values[0] = float32(noData)
+
+	write := func(name string, encode func(f *os.File) error) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		assert.Nil(t, err)
+		assert.Nil(t, encode(f))
+		assert.Nil(t, f.Close())
+		return path
+	}
+	horizontal := r
+	horizontal.Predictor = tnmtest.HorizontalPredictor
+	paths := []string{
+		write("strip.tif", func(f *os.File) error { return r.WriteGeoTIFF(f) }),
+		write("deflate.tif", func(f *os.File) error { return r.WriteTiledGeoTIFF(f, 128, tnmtest.Deflate) }),
+		write("lzw.tif", func(f *os.File) error { return r.WriteTiledGeoTIFF(f, 64, tnmtest.LZW) }),
+		write("horizontal.tif", func(f *os.File) error { return horizontal.WriteTiledGeoTIFF(f, 64, tnmtest.Deflate) }),
+	}
+	for _, path := range paths {
+		g, err := openGeoTIFF(path, 1)
+		assert.Nil(t, err, path)
+		w, h := g.Size()
+		assert.Equal(t, [2]int{300, 200}, [2]int{w, h}, path)
+		v, ok := g.NoDataValue()
+		assert.True(t, ok, path)
+		assert.Equal(t, noData, v, path)
+		gt := g.GeoTransform()
+		assert.InDeltaSlice(t, []float64{-156, 1.0 / 300, 0, 20, 0, -1.0 / 200}, gt[:], 1e-12, path)
+		assert.True(t, isGeographicWKT(g.Projection()), path)
+		assert.Equal(t, 4269, wktSRID(g.Projection()), path)
+
+		buf := make([]float32, w*h)
+		assert.Nil(t, g.Read(0, 0, w, h, buf), path)
+		assert.Equal(t, r.Values, buf, path)
+		// a window across tile edges
+		win := make([]float32, 70*50)
+		assert.Nil(t, g.Read(100, 120, 70, 50, win), path)
+		for row := 0; row < 50; row++ {
+			assert.Equal(t, r.Values[(120+row)*300+100:(120+row)*300+170], win[row*70:(row+1)*70], path)
+		}
+		assert.NotNil(t, g.Read(250, 0, 70, 1, win), path)
+		g.Close()
+	}
+
+	_, err := openGeoTIFF(paths[1], 2)
+	assert.Contains(t, fmt.Sprint(err), "unable to sample band=2")
+
+	// pixel interleaved bands are differenced band by band with either predictor
+	bands := r
+	bands.Bands = [][]float32{make([]float32, len(r.Values))}
+	for i, v := range r.Values {
+		bands.Bands[0][i] = -v / 2
+	}
+	for _, predictor := range []uint16{tnmtest.HorizontalPredictor, tnmtest.FloatPredictor} {
+		bands.Predictor = predictor
+		path := write(fmt.Sprintf("bands%d.tif", predictor), func(f *os.File) error { return bands.WriteTiledGeoTIFF(f, 64, tnmtest.LZW) })
+		for band, values := range [][]float32{r.Values, bands.Bands[0]} {
+			g, err := openGeoTIFF(path, band+1)
+			assert.Nil(t, err, path)
+			buf := make([]float32, 300*200)
+			assert.Nil(t, g.Read(0, 0, 300, 200, buf), path)
+			assert.Equal(t, values, buf, path, band+1)
+			g.Close()
+		}
+	}
+
+	text := filepath.Join(dir, "dem.tif")
+	assert.Nil(t, os.WriteFile(text, []byte("not a tiff"), 0644))
+	_, err = openGeoTIFF(text, 1)
+	assert.Contains(t, fmt.Sprint(err), "not a tiff")
+
+	// projected DEMs name their CRS
+	r.EPSG = 26905
+	utm := write("utm.tif", func(f *os.File) error { return r.WriteTiledGeoTIFF(f, 128, tnmtest.Deflate) })
+	g, err := openGeoTIFF(utm, 1)
+	assert.Nil(t, err)
+	defer g.Close()
+	assert.False(t, isGeographicWKT(g.Projection()))
+	assert.Equal(t, 26905, wktSRID(g.Projection()))
+}
+
+func TestProjection(t *testing.T) {
+	// UTM zone 10 north has its central meridian at -123
+	p, err := projectionOf(26910)
+	assert.Nil(t, err)
+	x, y := p.forward(-123, 0)
+	assert.InDelta(t, 500000, x, 1e-6)
+	assert.InDelta(t, 0, y, 1e-6)
+	// 0.9996 times the meridian arc from the equator to 45 degrees
+	_, y = p.forward(-123, 45)
+	assert.InDelta(t, 0.9996*4984944.378, y, 0.01)
+
+	for _, srid := range []int{26905, 6339, 32610, 32755, 3857, 4269} {
+		p, err := projectionOf(srid)
+		assert.Nil(t, err, srid)
+		zone := map[int]float64{26905: -153, 6339: -123, 32610: -123, 32755: 147, 3857: 0, 4269: 0}[srid]
+		for _, ll := range [][2]float64{{zone - 2.5, 21.3}, {zone + 1, -33.9}, {zone, 64.8}} {
+			x, y := p.forward(ll[0], ll[1])
+			lon, lat := p.inverse(x, y)
+			assert.InDelta(t, ll[0], lon, 1e-8, srid)
+			assert.InDelta(t, ll[1], lat, 1e-8, srid)
+		}
+	}
+	_, err = projectionOf(2229)
+	assert.Contains(t, fmt.Sprint(err), "unknown srid=2229")
+
+	assert.Equal(t, 26910, wktSRID(`PROJCS["NAD83 / UTM zone 10N",GEOGCS["NAD83",AUTHORITY["EPSG","4269"]],AUTHORITY["EPSG","26910"]]`))
+	assert.Equal(t, 6339, wktSRID(`PROJCRS["NAD83(2011) / UTM zone 10N",ID["EPSG",6339]]`))
+	assert.Equal(t, 0, wktSRID(`LOCAL_CS["unnamed"]`))
+}
@@ -1,20 +1,49 @@
 package elevation
 
 import (
-	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
 
-	"github.com/lukeroth/gdal"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
 )
 
 type Point struct {
-	FdId      int      `db:"fd_id"`
-	X         float64  `db:"x"`
-	Y         float64  `db:"y"`
-	Elevation *float64 `db:"ground_elev"` // pointer instead of value for nullable type
+	FdId      int                   `db:"fd_id"`
+	X         float64               `db:"x"`
+	Y         float64               `db:"y"`
+	Elevation *float64              `db:"ground_elev"`        // pointer instead of value for nullable type
+	Reason    types.ElevationReason `db:"ground_elev_reason"` // set instead of Elevation if the point can't be sampled
+
+	// provenance of Elevation
+	Tile       string                 `db:"ground_elev_source"`  // file name of the tile sampled
+	Resolution *float64               `db:"ground_elev_res"`     // pixel size of the tile in meters
+	Product    types.ElevationProduct `db:"ground_elev_product"` // National Map product of the tile, empty for local rasters
+
+	// set if X, Y were read from the shape geometry instead of the x, y columns
+	SRID  int      `db:"srid"`   // of X, Y, NullShapeSRID if the shape is null
+	AttrX *float64 `db:"attr_x"` // x, y columns to check against the geometry
+	AttrY *float64 `db:"attr_y"`
 }
 
 type Points []*Point
 
+// Partition is a unit of elevation work. Points are selected from the fd_id
+// range [MinFdId, MaxFdId) or, if Cell is set, from the half open cell
+// [MinX, MaxX) x [MinY, MaxY)
+type Partition struct {
+	MinFdId int
+	MaxFdId int
+	Cell    *BoundingBox
+}
+
+func (p Partition) String() string {
+	if p.Cell != nil {
+		return fmt.Sprintf("cell=%g,%g,%g,%g", p.Cell.MinX, p.Cell.MinY, p.Cell.MaxX, p.Cell.MaxY)
+	}
+	return fmt.Sprintf("fd_id=%d-%d", p.MinFdId, p.MaxFdId)
+}
+
 type BoundingBox struct {
 	MinX float64 `json:"minX"`
 	MaxX float64 `json:"maxX"`
@@ -27,6 +56,11 @@ func (p Point) NilElevation() bool {
 	return p.Elevation == nil
 }
 
+// Resolved checks whether Point holds either elevation data or the reason it has none
+func (p Point) Resolved() bool {
+	return p.Elevation != nil || p.Reason != ""
+}
+
 // Intersect checks whether a list of Points intersect with a National Map Item
 func (p Points) IsIntersecting(i Item) bool {
 	for _, point := range p {
@@ -66,11 +100,45 @@ func (p Points) BoundingBox() BoundingBox {
 	return b
 }
 
+// Overlaps reports whether the interiors of two boxes intersect
+func (b BoundingBox) Overlaps(o BoundingBox) bool {
+	return b.MinX < o.MaxX && o.MinX < b.MaxX && b.MinY < o.MaxY && o.MinY < b.MaxY
+}
+
 // Contains checks whether Point is within the BoundingBox
 func (b BoundingBox) Contains(p Point) bool {
 	return b.MinX <= p.X && p.X <= b.MaxX && b.MinY <= p.Y && p.Y <= b.MaxY
 }
 
+// ContainsBox checks whether o is within the BoundingBox
+func (b BoundingBox) ContainsBox(o BoundingBox) bool {
+	return b.MinX <= o.MinX && o.MaxX <= b.MaxX && b.MinY <= o.MinY && o.MaxY <= b.MaxY
+}
+
+// Buffer grows the box by meters on every side
+func (b BoundingBox) Buffer(meters float64) BoundingBox {
+	dy := meters / metersPerDegree
+	lat := math.Max(math.Abs(b.MinY), math.Abs(b.MaxY))
+	dx := dy / math.Max(math.Cos(lat*math.Pi/180), 0.01)
+	return BoundingBox{MinX: b.MinX - dx, MaxX: b.MaxX + dx, MinY: b.MinY - dy, MaxY: b.MaxY + dy}
+}
+
+// cells splits the box along a grid of size degrees, each cell is clipped to the box
+func (b BoundingBox) cells(size float64) []BoundingBox {
+	var cells []BoundingBox
+	for x := math.Floor(b.MinX/size) * size; x < b.MaxX; x += size {
+		for y := math.Floor(b.MinY/size) * size; y < b.MaxY; y += size {
+			cells = append(cells, BoundingBox{
+				MinX: math.Max(x, b.MinX),
+				MaxX: math.Min(x+size, b.MaxX),
+				MinY: math.Max(y, b.MinY),
+				MaxY: math.Min(y+size, b.MaxY),
+			})
+		}
+	}
+	return cells
+}
+
 // Intersect takes a list of Points and filter those not contained within the BoundingBox
 func (b BoundingBox) Intersect(p Points) Points {
 	var selectedPoints Points
@@ -82,50 +150,64 @@ func (b BoundingBox) Intersect(p Points) Points {
 	return selectedPoints
 }
 
-// gdalAccessor wraps around the golang gdal wrapper
-type gdalAccessor struct {
-	d *gdal.Dataset
-	r *gdal.RasterBand
+// rasterAccessor samples a band of a raster at lon/lat points
+type rasterAccessor struct {
+	r          raster
+	igt        [6]float64   // from the raster CRS to pixel coordinates
+	t          *transformer // from lon/lat to the raster CRS, nil for geographic rasters
+	tile       string       // file name, recorded as provenance of sampled points
+	resolution float64      // pixel height in meters
 }
 
-func newGDALAccessor(file string) (gdalAccessor, error) {
-	d, err := gdal.Open(file, gdal.ReadOnly)
+// newRasterAccessor samples band of file, bands are numbered from 1
+func newRasterAccessor(file string, band int) (rasterAccessor, error) {
+	r, err := openRaster(file, band)
 	if err != nil {
-		return gdalAccessor{}, err
+		return rasterAccessor{}, err
 	}
-	r := d.RasterBand(1)
-	return gdalAccessor{
-		d: &d,
-		r: &r,
-	}, nil
-}
-
-func (g gdalAccessor) close() {
-	g.d.Close()
-}
-
-func (g gdalAccessor) calculateElevation(rasterBBox BoundingBox, p *Point) error {
-	bufSizeX := int(1)
-	bufSizeY := int(1)
-	buf := make([]float32, bufSizeX*bufSizeY)
-	sizeX := g.r.XSize()
-	sizeY := g.r.YSize()
-	// https://gdal.org/tutorials/geotransforms_tut.html
-	// InvGeoTransform works exactly like GeoTransform, but converts from image coordinate space to goreference space
-	igt := g.d.InvGeoTransform()
-	row := int(igt[0] + p.X*igt[1] + p.Y*igt[2])
-	col := int(igt[3] + p.X*igt[4] + p.Y*igt[5])
-	if row < 0 || row > sizeX || col < 0 || col > sizeY {
-		return errors.New("Point lies outside Item BoundingBox")
+	g := rasterAccessor{
+		r:          r,
+		igt:        invGeoTransform(r.GeoTransform()),
+		tile:       filepath.Base(file),
+		resolution: pixelHeightMeters(r),
 	}
-	var err error
-	// C++ API
-	// https://gdal.org/api/gdalrasterband_cpp.html
-	err = g.r.IO(gdal.Read, row, col, bufSizeX, bufSizeY, buf, bufSizeX, bufSizeY, 0, 0)
-	if err != nil {
-		return err
+	if wkt := r.Projection(); !isGeographicWKT(wkt) {
+		g.t, err = newTransformer(GeographicSRID, "", 0, wkt)
+		if err != nil {
+			r.Close()
+			return rasterAccessor{}, err
+		}
+	}
+	return g, nil
+}
+
+// project returns the coordinates of p in the raster CRS
+func (g rasterAccessor) project(p *Point) (float64, float64, error) {
+	if g.t == nil {
+		return p.X, p.Y, nil
+	}
+	x, y := []float64{p.X}, []float64{p.Y}
+	err := g.t.transform(x, y)
+	return x[0], y[0], err
+}
+
+// metersPerDegree is the length of a degree of latitude, close enough for
+// reporting the resolution of geographic rasters
+const metersPerDegree = 111320.0
+
+// pixelHeightMeters converts the pixel height of geographic rasters to
+// meters, projected rasters are assumed to be in meters
+func pixelHeightMeters(r raster) float64 {
+	h := math.Abs(r.GeoTransform()[5])
+	if isGeographicWKT(r.Projection()) {
+		return h * metersPerDegree
+	}
+	return h
+}
+
+func (g rasterAccessor) close() {
+	if g.t != nil {
+		g.t.close()
 	}
-	v := float64(buf[0])
-	p.Elevation = &v
-	return nil
+	g.r.Close()
 }
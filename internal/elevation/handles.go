@@ -0,0 +1,127 @@
+package elevation
+
+import (
+	"container/list"
+	"os"
+	"sync"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/global"
+)
+
+// handles keeps the rasters sampled by every source and worker open across
+// batches
+var handles = newHandlePool(global.ELEVATION_GDAL_HANDLES)
+
+// handlePool is a bounded least recently used set of open rasters. A handle
+// is used by one goroutine at a time, handles in use are never closed and the
+// pool grows past its size until they are released. A raster whose file was
+// replaced since it was opened, e.g. a re-downloaded tile, is reopened
+type handlePool struct {
+	mu      sync.Mutex
+	size    int
+	entries map[handleKey]*list.Element // of *handle
+	lru     *list.List                  // front is the most recently used
+}
+
+type handleKey struct {
+	path string
+	band int
+}
+
+type handle struct {
+	key     handleKey
+	mu      sync.Mutex // held while the raster is sampled
+	g       rasterAccessor
+	info    os.FileInfo // of the file when it was opened
+	users   int         // goroutines holding or waiting on mu
+	evicted bool        // removed from the pool, closed once users drops to 0
+}
+
+func newHandlePool(size int) *handlePool {
+	return &handlePool{
+		size:    size,
+		entries: map[handleKey]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// acquire returns the open band of path for exclusive use until release
+func (hp *handlePool) acquire(path string, band int) (*handle, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	key := handleKey{path: path, band: band}
+
+	hp.mu.Lock()
+	if e, ok := hp.entries[key]; ok {
+		h := e.Value.(*handle)
+		// the modification time is not compared, it may be stamped by other tools
+		if os.SameFile(h.info, info) && h.info.Size() == info.Size() {
+			h.users++
+			hp.lru.MoveToFront(e)
+			hp.mu.Unlock()
+			h.mu.Lock()
+			return h, nil
+		}
+		hp.remove(e)
+	}
+	g, err := newRasterAccessor(path, band)
+	if err != nil {
+		hp.mu.Unlock()
+		return nil, err
+	}
+	h := &handle{key: key, g: g, info: info, users: 1}
+	hp.entries[key] = hp.lru.PushFront(h)
+	hp.trim()
+	hp.mu.Unlock()
+	h.mu.Lock()
+	return h, nil
+}
+
+// release hands h back to the pool
+func (hp *handlePool) release(h *handle) {
+	h.mu.Unlock()
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	h.users--
+	if h.evicted && h.users == 0 {
+		h.g.close()
+		return
+	}
+	hp.trim()
+}
+
+// evict closes every band of path, e.g. before the file is removed
+func (hp *handlePool) evict(path string) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	for key, e := range hp.entries {
+		if key.path == path {
+			hp.remove(e)
+		}
+	}
+}
+
+// trim closes the least recently used idle handles past the size of the pool
+func (hp *handlePool) trim() {
+	for e := hp.lru.Back(); e != nil && hp.lru.Len() > hp.size; {
+		prev := e.Prev()
+		if e.Value.(*handle).users == 0 {
+			hp.remove(e)
+		}
+		e = prev
+	}
+}
+
+// remove takes e out of the pool and closes it unless it is in use. hp.mu
+// must be held
+func (hp *handlePool) remove(e *list.Element) {
+	h := e.Value.(*handle)
+	hp.lru.Remove(e)
+	delete(hp.entries, h.key)
+	h.evicted = true
+	if h.users == 0 {
+		h.g.close()
+	}
+}
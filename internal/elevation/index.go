@@ -0,0 +1,226 @@
+package elevation
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+)
+
+// tileIndexName is the file the tile index is persisted to within the cache
+const tileIndexName = "index.json"
+
+// tileIndexes shares one index per cache directory between all accessors
+var tileIndexes = struct {
+	sync.Mutex
+	m map[string]*tileIndex
+}{m: map[string]*tileIndex{}}
+
+// tileIndex maps every tile of a DemCache to the TNM item it was downloaded
+// for, with a one degree grid for point lookups. The index is derived from the
+// tiles and their sidecars and is rebuilt from them if it is missing or stale,
+// so it can't get out of sync with the cache
+type tileIndex struct {
+	mu    sync.RWMutex
+	dir   string
+	tiles map[string]Item // keyed by file name
+	grid  map[[2]int][]string
+}
+
+// openTileIndex loads the index of a cache directory once per process
+func openTileIndex(dir string) (*tileIndex, error) {
+	key := filepath.Clean(dir)
+	tileIndexes.Lock()
+	defer tileIndexes.Unlock()
+	if x, ok := tileIndexes.m[key]; ok {
+		return x, nil
+	}
+	x := &tileIndex{dir: key, tiles: map[string]Item{}}
+	err := x.load()
+	if err != nil {
+		return nil, err
+	}
+	tileIndexes.m[key] = x
+	return x, nil
+}
+
+// load reads the persisted index and reconciles it with the cache directory
+func (x *tileIndex) load() error {
+	b, err := os.ReadFile(filepath.Join(x.dir, tileIndexName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &x.tiles)
+		if err != nil {
+			log.Printf("Rebuilding invalid tile index of dem cache=%s: %s", x.dir, err)
+			x.tiles = map[string]Item{}
+		}
+	}
+	tiles, err := DemCache{Dir: x.dir}.Tiles()
+	if err != nil {
+		return err
+	}
+	onDisk := map[string]bool{}
+	changed := false
+	for _, t := range tiles {
+		onDisk[t.Name()] = true
+		if _, ok := x.tiles[t.Name()]; !ok && t.Item != nil {
+			x.tiles[t.Name()] = *t.Item
+			changed = true
+		}
+	}
+	for name := range x.tiles {
+		if !onDisk[name] {
+			delete(x.tiles, name)
+			changed = true
+		}
+	}
+	x.buildGrid()
+	if changed {
+		return x.save()
+	}
+	return nil
+}
+
+func (x *tileIndex) buildGrid() {
+	x.grid = map[[2]int][]string{}
+	for name, i := range x.tiles {
+		x.addToGrid(name, i)
+	}
+}
+
+func (x *tileIndex) addToGrid(name string, i Item) {
+	b := i.BoundingBox
+	for gx := int(math.Floor(b.MinX)); gx <= int(math.Floor(b.MaxX)); gx++ {
+		for gy := int(math.Floor(b.MinY)); gy <= int(math.Floor(b.MaxY)); gy++ {
+			k := [2]int{gx, gy}
+			x.grid[k] = append(x.grid[k], name)
+			sort.Strings(x.grid[k])
+		}
+	}
+}
+
+// save writes the index to a temp file and renames it into place
+func (x *tileIndex) save() error {
+	b, err := json.MarshalIndent(x.tiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(x.dir, tileIndexName+".*.part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(x.dir, tileIndexName))
+}
+
+// add indexes a downloaded tile
+func (x *tileIndex) add(name string, i Item) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, ok := x.tiles[name]; ok {
+		return nil
+	}
+	x.tiles[name] = i
+	x.addToGrid(name, i)
+	return x.save()
+}
+
+// remove drops an evicted tile
+func (x *tileIndex) remove(name string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, ok := x.tiles[name]; !ok {
+		return nil
+	}
+	delete(x.tiles, name)
+	x.buildGrid()
+	return x.save()
+}
+
+// contains reports whether a tile is indexed
+func (x *tileIndex) contains(name string) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	_, ok := x.tiles[name]
+	return ok
+}
+
+// lookup returns the names of the tiles of a product that contain p, ordered by name
+func (x *tileIndex) lookup(p Point, product types.ElevationProduct) []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var names []string
+	for _, name := range x.grid[[2]int{int(math.Floor(p.X)), int(math.Floor(p.Y))}] {
+		i := x.tiles[name]
+		if i.Product() == product && i.BoundingBox.Contains(p) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// rasters returns the tiles of a product holding at least one of the points
+// and the points no tile holds. Tiles deleted from the cache by another
+// process, e.g. dem prune, are dropped from the index and their points count
+// as uncovered so that the tiles are downloaded again
+func (x *tileIndex) rasters(p Points, product types.ElevationProduct) ([]localRaster, Points) {
+	present := map[string]bool{}
+	var names []string
+	var uncovered Points
+	for _, point := range p {
+		covered := false
+		for _, name := range x.lookup(*point, product) {
+			ok, checked := present[name]
+			if !checked {
+				_, err := os.Stat(filepath.Join(x.dir, name))
+				ok = !errors.Is(err, os.ErrNotExist)
+				present[name] = ok
+				if ok {
+					names = append(names, name)
+				}
+			}
+			covered = covered || ok
+		}
+		if !covered {
+			uncovered = append(uncovered, point)
+		}
+	}
+	for name, ok := range present {
+		if ok {
+			continue
+		}
+		log.Printf("Dropping tile=%s deleted from dem cache=%s", name, x.dir)
+		err := x.remove(name)
+		if err != nil {
+			log.Printf("Unable to save the tile index of dem cache=%s: %s", x.dir, err)
+		}
+	}
+	sort.Strings(names)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var rasters []localRaster
+	for _, name := range names {
+		rasters = append(rasters, localRaster{
+			path:        filepath.Join(x.dir, name),
+			BoundingBox: x.tiles[name].BoundingBox,
+		})
+	}
+	return rasters, uncovered
+}
@@ -0,0 +1,175 @@
+package elevation
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+)
+
+// localRasterExts lists the file extensions picked up from a dem directory
+var localRasterExts = []string{".tif", ".tiff", ".vrt"}
+
+// localRaster is a single GeoTIFF or VRT of a LocalSource
+type localRaster struct {
+	path        string
+	BoundingBox BoundingBox
+}
+
+// LocalSource samples elevation from a directory of GeoTIFFs or a GDAL VRT
+// without any network access. Projected rasters are sampled at the lon/lat of
+// the points reprojected to their CRS. Rasters are tried in path order, the
+// first raster covering a point wins
+type LocalSource struct {
+	rasters  []localRaster
+	band     int
+	sampling types.SamplingMethod
+}
+
+// NewLocalSource indexes the extent of every raster under src. src is either
+// a single GeoTIFF / VRT or a directory searched recursively
+func NewLocalSource(src string, sampling types.SamplingMethod) (*LocalSource, error) {
+	return NewLocalBandSource(src, 1, sampling)
+}
+
+// NewLocalBandSource is NewLocalSource sampling band instead of the first
+// band, e.g. of a multi band hazard or land cover raster
+func NewLocalBandSource(src string, band int, sampling types.SamplingMethod) (*LocalSource, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid dem source=%s: %s", src, err))
+	}
+	var paths []string
+	if info.IsDir() {
+		err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && isLocalRaster(path) {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(paths)
+	} else {
+		if !isLocalRaster(src) {
+			return nil, errors.New(fmt.Sprintf("invalid dem source=%s, expected one of %s", src, strings.Join(localRasterExts, ", ")))
+		}
+		paths = []string{src}
+	}
+	if len(paths) == 0 {
+		return nil, errors.New(fmt.Sprintf("no rasters found in dem source=%s", src))
+	}
+
+	s := LocalSource{band: band, sampling: sampling}
+	for _, path := range paths {
+		b, err := rasterBoundingBox(path)
+		if err != nil {
+			return nil, err
+		}
+		// rasters rewritten in place since an earlier run keep their file,
+		// drop handles to them so the source reads them as they are now
+		handles.evict(path)
+		h, err := handles.acquire(path, band)
+		if err != nil {
+			return nil, err
+		}
+		handles.release(h)
+		s.rasters = append(s.rasters, localRaster{
+			path:        path,
+			BoundingBox: b,
+		})
+	}
+	log.Printf("Indexed %d rasters from dem source=%s", len(s.rasters), src)
+	return &s, nil
+}
+
+// GetElevation fills the nil Elevation field for each point covered by a
+// raster. Points outside of every raster are marked with the NoCoverage reason
+func (s *LocalSource) GetElevation(p Points) error {
+	err := sampleRasters(s.rasters, s.band, p, s.sampling)
+	if err != nil {
+		return err
+	}
+	for _, point := range p {
+		if point.Resolved() {
+			continue
+		}
+		covered := false
+		for _, r := range s.rasters {
+			covered = covered || r.BoundingBox.Contains(*point)
+		}
+		if !covered {
+			point.Reason = types.NoCoverage
+		}
+	}
+	return nil
+}
+
+func isLocalRaster(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range localRasterExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// rasterBoundingBox derives the lon/lat extent of a raster from its geotransform
+func rasterBoundingBox(path string) (BoundingBox, error) {
+	r, err := openRaster(path, 1)
+	if err != nil {
+		return BoundingBox{}, errors.New(fmt.Sprintf("unable to open raster=%s: %s", path, err))
+	}
+	defer r.Close()
+	gt := r.GeoTransform()
+	if gt[2] != 0 || gt[4] != 0 {
+		return BoundingBox{}, errors.New(fmt.Sprintf("rotated raster=%s is not supported", path))
+	}
+	w, h := r.Size()
+	x0, x1 := gt[0], gt[0]+gt[1]*float64(w)
+	y0, y1 := gt[3], gt[3]+gt[5]*float64(h)
+	wkt := r.Projection()
+	if isGeographicWKT(wkt) {
+		return BoundingBox{
+			MinX: math.Min(x0, x1),
+			MaxX: math.Max(x0, x1),
+			MinY: math.Min(y0, y1),
+			MaxY: math.Max(y0, y1),
+		}, nil
+	}
+	// the lon/lat extent of a projected raster is bounded by its edges, not its corners
+	t, err := newTransformer(0, wkt, GeographicSRID, "")
+	if err != nil {
+		return BoundingBox{}, errors.New(fmt.Sprintf("unable to reproject raster=%s: %s", path, err))
+	}
+	defer t.close()
+	var xs, ys []float64
+	const steps = 8
+	for i := 0; i <= steps; i++ {
+		f := float64(i) / steps
+		xs = append(xs, x0+f*(x1-x0), x0+f*(x1-x0), x0, x1)
+		ys = append(ys, y0, y1, y0+f*(y1-y0), y0+f*(y1-y0))
+	}
+	err = t.transform(xs, ys)
+	if err != nil {
+		return BoundingBox{}, errors.New(fmt.Sprintf("unable to reproject raster=%s: %s", path, err))
+	}
+	b := BoundingBox{MinX: math.Inf(1), MaxX: math.Inf(-1), MinY: math.Inf(1), MaxY: math.Inf(-1)}
+	for i := range xs {
+		b.MinX, b.MaxX = math.Min(b.MinX, xs[i]), math.Max(b.MaxX, xs[i])
+		b.MinY, b.MaxY = math.Min(b.MinY, ys[i]), math.Max(b.MaxY, ys[i])
+	}
+	return b, nil
+}
@@ -1,35 +1,131 @@
 package elevation
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"encoding/json"
 	"io/ioutil"
 
 	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/global"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
 )
 
+// nationalMapDatasets are the TNM datasets queried for each product
+var nationalMapDatasets = map[types.ElevationProduct]string{
+	types.OneMeter:       "Digital Elevation Model (DEM) 1 meter",
+	types.NinthArcSecond: "National Elevation Dataset (NED) 1/9 arc-second",
+	types.ThirdArcSecond: "National Elevation Dataset (NED) 1/3 arc-second",
+	types.OneArcSecond:   "National Elevation Dataset (NED) 1 arc-second",
+}
+
+// nationalMapTitles are the title prefixes of the items of each product,
+// used for items that don't list their datasets
+var nationalMapTitles = map[types.ElevationProduct]string{
+	types.OneMeter:       "USGS 1 Meter",
+	types.NinthArcSecond: "USGS 19 arc-second",
+	types.ThirdArcSecond: "USGS 13 arc-second",
+	types.OneArcSecond:   "USGS 1 arc-second",
+}
+
+// NationalMap locates the TNM Access API and the local directory that
+// downloaded tiles are cached in
+type NationalMap struct {
+	BaseURL     string // scheme://host[:port][/prefix], the products path is appended
+	CacheDir    string
+	RequestRate float64                  // product queries per second across all workers, 0 for no limit
+	Products    []types.ElevationProduct // best first, each point is sampled from the first product covering it
+}
+
+// defaultProducts are sampled if no Products are set, best first
+var defaultProducts = []types.ElevationProduct{
+	types.OneMeter,
+	types.NinthArcSecond,
+	types.ThirdArcSecond,
+	types.OneArcSecond,
+}
+
+// DefaultNationalMap points at the public TNM Access API
+func DefaultNationalMap() NationalMap {
+	return NationalMap{
+		BaseURL:     global.NATIONAL_MAP_URL,
+		CacheDir:    global.NATIONAL_MAP_CACHE_BASEPATH,
+		RequestRate: global.NATIONAL_MAP_REQUEST_RATE,
+		Products:    defaultProducts,
+	}
+}
+
+// products returns Products or the default products if none are set
+func (nm NationalMap) products() []types.ElevationProduct {
+	if len(nm.Products) == 0 {
+		return defaultProducts
+	}
+	return nm.Products
+}
+
+// cacheDir returns CacheDir with a trailing separator, cache keys are built by concatenation
+func (nm NationalMap) cacheDir() string {
+	return strings.TrimSuffix(nm.CacheDir, "/") + "/"
+}
+
+// download fetches the tile of an item into the cache unless it is there
+// already and records the item next to it. Callers only pass items of the
+// product they queried, so the product is derivable from the sidecar
+func (nm NationalMap) download(i Item) error {
+	key, err := i.cacheKey(nm)
+	if err != nil {
+		return err
+	}
+	err = downloadTile(i.DownloadURL, key, i.SizeInBytes)
+	if err != nil {
+		return err
+	}
+	err = saveTileMeta(key, i)
+	if err != nil {
+		return err
+	}
+	index, err := openTileIndex(nm.CacheDir)
+	if err != nil {
+		return err
+	}
+	return index.add(filepath.Base(key), i)
+}
+
 type Query struct {
 	u      *url.URL
 	params map[string]string
+	rate   float64
 }
 
-func NewNationalMapQuery() Query {
-	u := url.URL{
-		Scheme: global.NATIONAL_MAP_SCHEME,
-		Host:   global.NATIONAL_MAP_HOST,
-		Path:   global.NATIONAL_MAP_PATH,
+// NewNationalMapQuery queries the GeoTIFF items of a product
+func NewNationalMapQuery(nm NationalMap, product types.ElevationProduct) (Query, error) {
+	u, err := url.Parse(nm.BaseURL)
+	if err != nil {
+		return Query{}, err
 	}
+	if u.Scheme == "" || u.Host == "" {
+		return Query{}, errors.New(fmt.Sprintf("invalid National Map url=%s, expected scheme://host", nm.BaseURL))
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + global.NATIONAL_MAP_PATH
 	qb := Query{
-		u:      &u,
+		u:      u,
 		params: make(map[string]string),
+		rate:   nm.RequestRate,
+	}
+	dataset, ok := nationalMapDatasets[product]
+	if !ok {
+		return Query{}, errors.New(fmt.Sprintf("unknown elevation product=%s", product))
 	}
-	qb.setParam("dataset", global.NATIONAL_MAP_DATASET)
+	qb.setParam("datasets", dataset)
 	qb.setParam("prodFormats", "GeoTIFF")
-	return qb
+	return qb, nil
 }
 
 func (q *Query) setParam(k string, v string) {
@@ -67,14 +163,48 @@ func (q *Query) QueryBoundingBox(b BoundingBox) (QueryResult, error) {
 	return r, err
 }
 
-// newQueryResult deserializes json bytes into a QueryResult struct
+// sendRequest pages through the results of the query. Results are cached by
+// query url until the next ResetQueryCache
 func (q *Query) sendRequest() (QueryResult, error) {
+	return tnmQueries.do(q.String(), q.sendPages)
+}
+
+// sendPages requests pages of NATIONAL_MAP_PAGE_SIZE items until Total items
+// were received, the API silently truncates larger results to its default page size
+func (q *Query) sendPages() (QueryResult, error) {
+	defer q.delParam("offset")
+	defer q.delParam("max")
+	var all QueryResult
+	for offset := 0; ; {
+		q.setParam("offset", strconv.Itoa(offset))
+		q.setParam("max", strconv.Itoa(global.NATIONAL_MAP_PAGE_SIZE))
+		r, err := q.sendPage()
+		if err != nil {
+			return QueryResult{}, err
+		}
+		items := all.Items
+		all = r
+		all.Items = append(items, r.Items...)
+		offset += len(r.Items)
+		if len(r.Items) == 0 || offset >= r.Total {
+			break
+		}
+	}
+	return all, nil
+}
+
+// sendPage deserializes a single page of results into a QueryResult struct
+func (q *Query) sendPage() (QueryResult, error) {
+	tnmLimiter.wait(q.rate)
 	u := q.String()
 	resp, err := http.Get(u)
 	if err != nil {
 		return QueryResult{}, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return QueryResult{}, errors.New(fmt.Sprintf("National Map query=%s failed with status=%s", u, resp.Status))
+	}
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return QueryResult{}, err
@@ -87,6 +217,80 @@ func (q *Query) sendRequest() (QueryResult, error) {
 	return r, nil
 }
 
+// tnmLimiter spaces out product queries of all workers
+var tnmLimiter rateLimiter
+
+type rateLimiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until the next request may be sent at rate requests per second
+func (l *rateLimiter) wait(rate float64) {
+	if rate <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	t := l.next
+	if t.Before(now) {
+		t = now
+	}
+	l.next = t.Add(time.Duration(float64(time.Second) / rate))
+	l.mu.Unlock()
+	time.Sleep(time.Until(t))
+}
+
+// tnmQueries caches query results by url for a run. Concurrent callers of the
+// same query wait for the first one, failed queries are not cached
+var tnmQueries = queryCache{calls: map[string]*queryCall{}}
+
+// ResetQueryCache forgets the query results of earlier runs so that a run
+// sees the items the National Map published since
+func ResetQueryCache() {
+	tnmQueries.reset()
+}
+
+type queryCall struct {
+	wg  sync.WaitGroup
+	r   QueryResult
+	err error
+}
+
+type queryCache struct {
+	mu    sync.Mutex
+	calls map[string]*queryCall
+}
+
+func (c *queryCache) do(key string, f func() (QueryResult, error)) (QueryResult, error) {
+	c.mu.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		call.wg.Wait()
+		return call.r, call.err
+	}
+	call := &queryCall{}
+	call.wg.Add(1)
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	call.r, call.err = f()
+	call.wg.Done()
+	if call.err != nil {
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+	}
+	return call.r, call.err
+}
+
+// reset drops every cached result, callers waiting on a query still get it
+func (c *queryCache) reset() {
+	c.mu.Lock()
+	c.calls = map[string]*queryCall{}
+	c.mu.Unlock()
+}
+
 type QueryResult struct {
 	Total            int      `json:"total"`
 	Items            []Item   `json:"items"`
@@ -128,8 +332,26 @@ type Urls struct {
 	Tiff string `json:"TIFF"`
 }
 
+// Product is the elevation product of the item, derived from its datasets or
+// its title. Empty for items of other products
+func (i Item) Product() types.ElevationProduct {
+	for _, d := range i.Datasets {
+		for p, dataset := range nationalMapDatasets {
+			if d == dataset {
+				return p
+			}
+		}
+	}
+	for p, title := range nationalMapTitles {
+		if strings.HasPrefix(i.Title, title+" ") {
+			return p
+		}
+	}
+	return ""
+}
+
 // cacheKey generates a key to the data file within the key/value store
-func (i Item) cacheKey() (string, error) {
+func (i Item) cacheKey(nm NationalMap) (string, error) {
 	urlTokens := strings.Split(i.DownloadURL, "/")
-	return url.QueryUnescape(fmt.Sprintf(`%s%s`, global.NATIONAL_MAP_CACHE_BASEPATH, urlTokens[len(urlTokens)-1]))
+	return url.QueryUnescape(fmt.Sprintf(`%s%s`, nm.cacheDir(), urlTokens[len(urlTokens)-1]))
 }
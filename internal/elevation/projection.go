@@ -0,0 +1,146 @@
+package elevation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// projection converts between lon/lat and the coordinates of a CRS without
+// GDAL/OSR. Only the CRSs of the National Map DEMs and of web maps are known,
+// see projectionOf
+type projection interface {
+	forward(lon float64, lat float64) (float64, float64)
+	inverse(x float64, y float64) (float64, float64)
+}
+
+// pureGeographicSRIDs are lon/lat CRSs, NAD83 (2011) and NSRS2007 are used as
+// is like NAD83
+var pureGeographicSRIDs = []int{GeographicSRID, 4269, 4759, 6318}
+
+// projectionOf returns the projection of an EPSG code: lon/lat, web mercator
+// (3857) or a UTM zone of NAD83 (269xx), NAD83 (2011) (6328-6348) or WGS84
+// (326xx, 327xx)
+func projectionOf(srid int) (projection, error) {
+	for _, s := range pureGeographicSRIDs {
+		if s == srid {
+			return geographic{}, nil
+		}
+	}
+	if srid == 3857 {
+		return webMercator{}, nil
+	}
+	zone, south := 0, false
+	switch {
+	case srid >= 26901 && srid <= 26923:
+		zone = srid - 26900
+	case srid >= 6330 && srid <= 6348:
+		zone = srid - 6329
+	case srid == 6328 || srid == 6329:
+		zone = srid - 6328 + 59
+	case srid >= 32601 && srid <= 32660:
+		zone = srid - 32600
+	case srid >= 32701 && srid <= 32760:
+		zone, south = srid-32700, true
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown srid=%d, only lon/lat, 3857 and UTM zones can be reprojected without GDAL", srid))
+	}
+	tm := transverseMercator{lon0: float64(zone*6 - 183), k0: 0.9996, fe: 500000}
+	if south {
+		tm.fn = 10000000
+	}
+	return tm, nil
+}
+
+// wktAuthority matches the EPSG code of a WKT 1 AUTHORITY or WKT 2 ID node
+var wktAuthority = regexp.MustCompile(`(?:AUTHORITY\["EPSG",\s*"?|ID\["EPSG",\s*)(\d+)`)
+
+// wktSRID returns the EPSG code of the root of a WKT, the last authority in
+// it, 0 if it has none
+func wktSRID(wkt string) int {
+	m := wktAuthority.FindAllStringSubmatch(wkt, -1)
+	if len(m) == 0 {
+		return 0
+	}
+	srid, _ := strconv.Atoi(m[len(m)-1][1])
+	return srid
+}
+
+type geographic struct{}
+
+func (geographic) forward(lon float64, lat float64) (float64, float64) { return lon, lat }
+func (geographic) inverse(x float64, y float64) (float64, float64)     { return x, y }
+
+// webMercator is EPSG:3857, spherical mercator on the WGS84 semi major axis
+type webMercator struct{}
+
+const grs80A = 6378137.0
+const grs80F = 1 / 298.257222101
+
+func (webMercator) forward(lon float64, lat float64) (float64, float64) {
+	return grs80A * lon * math.Pi / 180, grs80A * math.Log(math.Tan(math.Pi/4+lat*math.Pi/360))
+}
+
+func (webMercator) inverse(x float64, y float64) (float64, float64) {
+	return x / grs80A * 180 / math.Pi, (2*math.Atan(math.Exp(y/grs80A)) - math.Pi/2) * 180 / math.Pi
+}
+
+// transverseMercator on the GRS80 ellipsoid, which WGS84 matches to a tenth
+// of a millimeter, with the series of Snyder, Map Projections - A Working
+// Manual (USGS PP 1395), accurate to a millimeter within a UTM zone
+type transverseMercator struct {
+	lon0   float64 // central meridian in degrees
+	k0     float64 // scale on the central meridian
+	fe, fn float64 // false easting and northing
+}
+
+func (tm transverseMercator) forward(lon float64, lat float64) (float64, float64) {
+	e2 := grs80F * (2 - grs80F)
+	ep2 := e2 / (1 - e2)
+	phi := lat * math.Pi / 180
+	sin, cos, tan := math.Sin(phi), math.Cos(phi), math.Tan(phi)
+	n := grs80A / math.Sqrt(1-e2*sin*sin)
+	t := tan * tan
+	c := ep2 * cos * cos
+	a := (lon - tm.lon0) * math.Pi / 180 * cos
+	m := meridianArc(phi, e2)
+	x := tm.k0*n*(a+(1-t+c)*math.Pow(a, 3)/6+(5-18*t+t*t+72*c-58*ep2)*math.Pow(a, 5)/120) + tm.fe
+	y := tm.k0*(m+n*tan*(a*a/2+(5-t+9*c+4*c*c)*math.Pow(a, 4)/24+(61-58*t+t*t+600*c-330*ep2)*math.Pow(a, 6)/720)) + tm.fn
+	return x, y
+}
+
+func (tm transverseMercator) inverse(x float64, y float64) (float64, float64) {
+	e2 := grs80F * (2 - grs80F)
+	ep2 := e2 / (1 - e2)
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+	m := (y - tm.fn) / tm.k0
+	mu := m / (grs80A * (1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256))
+	phi1 := mu +
+		(3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+		(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+		(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+		(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+	sin, cos, tan := math.Sin(phi1), math.Cos(phi1), math.Tan(phi1)
+	c1 := ep2 * cos * cos
+	t1 := tan * tan
+	n1 := grs80A / math.Sqrt(1-e2*sin*sin)
+	r1 := grs80A * (1 - e2) / math.Pow(1-e2*sin*sin, 1.5)
+	d := (x - tm.fe) / (n1 * tm.k0)
+	phi := phi1 - (n1*tan/r1)*(d*d/2-
+		(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+		(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+	lon := (d - (1+2*t1+c1)*math.Pow(d, 3)/6 +
+		(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120) / cos
+	return tm.lon0 + lon*180/math.Pi, phi * 180 / math.Pi
+}
+
+// meridianArc is the distance from the equator to latitude phi along a meridian
+func meridianArc(phi float64, e2 float64) float64 {
+	e4, e6 := e2*e2, e2*e2*e2
+	return grs80A * ((1-e2/4-3*e4/64-5*e6/256)*phi -
+		(3*e2/8+3*e4/32+45*e6/1024)*math.Sin(2*phi) +
+		(15*e4/256+45*e6/1024)*math.Sin(4*phi) -
+		(35*e6/3072)*math.Sin(6*phi))
+}
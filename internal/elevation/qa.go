@@ -0,0 +1,81 @@
+package elevation
+
+import (
+	"math"
+	"sort"
+)
+
+// Distance is the distance in meters between two lon/lat points, using an
+// equirectangular approximation that is accurate over the short distances of
+// a neighborhood
+func Distance(a Point, b Point) float64 {
+	dx := (b.X - a.X) * metersPerDegree * math.Cos((a.Y+b.Y)/2*math.Pi/180)
+	dy := (b.Y - a.Y) * metersPerDegree
+	return math.Hypot(dx, dy)
+}
+
+// NeighborMedians returns, for each point of p, the median elevation of the
+// points of all within radius meters of it and their count. A point is not its
+// own neighbor and points of all without elevation are ignored
+func NeighborMedians(p Points, all Points, radius float64) ([]float64, []int) {
+	// grid of radius sized cells in latitude, longitude cells are as many
+	// degrees wide and searched further out away from the equator
+	size := radius / metersPerDegree
+	grid := map[[2]int]Points{}
+	for _, point := range all {
+		if point.NilElevation() {
+			continue
+		}
+		k := [2]int{int(math.Floor(point.X / size)), int(math.Floor(point.Y / size))}
+		grid[k] = append(grid[k], point)
+	}
+	medians := make([]float64, len(p))
+	counts := make([]int, len(p))
+	for i, point := range p {
+		kx, ky := int(math.Floor(point.X/size)), int(math.Floor(point.Y/size))
+		nx := int(math.Ceil(1 / math.Max(math.Cos(point.Y*math.Pi/180), 0.01)))
+		var elevations []float64
+		for x := kx - nx; x <= kx+nx; x++ {
+			for y := ky - 1; y <= ky+1; y++ {
+				for _, n := range grid[[2]int{x, y}] {
+					if n.FdId == point.FdId || Distance(*point, *n) > radius {
+						continue
+					}
+					elevations = append(elevations, *n.Elevation)
+				}
+			}
+		}
+		medians[i] = Median(elevations)
+		counts[i] = len(elevations)
+	}
+	return medians, counts
+}
+
+// Ring returns the points radius meters north, north east, east, ... of p
+func Ring(p Point, radius float64) Points {
+	var ring Points
+	dy := radius / metersPerDegree
+	dx := dy / math.Max(math.Cos(p.Y*math.Pi/180), 0.01)
+	for i := 0; i < 8; i++ {
+		a := float64(i) * math.Pi / 4
+		ring = append(ring, &Point{
+			FdId: p.FdId,
+			X:    p.X + dx*math.Sin(a),
+			Y:    p.Y + dy*math.Cos(a),
+		})
+	}
+	return ring
+}
+
+// Median of v, NaN if v is empty. v is sorted in place
+func Median(v []float64) float64 {
+	if len(v) == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(v)
+	m := len(v) / 2
+	if len(v)%2 == 0 {
+		return (v[m-1] + v[m]) / 2
+	}
+	return v[m]
+}
@@ -0,0 +1,32 @@
+package elevation
+
+// raster is a band of a raster file open for sampling. It is read with GDAL,
+// or with the pure Go GeoTIFF reader in builds with the purego tag
+type raster interface {
+	// Size is the width and height in pixels
+	Size() (int, int)
+	// BlockSize is the width and height of the blocks the band is stored in
+	BlockSize() (int, int)
+	NoDataValue() (float64, bool)
+	// GeoTransform maps pixel to raster CRS coordinates like GDAL
+	GeoTransform() [6]float64
+	// Projection is the WKT of the raster CRS, empty if it has none
+	Projection() string
+	// Read copies the w x h window at col, row into buf, row major
+	Read(col int, row int, w int, h int, buf []float32) error
+	Close()
+}
+
+// invGeoTransform inverts a geotransform like GDALInvGeoTransform, from
+// raster CRS to pixel coordinates
+func invGeoTransform(gt [6]float64) [6]float64 {
+	det := gt[1]*gt[5] - gt[2]*gt[4]
+	return [6]float64{
+		(gt[2]*gt[3] - gt[0]*gt[5]) / det,
+		gt[5] / det,
+		-gt[2] / det,
+		(-gt[1]*gt[3] + gt[0]*gt[4]) / det,
+		-gt[4] / det,
+		gt[1] / det,
+	}
+}
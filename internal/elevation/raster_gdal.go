@@ -0,0 +1,59 @@
+//go:build !purego
+
+package elevation
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lukeroth/gdal"
+)
+
+// gdalRaster reads any raster GDAL opens, VRTs included
+type gdalRaster struct {
+	d gdal.Dataset
+	r gdal.RasterBand
+}
+
+// openRaster opens band of path, bands are numbered from 1
+func openRaster(path string, band int) (raster, error) {
+	d, err := gdal.Open(path, gdal.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	if band < 1 || band > d.RasterCount() {
+		d.Close()
+		return nil, errors.New(fmt.Sprintf("raster=%s has %d bands, unable to sample band=%d", path, d.RasterCount(), band))
+	}
+	return gdalRaster{d: d, r: d.RasterBand(band)}, nil
+}
+
+func (g gdalRaster) Size() (int, int) {
+	return g.r.XSize(), g.r.YSize()
+}
+
+func (g gdalRaster) BlockSize() (int, int) {
+	return g.r.BlockSize()
+}
+
+func (g gdalRaster) NoDataValue() (float64, bool) {
+	return g.r.NoDataValue()
+}
+
+func (g gdalRaster) GeoTransform() [6]float64 {
+	return g.d.GeoTransform()
+}
+
+func (g gdalRaster) Projection() string {
+	return g.d.Projection()
+}
+
+func (g gdalRaster) Read(col int, row int, w int, h int, buf []float32) error {
+	// C++ API
+	// https://gdal.org/api/gdalrasterband_cpp.html
+	return g.r.IO(gdal.Read, col, row, w, h, buf, w, h, 0, 0)
+}
+
+func (g gdalRaster) Close() {
+	g.d.Close()
+}
@@ -0,0 +1,19 @@
+//go:build purego
+
+package elevation
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// openRaster opens band of path with the pure Go GeoTIFF reader, bands are
+// numbered from 1
+func openRaster(path string, band int) (raster, error) {
+	if strings.EqualFold(filepath.Ext(path), ".vrt") {
+		return nil, errors.New(fmt.Sprintf("raster=%s is a VRT, reading it requires a build with GDAL", path))
+	}
+	return openGeoTIFF(path, band)
+}
@@ -0,0 +1,241 @@
+package elevation
+
+import (
+	"math"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/global"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+)
+
+// sampleRasters resolves the unresolved points covered by band of the rasters. Points
+// whose kernel reaches past the edge of one raster are first left for an
+// overlapping neighbour, only points that no raster can sample in full are
+// sampled with the kernel clamped to the edge pixels
+func sampleRasters(rasters []localRaster, band int, p Points, method types.SamplingMethod) error {
+	for _, clamp := range []bool{false, true} {
+		for _, r := range rasters {
+			var pending Points
+			for _, point := range r.BoundingBox.Intersect(p) {
+				if !point.Resolved() {
+					pending = append(pending, point)
+				}
+			}
+			if len(pending) == 0 {
+				continue
+			}
+			h, err := handles.acquire(r.path, band)
+			if err != nil {
+				return err
+			}
+			err = h.g.samplePoints(method, pending, clamp)
+			handles.release(h)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pixelKernel holds the pixels a point is sampled from
+type pixelKernel struct {
+	point  *Point
+	cols   []int // kernel columns clamped to the raster, then the nearest column
+	rows   []int // kernel rows clamped to the raster, then the nearest row
+	wx, wy []float64
+}
+
+// window is a block of a raster band read into memory
+type window struct {
+	col, row int
+	w, h     int
+	buf      []float32
+}
+
+func (w window) at(col int, row int) float64 {
+	return float64(w.buf[(row-w.row)*w.w+col-w.col])
+}
+
+// samplePoints sets the elevation of each point, or the NoData reason if the
+// pixel holding it is nodata. Kernel pixels that are nodata fall back to the
+// nearest pixel. Unless clamp is set, points are left unresolved if their
+// kernel leaves the raster. Points are grouped by block aligned window of at
+// least ELEVATION_WINDOW_SIZE pixels a side, each window is read once
+func (g rasterAccessor) samplePoints(method types.SamplingMethod, p Points, clamp bool) error {
+	winW, winH := g.windowSize()
+	groups := map[[2]int][]pixelKernel{}
+	var keys [][2]int
+	for _, point := range p {
+		k, err := g.kernelAt(method, point, clamp)
+		if err != nil {
+			return err
+		}
+		if k == nil {
+			continue
+		}
+		key := [2]int{k.cols[len(k.cols)-1] / winW, k.rows[len(k.rows)-1] / winH}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], *k)
+	}
+
+	noData, hasNoData := g.r.NoDataValue()
+	valid := func(v float64) bool {
+		return !math.IsNaN(v) && !(hasNoData && v == noData)
+	}
+	for _, key := range keys {
+		kernels := groups[key]
+		// the window grows by the kernels reaching past its edges
+		var cols, rows []int
+		for _, k := range kernels {
+			cols = append(cols, k.cols...)
+			rows = append(rows, k.rows...)
+		}
+		minCol, maxCol := minMax(cols)
+		minRow, maxRow := minMax(rows)
+		win := window{col: minCol, row: minRow, w: maxCol - minCol + 1, h: maxRow - minRow + 1}
+		win.buf = make([]float32, win.w*win.h)
+		err := g.r.Read(win.col, win.row, win.w, win.h, win.buf)
+		if err != nil {
+			return err
+		}
+		for _, k := range kernels {
+			g.sampleKernel(k, win, valid)
+		}
+	}
+	return nil
+}
+
+// kernelAt locates the pixels p is sampled from, nil if p is outside the
+// raster or, unless clamp is set, its kernel leaves the raster
+func (g rasterAccessor) kernelAt(method types.SamplingMethod, p *Point, clamp bool) (*pixelKernel, error) {
+	// https://gdal.org/tutorials/geotransforms_tut.html
+	// the inverse geotransform converts from georeference space to image coordinate space
+	x, y, err := g.project(p)
+	if err != nil {
+		return nil, err
+	}
+	igt := g.igt
+	px := igt[0] + x*igt[1] + y*igt[2]
+	py := igt[3] + x*igt[4] + y*igt[5]
+	sizeX, sizeY := g.r.Size()
+	if px < 0 || px > float64(sizeX) || py < 0 || py > float64(sizeY) {
+		return nil, nil
+	}
+	cols, wx := kernel(method, px, sizeX)
+	rows, wy := kernel(method, py, sizeY)
+	if !clamp && (cols[0] < 0 || cols[len(cols)-1] >= sizeX || rows[0] < 0 || rows[len(rows)-1] >= sizeY) {
+		return nil, nil
+	}
+	nearestCol, _ := kernel(types.Nearest, px, sizeX)
+	nearestRow, _ := kernel(types.Nearest, py, sizeY)
+	return &pixelKernel{
+		point: p,
+		cols:  append(clampIdx(cols, sizeX), nearestCol[0]),
+		rows:  append(clampIdx(rows, sizeY), nearestRow[0]),
+		wx:    wx,
+		wy:    wy,
+	}, nil
+}
+
+// sampleKernel sets the elevation of the point of k from win
+func (g rasterAccessor) sampleKernel(k pixelKernel, win window, valid func(float64) bool) {
+	p := k.point
+	nearest := win.at(k.cols[len(k.cols)-1], k.rows[len(k.rows)-1])
+	p.Tile = g.tile
+	resolution := g.resolution
+	p.Resolution = &resolution
+	if !valid(nearest) {
+		p.Reason = types.NoData
+		return
+	}
+	v := 0.0
+	for j, row := range k.rows[:len(k.rows)-1] {
+		for i, col := range k.cols[:len(k.cols)-1] {
+			weight := k.wx[i] * k.wy[j]
+			if weight == 0 {
+				continue
+			}
+			pixel := win.at(col, row)
+			if !valid(pixel) {
+				p.Elevation = &nearest
+				return
+			}
+			v += weight * pixel
+		}
+	}
+	p.Elevation = &v
+}
+
+// windowSize is the raster block size grown to whole blocks of at least
+// ELEVATION_WINDOW_SIZE pixels a side
+func (g rasterAccessor) windowSize() (int, int) {
+	bw, bh := g.r.BlockSize()
+	grow := func(b int) int {
+		if b < 1 {
+			b = 1
+		}
+		return b * int(math.Ceil(float64(global.ELEVATION_WINDOW_SIZE)/float64(b)))
+	}
+	return grow(bw), grow(bh)
+}
+
+// kernel returns the pixel indices along one axis and their weights for a
+// continuous image coordinate, pixel centers sit at index + 0.5
+func kernel(method types.SamplingMethod, pos float64, size int) ([]int, []float64) {
+	switch method {
+	case types.Bilinear:
+		u := pos - 0.5
+		i := int(math.Floor(u))
+		f := u - float64(i)
+		return []int{i, i + 1}, []float64{1 - f, f}
+	case types.Bicubic:
+		u := pos - 0.5
+		i := int(math.Floor(u))
+		f := u - float64(i)
+		return []int{i - 1, i, i + 1, i + 2}, []float64{cubic(1 + f), cubic(f), cubic(1 - f), cubic(2 - f)}
+	default:
+		i := int(math.Floor(pos))
+		if i == size {
+			// point on the far edge of the raster
+			i = size - 1
+		}
+		return []int{i}, []float64{1}
+	}
+}
+
+// cubic is the catmull-rom convolution kernel (a = -0.5) at distance d
+func cubic(d float64) float64 {
+	const a = -0.5
+	d = math.Abs(d)
+	switch {
+	case d <= 1:
+		return (a+2)*d*d*d - (a+3)*d*d + 1
+	case d < 2:
+		return a*d*d*d - 5*a*d*d + 8*a*d - 4*a
+	default:
+		return 0
+	}
+}
+
+func clampIdx(idx []int, size int) []int {
+	out := make([]int, len(idx))
+	for i, v := range idx {
+		out[i] = int(math.Max(0, math.Min(float64(size-1), float64(v))))
+	}
+	return out
+}
+
+func minMax(idx []int) (int, int) {
+	lo, hi := idx[0], idx[0]
+	for _, v := range idx {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+	return lo, hi
+}
@@ -0,0 +1,37 @@
+//go:build purego
+
+package elevation
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/tnmtest"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSamplingPureGoUTM(t *testing.T) {
+	// a 1m style DEM in UTM zone 5 north holding its own easting plus northing
+	dir := t.TempDir()
+	r := tnmtest.NewRaster(820000, 830000, 2170000, 2180000, 500, 500, func(x, y float64) float64 { return (x + y) / 1000 })
+	r.EPSG = 26905
+	path := filepath.Join(dir, "USGS_1M_5_x82y218.tif")
+	f, err := os.Create(path)
+	assert.Nil(t, err)
+	assert.Nil(t, r.WriteTiledGeoTIFF(f, 256, tnmtest.LZW))
+	assert.Nil(t, f.Close())
+
+	s, err := NewLocalSource(dir, types.Bilinear)
+	assert.Nil(t, err)
+	utm, err := projectionOf(26905)
+	assert.Nil(t, err)
+	lon, lat := utm.inverse(825000, 2175000)
+	p := Points{{X: lon, Y: lat}, {X: -150, Y: 19.5}}
+	assert.Nil(t, s.GetElevation(p))
+	assert.InDelta(t, 3000, *p[0].Elevation, 1e-3)
+	assert.Equal(t, 20.0, math.Round(*p[0].Resolution))
+	assert.Equal(t, types.ElevationReason(types.NoCoverage), p[1].Reason)
+}
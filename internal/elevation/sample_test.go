@@ -0,0 +1,159 @@
+package elevation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/tnmtest"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestRaster(t *testing.T, path string, r tnmtest.Raster) {
+	f, err := os.Create(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	assert.Nil(t, r.WriteGeoTIFF(f))
+}
+
+func TestSampling(t *testing.T) {
+	dir := t.TempDir()
+	writeTestRaster(t, filepath.Join(dir, "a.tif"), tnmtest.NewRaster(-156, -155, 19, 20, 12, 12, tnmtest.Elevation))
+
+	for _, m := range []types.SamplingMethod{types.Nearest, types.Bilinear, types.Bicubic} {
+		s, err := NewLocalSource(dir, m)
+		assert.Nil(t, err)
+		p := Points{{X: -155.53, Y: 19.41}, {X: -150, Y: 19.5}}
+		assert.Nil(t, s.GetElevation(p))
+		assert.False(t, p[0].NilElevation(), m)
+		if m == types.Nearest {
+			// at most half a pixel away from the pixel center
+			assert.InDelta(t, tnmtest.Elevation(p[0].X, p[0].Y), *p[0].Elevation, 30.0/24, m)
+		} else {
+			// both interpolations reproduce a plane
+			assert.InDelta(t, tnmtest.Elevation(p[0].X, p[0].Y), *p[0].Elevation, 1e-3, m)
+		}
+		assert.True(t, p[1].NilElevation(), m)
+		assert.Equal(t, types.ElevationReason(types.NoCoverage), p[1].Reason, m)
+	}
+}
+
+func TestSamplingNoData(t *testing.T) {
+	dir := t.TempDir()
+	noData := -9999.0
+	r := tnmtest.NewRaster(-156, -155, 19, 20, 12, 12, tnmtest.Elevation)
+	r.NoData = &noData
+	r.Values[0] = float32(noData) // north west corner pixel
+	writeTestRaster(t, filepath.Join(dir, "a.tif"), r)
+
+	s, err := NewLocalSource(dir, types.Bilinear)
+	assert.Nil(t, err)
+	p := Points{
+		{X: -155.99, Y: 19.99}, // inside the nodata pixel
+		{X: -155.9, Y: 19.9},   // next to it, falls back to the nearest pixel
+	}
+	assert.Nil(t, s.GetElevation(p))
+	assert.True(t, p[0].NilElevation())
+	assert.Equal(t, types.ElevationReason(types.NoData), p[0].Reason)
+	assert.False(t, p[1].NilElevation())
+	assert.Equal(t, float64(r.Values[13]), *p[1].Elevation)
+}
+
+func TestSamplingTileBoundary(t *testing.T) {
+	// tiles overlap by two pixels, the National Map tiles overlap by six
+	dir := t.TempDir()
+	px := 1.0 / 12
+	writeTestRaster(t, filepath.Join(dir, "a.tif"), tnmtest.NewRaster(-156-2*px, -155+2*px, 19-2*px, 20+2*px, 16, 16, tnmtest.Elevation))
+	writeTestRaster(t, filepath.Join(dir, "b.tif"), tnmtest.NewRaster(-155-2*px, -154+2*px, 19-2*px, 20+2*px, 16, 16, tnmtest.Elevation))
+	s, err := NewLocalSource(dir, types.Bicubic)
+	assert.Nil(t, err)
+	p := Points{{X: -155 + px/4, Y: 19.5}, {X: -155, Y: 19.5}}
+	assert.Nil(t, s.GetElevation(p))
+	for _, point := range p {
+		assert.InDelta(t, tnmtest.Elevation(point.X, point.Y), *point.Elevation, 1e-3)
+	}
+
+	// without overlap the kernel is clamped to the edge of the tile
+	dir = t.TempDir()
+	writeTestRaster(t, filepath.Join(dir, "a.tif"), tnmtest.NewRaster(-156, -155, 19, 20, 12, 12, tnmtest.Elevation))
+	s, err = NewLocalSource(dir, types.Bilinear)
+	assert.Nil(t, err)
+	p = Points{{X: -155, Y: 19.5}}
+	assert.Nil(t, s.GetElevation(p))
+	assert.InDelta(t, tnmtest.Elevation(p[0].X, p[0].Y), *p[0].Elevation, 30.0/24)
+}
+
+func TestSamplingWindows(t *testing.T) {
+	// rows span three windows, points are sampled concurrently from shared handles
+	dir := t.TempDir()
+	writeTestRaster(t, filepath.Join(dir, "a.tif"), tnmtest.NewRaster(-156, -155, 19, 20, 600, 600, tnmtest.Elevation))
+	s, err := NewLocalSource(dir, types.Bicubic)
+	assert.Nil(t, err)
+	var wg sync.WaitGroup
+	batches := make([]Points, 4)
+	for i := range batches {
+		for j := 0; j < 200; j++ {
+			batches[i] = append(batches[i], &Point{X: -155.99 + float64(j)*0.0049, Y: 19.01 + float64(i*200+j)*0.0012})
+		}
+		wg.Add(1)
+		go func(p Points) {
+			defer wg.Done()
+			assert.Nil(t, s.GetElevation(p))
+		}(batches[i])
+	}
+	wg.Wait()
+	for _, p := range batches {
+		for _, point := range p {
+			assert.InDelta(t, tnmtest.Elevation(point.X, point.Y), *point.Elevation, 1e-3)
+		}
+	}
+}
+
+func TestHandlePool(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.tif"), filepath.Join(dir, "b.tif")
+	flat := func(v float64) tnmtest.Raster {
+		return tnmtest.NewRaster(-156, -155, 19, 20, 12, 12, func(x, y float64) float64 { return v })
+	}
+	writeTestRaster(t, a, flat(1))
+	writeTestRaster(t, b, flat(2))
+	pool := newHandlePool(1)
+
+	// handles in use are kept past the size of the pool
+	ha, err := pool.acquire(a, 1)
+	assert.Nil(t, err)
+	hb, err := pool.acquire(b, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, pool.lru.Len())
+	pool.release(ha)
+	assert.Equal(t, 1, pool.lru.Len())
+	assert.True(t, ha.evicted)
+	pool.release(hb)
+
+	// the same handle is handed out again, also once the file is stamped,
+	// until the file is replaced like a re-downloaded tile
+	future := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(b, future, future))
+	again, err := pool.acquire(b, 1)
+	assert.Nil(t, err)
+	assert.Same(t, hb, again)
+	pool.release(again)
+	writeTestRaster(t, b+".part", flat(3))
+	assert.Nil(t, os.Rename(b+".part", b))
+	reopened, err := pool.acquire(b, 1)
+	assert.Nil(t, err)
+	assert.NotSame(t, hb, reopened)
+	p := Points{{X: -155.5, Y: 19.5}}
+	assert.Nil(t, reopened.g.samplePoints(types.Nearest, p, false))
+	pool.release(reopened)
+	assert.Equal(t, 3.0, *p[0].Elevation)
+
+	pool.evict(b)
+	assert.Equal(t, 0, pool.lru.Len())
+	_, err = pool.acquire(a, 2)
+	assert.Contains(t, fmt.Sprint(err), "unable to sample band=2")
+}
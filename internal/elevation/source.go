@@ -0,0 +1,31 @@
+package elevation
+
+import "github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+
+// Source fills the nil Elevation field for a set of Points. Points that
+// can't be sampled get a Reason instead, points the source is unsure about
+// are left unresolved
+type Source interface {
+	GetElevation(p Points) error
+}
+
+var _ Source = NationalMapSource{}
+var _ Source = (*LocalSource)(nil)
+
+// NationalMapSource samples the cached National Map tiles through an
+// ElevationAccessor, querying the National Map for points outside of them
+type NationalMapSource struct {
+	NationalMap NationalMap
+	Sampling    types.SamplingMethod
+}
+
+func (s NationalMapSource) GetElevation(p Points) error {
+	if len(p) == 0 {
+		return nil
+	}
+	e, err := NewElevationAccessor(s.NationalMap, s.Sampling)
+	if err != nil {
+		return err
+	}
+	return e.GetElevation(p)
+}
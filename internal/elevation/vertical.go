@@ -0,0 +1,79 @@
+package elevation
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+)
+
+// metersPerFoot is the length of the international foot
+const metersPerFoot = 0.3048
+
+var _ Source = VerticalTransform{}
+
+// VerticalTransform converts the elevations of a Source, sampled in meters
+// above NAVD88, into another unit and optionally another vertical datum
+type VerticalTransform struct {
+	Source Source
+	Unit   types.ElevationUnit
+	// Geoid is a raster of the offsets in meters from NAVD88 to the target
+	// datum, empty to keep NAVD88
+	Geoid string
+}
+
+// GetElevation samples the source and converts every elevation. Points the
+// geoid grid holds no offset for lose their elevation and get the
+// NoDatumShift reason, a value in the wrong datum is worse than none
+func (v VerticalTransform) GetElevation(p Points) error {
+	err := v.Source.GetElevation(p)
+	if err != nil {
+		return err
+	}
+	if v.Geoid != "" {
+		err = v.shift(p)
+		if err != nil {
+			return err
+		}
+	}
+	if v.Unit == types.Feet {
+		for _, point := range p {
+			if !point.NilElevation() {
+				e := *point.Elevation / metersPerFoot
+				point.Elevation = &e
+			}
+		}
+	}
+	return nil
+}
+
+// shift adds the bilinear geoid offset at each point with an elevation
+func (v VerticalTransform) shift(p Points) error {
+	h, err := handles.acquire(v.Geoid, 1)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to open geoid grid=%s: %s", v.Geoid, err))
+	}
+	defer handles.release(h)
+	var shifted, offsets Points
+	for _, point := range p {
+		if !point.NilElevation() {
+			shifted = append(shifted, point)
+			offsets = append(offsets, &Point{X: point.X, Y: point.Y})
+		}
+	}
+	err = h.g.samplePoints(types.Bilinear, offsets, true)
+	if err != nil {
+		return err
+	}
+	for i, point := range shifted {
+		offset := offsets[i]
+		if offset.NilElevation() {
+			point.Elevation = nil
+			point.Reason = types.NoDatumShift
+			continue
+		}
+		e := *point.Elevation + *offset.Elevation
+		point.Elevation = &e
+	}
+	return nil
+}
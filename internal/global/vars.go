@@ -1,5 +1,7 @@
 package global
 
+import "time"
+
 // APP
 const (
 	APP_NAME    = "sael"
@@ -15,12 +17,34 @@ const (
 
 // ELEVATION
 const (
-	ELEVATION_COLUMN_NAME          = "ground_elev" // ground_elev is hardwired into struct tags, there are multiple source of truth for this value
+	ELEVATION_COLUMN_NAME          = "ground_elev"         // ground_elev is hardwired into struct tags, there are multiple source of truth for this value
+	ELEVATION_REASON_COLUMN_NAME   = "ground_elev_reason"  // why ground_elev was left null, see types.ElevationReason
+	ELEVATION_SOURCE_COLUMN_NAME   = "ground_elev_source"  // tile ground_elev was sampled from
+	ELEVATION_RES_COLUMN_NAME      = "ground_elev_res"     // pixel size in meters of the tile ground_elev was sampled from
+	ELEVATION_PRODUCT_COLUMN_NAME  = "ground_elev_product" // National Map product ground_elev was sampled from, see types.ElevationProduct
 	ELEVATION_BATCHSIZE            = 10000
-	ELEVATION_NO_PARALLEL_ROUTINES = 4
-	NATIONAL_MAP_DATASET           = "National Elevation Dataset (NED) 1/3 arc-second"
-	NATIONAL_MAP_SCHEME            = "https"
-	NATIONAL_MAP_HOST              = "tnmaccess.nationalmap.gov"
+	ELEVATION_NO_PARALLEL_ROUTINES = 4                                   // default --workers
+	ELEVATION_RETRY_BUDGET         = 10                                  // default --retries, failed batches retried per run
+	ELEVATION_TILE_SIZE            = 1.0                                 // degrees, cell size of tile partitions, matches the National Map tiles
+	ELEVATION_COORD_TOLERANCE      = 5.0                                 // meters the shape may be off the x, y columns before it is reported
+	ELEVATION_QA_RADIUS            = 250.0                               // default --radius, meters structures count as neighbors within
+	ELEVATION_QA_THRESHOLD         = 10.0                                // default --threshold, in the unit of ground_elev
+	ELEVATION_QA_MIN_SAMPLES       = 3                                   // neighbors or DEM pixels required to compare a structure against them
+	ELEVATION_QA_DEM_RADIUS        = 30.0                                // meters around a structure the DEM neighborhood is sampled at
+	ELEVATION_WINDOW_SIZE          = 256                                 // pixels, minimum side of the block aligned windows rasters are read in
+	ELEVATION_GDAL_HANDLES         = 64                                  // rasters kept open across batches and workers
+	ELEVATION_WORKER_IDLE          = 5 * time.Minute                     // default --idle, elevation worker sleep once no dataset has empty points
+	ELEVATION_LOCK_NAMESPACE       = 24871                               // first key of the advisory locks elevation workers take on datasets
+	NATIONAL_MAP_PRODUCTS          = "1m,1/9,1/3,1"                      // default --products, best first
+	NATIONAL_MAP_URL               = "https://tnmaccess.nationalmap.gov" // default TNM Access API, override with --tnmUrl
 	NATIONAL_MAP_PATH              = "api/v1/products"
+	NATIONAL_MAP_PAGE_SIZE         = 100 // items per products request, paged with offset and max
+	NATIONAL_MAP_REQUEST_RATE      = 5.0 // default --tnmRate, product queries per second
 	NATIONAL_MAP_CACHE_BASEPATH    = "./assets/dem/"
+	NATIONAL_MAP_VERTICAL_DATUM    = "NAVD88" // datum of the National Map DEMs, default --datum
+)
+
+// ENRICH
+const (
+	ENRICH_REPORT_LIMIT = 20 // fd_ids listed per problem by mod enrich
 )
@@ -0,0 +1,284 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/model"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/xls"
+	"gopkg.in/yaml.v3"
+)
+
+// TableMeta describes an existing inventory table that is adopted into the
+// catalog. Unlike MetaAccessor there is no shp file, field types are taken
+// from the table itself. It can be read from the same workbook used by
+// "sael mod inventory" or from a yaml file:
+//
+//	schema:
+//	  name: nsiDevSchema
+//	  version: 0.0.9
+//	  notes: optional
+//	dataset:
+//	  name: nsiLegacy
+//	  version: 2.0.0
+//	  description: optional
+//	  purpose: optional
+//	  createdBy: source
+//	  quality: high
+//	  group: nsidev
+//	fields:
+//	  - name: bid
+//	    description: optional
+//	    isDomain: false
+//	    isPrivate: false
+//	    index: true
+type TableMeta struct {
+	Schema  SchemaMeta  `yaml:"schema"`
+	Dataset DatasetMeta `yaml:"dataset"`
+	Fields  []FieldMeta `yaml:"fields"`
+}
+
+type SchemaMeta struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	Notes   string `yaml:"notes"`
+}
+
+type DatasetMeta struct {
+	Name        string `yaml:"name"`
+	Version     string `yaml:"version"`
+	Description string `yaml:"description"`
+	Purpose     string `yaml:"purpose"`
+	CreatedBy   string `yaml:"createdBy"`
+	Quality     string `yaml:"quality"`
+	Group       string `yaml:"group"`
+}
+
+// FieldMeta describes a single inventory column, Name is the column name
+type FieldMeta struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	IsDomain    bool   `yaml:"isDomain"`
+	IsPrivate   bool   `yaml:"isPrivate"`
+	Index       bool   `yaml:"index"`
+}
+
+// NewTableMeta reads table metadata from a .yaml/.yml or .xlsx file
+func NewTableMeta(src string) (TableMeta, error) {
+	log.Printf("Reading metadata from: %s\n", src)
+	var m TableMeta
+	var err error
+	switch strings.ToLower(filepath.Ext(src)) {
+	case ".yaml", ".yml":
+		m, err = readTableMetaYaml(src)
+	case ".xlsx":
+		m, err = readTableMetaXls(src)
+	default:
+		return TableMeta{}, errors.New(fmt.Sprintf("unsupported metadata file=%s, expected .xlsx, .yaml, or .yml", src))
+	}
+	if err != nil {
+		return TableMeta{}, err
+	}
+	err = m.validate()
+	if err != nil {
+		return TableMeta{}, err
+	}
+	return m, nil
+}
+
+func readTableMetaYaml(src string) (TableMeta, error) {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return TableMeta{}, err
+	}
+	var m TableMeta
+	err = yaml.Unmarshal(b, &m)
+	if err != nil {
+		return TableMeta{}, err
+	}
+	return m, nil
+}
+
+// readTableMetaXls reads the metadata workbook. Rows of the "field-domain"
+// sheet are read until the first empty dbName, rows not kept are skipped
+func readTableMetaXls(src string) (TableMeta, error) {
+	x, err := xls.NewXls(src)
+	if err != nil {
+		return TableMeta{}, err
+	}
+	var m TableMeta
+	for cell, dest := range map[string]*string{
+		"C1": &m.Schema.Name,
+		"C2": &m.Schema.Version,
+		"C3": &m.Schema.Notes,
+	} {
+		*dest, err = x.GetString("schema", cell)
+		if err != nil {
+			return TableMeta{}, err
+		}
+	}
+	for cell, dest := range map[string]*string{
+		"C1": &m.Dataset.Name,
+		"C2": &m.Dataset.Version,
+		"C3": &m.Dataset.Description,
+		"C4": &m.Dataset.Purpose,
+		"C5": &m.Dataset.CreatedBy,
+		"C6": &m.Dataset.Quality,
+		"C7": &m.Dataset.Group,
+	} {
+		*dest, err = x.GetString("dataset", cell)
+		if err != nil {
+			return TableMeta{}, err
+		}
+	}
+	for row := 2; ; row++ {
+		r := fmt.Sprint(row)
+		dbName, err := x.GetString("field-domain", "F"+r)
+		if err != nil {
+			return TableMeta{}, err
+		}
+		if dbName == "" {
+			break
+		}
+		flags := map[string]bool{}
+		for _, col := range []string{"C", "D", "E", "H"} {
+			v, err := x.GetString("field-domain", col+r)
+			if err != nil {
+				return TableMeta{}, err
+			}
+			if v == "" {
+				continue
+			}
+			flags[col], err = strconv.ParseBool(v)
+			if err != nil {
+				return TableMeta{}, errors.New(fmt.Sprintf("invalid boolean=%s at field-domain!%s%s", v, col, r))
+			}
+		}
+		if !flags["C"] {
+			continue
+		}
+		description, err := x.GetString("field-domain", "G"+r)
+		if err != nil {
+			return TableMeta{}, err
+		}
+		m.Fields = append(m.Fields, FieldMeta{
+			Name:        dbName,
+			Description: description,
+			IsDomain:    flags["D"],
+			IsPrivate:   flags["E"],
+			Index:       flags["H"],
+		})
+	}
+	return m, nil
+}
+
+func (m TableMeta) validate() error {
+	required := map[string]string{
+		"schema.name":     m.Schema.Name,
+		"schema.version":  m.Schema.Version,
+		"dataset.name":    m.Dataset.Name,
+		"dataset.version": m.Dataset.Version,
+		"dataset.quality": m.Dataset.Quality,
+		"dataset.group":   m.Dataset.Group,
+	}
+	for k, v := range required {
+		if v == "" {
+			return errors.New(fmt.Sprintf("invalid metadata, %s must not be empty", k))
+		}
+	}
+	if _, ok := types.QualityReverse[m.Dataset.Quality]; !ok {
+		return errors.New(fmt.Sprintf("invalid metadata, dataset.quality=%s must be %s, %s, or %s", m.Dataset.Quality, types.High, types.Medium, types.Low))
+	}
+	if len(m.Fields) == 0 {
+		return errors.New("invalid metadata, no fields specified")
+	}
+	return nil
+}
+
+// GetSchema returns the schema model, Id is left for the store to resolve
+func (m TableMeta) GetSchema() model.Schema {
+	return model.Schema{
+		Name:    m.Schema.Name,
+		Version: m.Schema.Version,
+		Notes:   m.Schema.Notes,
+	}
+}
+
+// GetFields maps the metadata fields onto the column types of the table.
+// Fails on fields that don't exist in the table or have an unsupported type
+func (m TableMeta) GetFields(columns map[string]string) ([]model.Field, error) {
+	var fields []model.Field
+	var missing []string
+	for _, f := range m.Fields {
+		pgType, ok := columns[f.Name]
+		if !ok {
+			missing = append(missing, f.Name)
+			continue
+		}
+		t, ok := types.DatatypePostgres[pgType]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("field=%s has unsupported column type=%s", f.Name, pgType))
+		}
+		fields = append(fields, model.Field{
+			ShpName:     f.Name,
+			DbName:      f.Name,
+			Type:        t,
+			Description: f.Description,
+			IsDomain:    f.IsDomain,
+			IsInDb:      true,
+			IsIndexed:   f.Index,
+		})
+	}
+	if len(missing) > 0 {
+		return nil, errors.New(fmt.Sprintf("table does not contain metadata fields=%s", strings.Join(missing, ", ")))
+	}
+	return fields, nil
+}
+
+// GetSchemaFieldAssociation links field f to schema s using the private flag from the metadata
+func (m TableMeta) GetSchemaFieldAssociation(s model.Schema, f model.Field) model.SchemaField {
+	sf := model.SchemaField{
+		Id:         s.Id,
+		NsiFieldId: f.Id,
+	}
+	for _, fm := range m.Fields {
+		if fm.Name == f.DbName {
+			sf.IsPrivate = fm.IsPrivate
+		}
+	}
+	return sf
+}
+
+func (m TableMeta) GetGroup() model.Group {
+	return model.Group{
+		Name: m.Dataset.Group,
+	}
+}
+
+func (m TableMeta) GetQuality() model.Quality {
+	return model.Quality{
+		Value: types.QualityReverse[m.Dataset.Quality],
+	}
+}
+
+// GetDataset returns the dataset model pointing at table. Quality must
+// already be resolved by the store
+func (m TableMeta) GetDataset(table string, schema model.Schema, g model.Group, q model.Quality) model.Dataset {
+	return model.Dataset{
+		Name:        m.Dataset.Name,
+		Version:     m.Dataset.Version,
+		SchemaId:    schema.Id,
+		TableName:   table,
+		Description: m.Dataset.Description,
+		Purpose:     m.Dataset.Purpose,
+		CreatedBy:   m.Dataset.CreatedBy,
+		QualityId:   q.Id,
+		GroupId:     g.Id,
+	}
+}
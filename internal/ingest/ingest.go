@@ -1,8 +1,10 @@
 package ingest
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
 
 	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/config"
 	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/model"
@@ -112,6 +114,18 @@ func (a MetaAccessor) GetFields() ([]model.Field, error) {
 		if err != nil {
 			return []model.Field{}, err
 		}
+		// index column is optional, older metadata files do not have it
+		indexStr, err := a.X.GetString("field-domain", "H"+fmt.Sprint(j+2))
+		if err != nil {
+			return []model.Field{}, err
+		}
+		var isIndexed bool
+		if indexStr != "" {
+			isIndexed, err = strconv.ParseBool(indexStr)
+			if err != nil {
+				return []model.Field{}, errors.New(fmt.Sprintf("invalid index value=%s for field=%s", indexStr, shpName))
+			}
+		}
 		if isInDb {
 			field := model.Field{
 				ShpName:     shpName,
@@ -120,6 +134,7 @@ func (a MetaAccessor) GetFields() ([]model.Field, error) {
 				Description: fieldDescription,
 				IsDomain:    isDomain,
 				IsInDb:      isInDb,
+				IsIndexed:   isIndexed,
 			}
 			fieldsModel = append(fieldsModel, field)
 		}
@@ -159,7 +174,7 @@ func (a MetaAccessor) GetDomainsForField(f model.Field) ([]model.Domain, error)
 	return domains, nil
 }
 
-func (a MetaAccessor) GetDataset(s *store.PSStore, schema model.Schema, g model.Group) (model.Dataset, error) {
+func (a MetaAccessor) GetDataset(s store.Store, schema model.Schema, g model.Group) (model.Dataset, error) {
 	datasetName, err := a.X.GetString("dataset", "C1")
 	if err != nil {
 		return model.Dataset{}, err
@@ -197,7 +212,7 @@ func (a MetaAccessor) GetDataset(s *store.PSStore, schema model.Schema, g model.
 	return dataset, nil
 }
 
-func (a MetaAccessor) GetQuality(s *store.PSStore) (model.Quality, error) {
+func (a MetaAccessor) GetQuality(s store.Store) (model.Quality, error) {
 	qs, err := a.X.GetString("dataset", "C6")
 	if err != nil {
 		return model.Quality{}, err
@@ -84,6 +84,11 @@ type Field struct {
 	Description string         `db:"description"`
 	IsDomain    bool           `db:"is_domain"`
 	IsInDb      bool           // store in db or remove
+	IsIndexed   bool           // build a btree index on the inventory column after load
+
+	// elevation fields only
+	Unit          *string `db:"unit"`
+	VerticalDatum *string `db:"vertical_datum"`
 }
 
 type SchemaField struct {
@@ -120,6 +125,71 @@ type Dataset struct {
 	GroupId     uuid.UUID `db:"group_id"`
 }
 
+// DatasetStats summarizes the inventory table of a dataset. It is maintained
+// incrementally after each load so that readers don't need to scan the table
+type DatasetStats struct {
+	DatasetId    uuid.UUID        `db:"dataset_id"`
+	RowCount     int64            `db:"row_count"`
+	MinX         float64          `db:"min_x"`
+	MinY         float64          `db:"min_y"`
+	MaxX         float64          `db:"max_x"`
+	MaxY         float64          `db:"max_y"`
+	Srid         int              `db:"srid"`
+	StateCounts  map[string]int64 // state fips -> row count
+	CountyCounts map[string]int64 // county fips -> row count
+	NullCounts   map[string]int64 // column name -> null count
+	LastLoad     time.Time        `db:"last_load"`
+}
+
+// ElevationRun records a mod elevation run of a dataset, Ended is nil while
+// the run is in progress or if it was killed
+type ElevationRun struct {
+	Id         uuid.UUID            `db:"id"`
+	DatasetId  uuid.UUID            `db:"dataset_id"`
+	Started    time.Time            `db:"started"`
+	Ended      *time.Time           `db:"ended"`
+	Source     string               `db:"source"`  // National Map url or local dem source
+	Product    string               `db:"product"` // elevation product sampled
+	Sampling   types.SamplingMethod `db:"sampling"`
+	Filled     int64                `db:"filled"`
+	Failed     int64                `db:"failed"`     // points left null with a reason
+	Mismatched int64                `db:"mismatched"` // points whose shape disagrees with their x, y columns
+	Error      *string              `db:"error"`      // error that ended the run
+}
+
+// ElevationReview is a ground elevation flagged by elevation qa. Expected is
+// the median of the Samples neighbors or DEM pixels the check compared against
+type ElevationReview struct {
+	DatasetId  uuid.UUID            `db:"dataset_id"`
+	FdId       int                  `db:"fd_id"`
+	X          float64              `db:"x"`
+	Y          float64              `db:"y"`
+	Elevation  float64              `db:"ground_elev"`
+	Check      types.ElevationCheck `db:"check_type"`
+	Expected   float64              `db:"expected"`
+	Difference float64              `db:"difference"` // Elevation - Expected
+	Samples    int                  `db:"samples"`
+	Created    time.Time            `db:"created"`
+}
+
+// PolygonLayer is an OGR vector source mod enrich reads Attribute from
+type PolygonLayer struct {
+	Path      string
+	Layer     string // empty for the first layer
+	Attribute string
+}
+
+// EnrichResult reports a point in polygon enrichment of an inventory column.
+// The id lists hold the first global.ENRICH_REPORT_LIMIT fd_ids
+type EnrichResult struct {
+	Updated     int // rows written, in polygons that agree on the value
+	NoMatch     int // rows in no polygon, left unchanged
+	Multiple    int // rows in several polygons
+	Conflicting int // rows of Multiple in polygons that disagree, left unchanged
+	NoMatchIds  []int
+	MultipleIds []int
+}
+
 type Group struct {
 	Id   uuid.UUID `db:"id"`
 	Name string    `db:"name"`
@@ -2,19 +2,24 @@ package shp
 
 import "fmt"
 
-// GenerateSqlArg generates the -sql argument required for ogr2ogr
-func GenerateSqlArg(shp2DbColMap map[string]string, shpFileName string) string {
-	sqlArg := `-sql "SELECT `
+// GenerateSqlArg generates the statement of the -sql argument required for
+// ogr2ogr. Columns listed in casts are wrapped in an OGR SQL CAST to the
+// given type
+func GenerateSqlArg(shp2DbColMap map[string]string, casts map[string]string, shpFileName string) string {
+	sqlArg := `SELECT`
 	i := 0
 	noElements := len(shp2DbColMap)
 	for k, v := range shp2DbColMap {
-		sqlArg += fmt.Sprintf(` %s AS %s`, k, v)
+		if cast, ok := casts[k]; ok {
+			sqlArg += fmt.Sprintf(` CAST(%s AS %s) AS %s`, k, cast, v)
+		} else {
+			sqlArg += fmt.Sprintf(` %s AS %s`, k, v)
+		}
 		if i < noElements-1 {
 			sqlArg += ","
 		}
 		i++
 	}
-	sqlArg += (` FROM \"` + shpFileName + `\"`)
-	sqlArg += `"`
+	sqlArg += ` FROM "` + shpFileName + `"`
 	return sqlArg
 }
@@ -0,0 +1,1260 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/elevation"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/global"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/model"
+	shape "github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/shp"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/util"
+	"github.com/google/uuid"
+	"github.com/jonas-p/go-shp"
+)
+
+// MemStore is an in-memory Store. It mirrors the unique constraints of the
+// PostGIS catalog (scripts/sql/schema_v2.sql) so that core logic can be tested
+// without a live database. Inventory tables only keep raw attribute strings
+// and the X, Y, elevation triplet used by the elevation flow.
+type MemStore struct {
+	mu           sync.Mutex
+	schemas      []model.Schema
+	fields       []model.Field
+	domains      []model.Domain
+	schemaFields []model.SchemaField
+	qualities    []model.Quality
+	groups       []model.Group
+	members      []model.Member
+	datasets     []model.Dataset
+	inventories  map[string]*memInventory // keyed by dataset.table_name
+	stats        map[uuid.UUID]model.DatasetStats
+	runs         []model.ElevationRun
+	reviews      []model.ElevationReview
+	locks        map[uuid.UUID]bool // datasets locked by TryLockElevation
+}
+
+type memInventory struct {
+	columns      map[string]string // column name -> postgres data type
+	rows         []memRow
+	hasElevation bool
+	indexes      []string // indexed columns
+	clustered    bool
+}
+
+type memRow struct {
+	attrs map[string]string // db column name -> raw value
+	point elevation.Point
+	shape *elevation.Point // X, Y and SRID of the shape if it differs from the x, y columns
+}
+
+// NewMemStore returns an empty catalog seeded with the same quality rows as
+// the database setup script
+func NewMemStore() *MemStore {
+	st := MemStore{
+		inventories: map[string]*memInventory{},
+		stats:       map[uuid.UUID]model.DatasetStats{},
+		locks:       map[uuid.UUID]bool{},
+	}
+	for _, q := range []types.Quality{types.High, types.Medium, types.Low} {
+		st.qualities = append(st.qualities, model.Quality{
+			Id:    uuid.New(),
+			Value: q,
+		})
+	}
+	return &st
+}
+
+func (st *MemStore) GetSchemaId(s *model.Schema) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s.Id = uuid.Nil
+	for _, e := range st.schemas {
+		if e.Name == s.Name && e.Version == s.Version {
+			s.Id = e.Id
+		}
+	}
+	return nil
+}
+
+func (st *MemStore) AddSchema(s *model.Schema) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, e := range st.schemas {
+		if e.Name == s.Name && e.Version == s.Version {
+			return errors.New("duplicate schema.name=" + s.Name + " and schema.version=" + s.Version)
+		}
+	}
+	s.Id = uuid.New()
+	st.schemas = append(st.schemas, *s)
+	return nil
+}
+
+func (st *MemStore) GetFieldId(f *model.Field) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	f.Id = uuid.Nil
+	for _, e := range st.fields {
+		if e.DbName == f.DbName && e.Type == f.Type {
+			f.Id = e.Id
+		}
+	}
+	return nil
+}
+
+func (st *MemStore) GetFieldsByName(f model.Field) ([]model.Field, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var fs []model.Field
+	for _, e := range st.fields {
+		if e.DbName == f.DbName {
+			fs = append(fs, e)
+		}
+	}
+	return fs, nil
+}
+
+func (st *MemStore) AddField(f *model.Field) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, e := range st.fields {
+		if e.DbName == f.DbName && e.Type == f.Type {
+			return errors.New("duplicate field.name=" + f.DbName + " and field.type=" + string(f.Type))
+		}
+	}
+	f.Id = uuid.New()
+	st.fields = append(st.fields, *f)
+	return nil
+}
+
+func (st *MemStore) UpdateFieldUnit(f model.Field) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for i, e := range st.fields {
+		if e.Id == f.Id {
+			st.fields[i].Unit = f.Unit
+			st.fields[i].VerticalDatum = f.VerticalDatum
+			return nil
+		}
+	}
+	return errors.New("field.id=" + f.Id.String() + " does not exist")
+}
+
+func (st *MemStore) AddDomain(d *model.Domain) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	d.Id = uuid.New()
+	st.domains = append(st.domains, *d)
+	return nil
+}
+
+// Domains returns the sorted domain values of field f, useful for assertions in tests
+func (st *MemStore) Domains(f model.Field) []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var values []string
+	for _, e := range st.domains {
+		if e.FieldId == f.Id {
+			values = append(values, e.Value)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+func (st *MemStore) SchemaFieldAssociationExists(sf model.SchemaField) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, e := range st.schemaFields {
+		if e.Id == sf.Id && e.NsiFieldId == sf.NsiFieldId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (st *MemStore) AddSchemaFieldAssociation(sf model.SchemaField) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.schemaFields = append(st.schemaFields, sf)
+	return nil
+}
+
+func (st *MemStore) GetQuality(q *model.Quality) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, e := range st.qualities {
+		if e.Value == q.Value {
+			*q = e
+			return nil
+		}
+	}
+	return errors.New("no rows exist for quality.value=" + string(q.Value))
+}
+
+func (st *MemStore) GetQualityId(q *model.Quality) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, e := range st.qualities {
+		if e.Value == q.Value {
+			q.Id = e.Id
+		}
+	}
+	return nil
+}
+
+func (st *MemStore) GetGroupId(g *model.Group) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, e := range st.groups {
+		if e.Name == g.Name {
+			g.Id = e.Id
+		}
+	}
+	return nil
+}
+
+func (st *MemStore) AddGroup(g *model.Group) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, e := range st.groups {
+		if e.Name == g.Name {
+			return errors.New("duplicate group.name=" + g.Name)
+		}
+	}
+	g.Id = uuid.New()
+	st.groups = append(st.groups, *g)
+	return nil
+}
+
+func (st *MemStore) GetMemberId(m *model.Member) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, e := range st.members {
+		if e.GroupId == m.GroupId && e.UserId == m.UserId {
+			m.Id = e.Id
+		}
+	}
+	return nil
+}
+
+func (st *MemStore) AddMember(m *model.Member) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, e := range st.members {
+		if e.GroupId == m.GroupId && e.UserId == m.UserId {
+			return errors.New(fmt.Sprintf("duplicate group_member.group_id=%s and group_member.user_id=%s", m.GroupId, m.UserId))
+		}
+	}
+	m.Id = uuid.New()
+	st.members = append(st.members, *m)
+	return nil
+}
+
+func (st *MemStore) UpdateMemberRole(m *model.Member) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for i, e := range st.members {
+		if e.Id == m.Id {
+			st.members[i].Role = m.Role
+		}
+	}
+	return nil
+}
+
+// Member returns the stored member row for m.Id, useful for assertions in tests
+func (st *MemStore) Member(m model.Member) (model.Member, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, e := range st.members {
+		if e.Id == m.Id {
+			return e, true
+		}
+	}
+	return model.Member{}, false
+}
+
+func (st *MemStore) GetDataset(d *model.Dataset) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, e := range st.datasets {
+		if e.Name == d.Name && e.Version == d.Version && e.QualityId == d.QualityId {
+			*d = e
+			return nil
+		}
+	}
+	d.Id = uuid.Nil
+	return nil
+}
+
+func (st *MemStore) GetDatasets() ([]model.Dataset, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return append([]model.Dataset(nil), st.datasets...), nil
+}
+
+func (st *MemStore) AddDataset(d *model.Dataset) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, e := range st.datasets {
+		if e.Name == d.Name && e.Version == d.Version && e.QualityId == d.QualityId {
+			return errors.New(fmt.Sprintf("duplicate dataset.name=%s dataset.version=%s dataset.quality_id=%s", d.Name, d.Version, d.QualityId))
+		}
+	}
+	d.Id = uuid.New()
+	d.DateCreated = time.Now()
+	st.datasets = append(st.datasets, *d)
+	return nil
+}
+
+// LoadShp copies the attributes selected by shp2DbName into the in-memory
+// inventory table. Casts are not applied, values are kept as raw strings
+func (st *MemStore) LoadShp(d model.Dataset, shpPath string, shp2DbName map[string]string, casts map[string]string, appendRows bool) error {
+	r, err := shp.Open(shpPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, exists := st.inventories[d.TableName]
+	if appendRows && !exists {
+		return errors.New(fmt.Sprintf("unable to append, table=%s does not exist", d.TableName))
+	}
+	if !appendRows {
+		if exists {
+			return errors.New(fmt.Sprintf("unable to create, table=%s already exists", d.TableName))
+		}
+		inv = &memInventory{
+			columns: map[string]string{"fd_id": "integer", "shape": "USER-DEFINED"},
+		}
+		st.inventories[d.TableName] = inv
+	}
+
+	fields := r.Fields()
+	for _, dbName := range shp2DbName {
+		inv.columns[dbName] = "text"
+	}
+	for i := 0; i < r.AttributeCount(); i++ {
+		row := memRow{
+			attrs: map[string]string{},
+			point: elevation.Point{FdId: len(inv.rows) + 1},
+		}
+		for j, f := range fields {
+			dbName, ok := shp2DbName[f.String()]
+			if !ok {
+				continue
+			}
+			row.attrs[dbName] = trimAttribute(r.ReadAttribute(i, j))
+		}
+		row.point.X, _ = strconv.ParseFloat(row.attrs["x"], 64)
+		row.point.Y, _ = strconv.ParseFloat(row.attrs["y"], 64)
+		inv.rows = append(inv.rows, row)
+	}
+	return nil
+}
+
+func (st *MemStore) ShpDataInStore(d model.Dataset, s *shp.Reader) (bool, error) {
+	xIdx, err := shape.FieldIdx(s, "X")
+	if err != nil {
+		return false, err
+	}
+	yIdx, err := shape.FieldIdx(s, "Y")
+	if err != nil {
+		return false, err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return false, nil
+	}
+	for i := 0; i < s.AttributeCount(); i++ {
+		x, _ := strconv.ParseFloat(trimAttribute(s.ReadAttribute(i, xIdx)), 64)
+		y, _ := strconv.ParseFloat(trimAttribute(s.ReadAttribute(i, yIdx)), 64)
+		for _, row := range inv.rows {
+			if row.point.X == x && row.point.Y == y {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (st *MemStore) GetMaxFdId(d model.Dataset) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	return len(inv.rows), nil
+}
+
+// GetInventoryStats summarizes rows loaded after sinceFdId. Empty attribute
+// values count as nulls
+func (st *MemStore) GetInventoryStats(d model.Dataset, sinceFdId int) (model.DatasetStats, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return model.DatasetStats{}, errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	stats := model.DatasetStats{
+		DatasetId:    d.Id,
+		StateCounts:  map[string]int64{},
+		CountyCounts: map[string]int64{},
+		NullCounts:   map[string]int64{},
+	}
+	var points elevation.Points
+	for i := range inv.rows {
+		row := inv.rows[i]
+		if row.point.FdId <= sinceFdId {
+			continue
+		}
+		points = append(points, &inv.rows[i].point)
+		if fips := row.attrs["cbfips2010"]; len(fips) >= 5 {
+			stats.CountyCounts[fips[:5]]++
+			stats.StateCounts[fips[:2]]++
+		}
+		for k, v := range row.attrs {
+			if v == "" {
+				stats.NullCounts[k]++
+			}
+		}
+	}
+	stats.RowCount = int64(len(points))
+	if stats.RowCount > 0 {
+		b := points.BoundingBox()
+		stats.MinX, stats.MinY, stats.MaxX, stats.MaxY = b.MinX, b.MinY, b.MaxX, b.MaxY
+		stats.Srid = 4326
+	}
+	return stats, nil
+}
+
+func (st *MemStore) GetDatasetStats(d model.Dataset) (model.DatasetStats, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s, ok := st.stats[d.Id]
+	if !ok {
+		return model.DatasetStats{
+			DatasetId:    d.Id,
+			StateCounts:  map[string]int64{},
+			CountyCounts: map[string]int64{},
+			NullCounts:   map[string]int64{},
+		}, nil
+	}
+	return s, nil
+}
+
+func (st *MemStore) SaveDatasetStats(s model.DatasetStats) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.stats[s.DatasetId] = s
+	return nil
+}
+
+func (st *MemStore) AddElevationRun(r *model.ElevationRun) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	r.Id = uuid.New()
+	st.runs = append(st.runs, *r)
+	return nil
+}
+
+func (st *MemStore) UpdateElevationRun(r model.ElevationRun) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for i := range st.runs {
+		if st.runs[i].Id == r.Id {
+			st.runs[i].Ended = r.Ended
+			st.runs[i].Filled = r.Filled
+			st.runs[i].Failed = r.Failed
+			st.runs[i].Mismatched = r.Mismatched
+			st.runs[i].Error = r.Error
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("elevation run=%s does not exist", r.Id))
+}
+
+func (st *MemStore) GetElevationRuns(d model.Dataset) ([]model.ElevationRun, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var runs []model.ElevationRun
+	for _, r := range st.runs {
+		if r.DatasetId == d.Id {
+			runs = append(runs, r)
+		}
+	}
+	return runs, nil
+}
+
+// OptimizeInventory records the indexed columns, failing like postgres would
+// on columns that were not loaded into the table
+func (st *MemStore) OptimizeInventory(d model.Dataset, columns []string, cluster bool) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	for _, c := range columns {
+		if len(inv.rows) > 0 {
+			if _, ok := inv.rows[0].attrs[c]; !ok {
+				return errors.New(fmt.Sprintf("column=%s does not exist on table=%s", c, d.TableName))
+			}
+		}
+		if !util.StrContains(inv.indexes, c) {
+			inv.indexes = append(inv.indexes, c)
+			sort.Strings(inv.indexes)
+		}
+	}
+	inv.clustered = inv.clustered || cluster
+	return nil
+}
+
+// AddTable creates an inventory table that was not loaded through LoadShp,
+// as if it had been created outside of the loader. Rows map column names to
+// raw values, x and y are parsed into the point of each row
+func (st *MemStore) AddTable(table string, columns map[string]string, rows []map[string]string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, exists := st.inventories[table]; exists {
+		return errors.New(fmt.Sprintf("unable to create, table=%s already exists", table))
+	}
+	inv := &memInventory{columns: map[string]string{}}
+	for k, v := range columns {
+		inv.columns[k] = v
+	}
+	for _, attrs := range rows {
+		row := memRow{attrs: map[string]string{}}
+		for k, v := range attrs {
+			row.attrs[k] = v
+		}
+		row.point.FdId, _ = strconv.Atoi(row.attrs["fd_id"])
+		row.point.X, _ = strconv.ParseFloat(row.attrs["x"], 64)
+		row.point.Y, _ = strconv.ParseFloat(row.attrs["y"], 64)
+		if v, err := strconv.ParseFloat(row.attrs[global.ELEVATION_COLUMN_NAME], 64); err == nil {
+			row.point.Elevation = &v
+		}
+		inv.rows = append(inv.rows, row)
+	}
+	inv.hasElevation = true
+	for _, c := range elevationColumns {
+		_, ok := inv.columns[c]
+		inv.hasElevation = inv.hasElevation && ok
+	}
+	if _, hasReason := inv.columns[global.ELEVATION_REASON_COLUMN_NAME]; hasReason {
+		for i := range inv.rows {
+			inv.rows[i].point.Reason = types.ElevationReason(inv.rows[i].attrs[global.ELEVATION_REASON_COLUMN_NAME])
+		}
+	}
+	st.inventories[table] = inv
+	return nil
+}
+
+func (st *MemStore) TableExists(table string) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	_, ok := st.inventories[table]
+	return ok, nil
+}
+
+func (st *MemStore) GetInventoryColumns(d model.Dataset) (map[string]string, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	columns := map[string]string{}
+	for k, v := range inv.columns {
+		columns[k] = v
+	}
+	return columns, nil
+}
+
+func (st *MemStore) GetDistinctValues(d model.Dataset, column string) ([]string, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	if _, ok := inv.columns[column]; !ok {
+		return nil, errors.New(fmt.Sprintf("column=%s does not exist on table=%s", column, d.TableName))
+	}
+	seen := map[string]bool{}
+	var values []string
+	for _, row := range inv.rows {
+		v := row.attrs[column]
+		if v != "" && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// AddFdIdColumn numbers the rows in insertion order
+func (st *MemStore) AddFdIdColumn(d model.Dataset) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	if _, ok := inv.columns["fd_id"]; ok {
+		return errors.New(fmt.Sprintf("column=fd_id already exists on table=%s", d.TableName))
+	}
+	inv.columns["fd_id"] = "integer"
+	for i := range inv.rows {
+		inv.rows[i].point.FdId = i + 1
+	}
+	return nil
+}
+
+// AddShapeColumn only records the column, points are already kept from x, y
+func (st *MemStore) AddShapeColumn(d model.Dataset) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	if _, ok := inv.columns["shape"]; ok {
+		return errors.New(fmt.Sprintf("column=shape already exists on table=%s", d.TableName))
+	}
+	inv.columns["shape"] = "USER-DEFINED"
+	return nil
+}
+
+// InventoryIndexes returns the sorted columns indexed on the inventory table of d
+func (st *MemStore) InventoryIndexes(d model.Dataset) []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return nil
+	}
+	return append([]string{}, inv.indexes...)
+}
+
+// InventoryPoints returns copies of every row point of the inventory table of d
+func (st *MemStore) InventoryPoints(d model.Dataset) elevation.Points {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return nil
+	}
+	var points elevation.Points
+	for _, row := range inv.rows {
+		p := row.point
+		points = append(points, &p)
+	}
+	return points
+}
+
+// InventoryValues returns the raw values of column in row order, empty for null
+func (st *MemStore) InventoryValues(d model.Dataset, column string) []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return nil
+	}
+	var values []string
+	for _, row := range inv.rows {
+		values = append(values, row.attrs[column])
+	}
+	return values
+}
+
+// InventoryCount returns the number of rows loaded into the inventory table of d
+func (st *MemStore) InventoryCount(d model.Dataset) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return 0
+	}
+	return len(inv.rows)
+}
+
+func (st *MemStore) ElevationColumnExists(d model.Dataset) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return false, nil
+	}
+	return inv.hasElevation, nil
+}
+
+func (st *MemStore) AddElevationColumn(d model.Dataset) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	inv.hasElevation = true
+	inv.columns[global.ELEVATION_COLUMN_NAME] = "double precision"
+	inv.columns[global.ELEVATION_REASON_COLUMN_NAME] = "text"
+	inv.columns[global.ELEVATION_SOURCE_COLUMN_NAME] = "text"
+	inv.columns[global.ELEVATION_RES_COLUMN_NAME] = "double precision"
+	inv.columns[global.ELEVATION_PRODUCT_COLUMN_NAME] = "text"
+	return nil
+}
+
+// emptyElevationRows returns the unresolved rows of an inventory table with an elevation column
+func (st *MemStore) emptyElevationRows(d model.Dataset) ([]memRow, error) {
+	return st.elevationRows(d, nil)
+}
+
+// elevationRows returns the rows f selects of an inventory table with an
+// elevation column, the unresolved rows if f is nil
+func (st *MemStore) elevationRows(d model.Dataset, f *elevation.Filter) ([]memRow, error) {
+	inv, ok := st.inventories[d.TableName]
+	if !ok || !inv.hasElevation {
+		return nil, errors.New(fmt.Sprintf("column=%s does not exist on table=%s", global.ELEVATION_COLUMN_NAME, d.TableName))
+	}
+	var rows []memRow
+	for _, row := range inv.rows {
+		if f == nil && !row.point.Resolved() || f != nil && f.Match(row.point, row.attrs["cbfips2010"]) {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+func (st *MemStore) GetEmptyElevationFdIdRange(d model.Dataset) (int, int, int, error) {
+	return st.elevationFdIdRange(d, nil)
+}
+
+func (st *MemStore) GetSelectedElevationFdIdRange(d model.Dataset, f elevation.Filter) (int, int, int, error) {
+	return st.elevationFdIdRange(d, &f)
+}
+
+func (st *MemStore) elevationFdIdRange(d model.Dataset, f *elevation.Filter) (int, int, int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	rows, err := st.elevationRows(d, f)
+	if err != nil || len(rows) == 0 {
+		return 0, 0, 0, err
+	}
+	minFdId, maxFdId := rows[0].point.FdId, rows[0].point.FdId
+	for _, row := range rows {
+		if row.point.FdId < minFdId {
+			minFdId = row.point.FdId
+		}
+		if row.point.FdId > maxFdId {
+			maxFdId = row.point.FdId
+		}
+	}
+	return len(rows), minFdId, maxFdId, nil
+}
+
+// TryLockElevation mimics the advisory lock of PSStore, a MemStore shared by
+// several workers hands each dataset to one of them at a time
+func (st *MemStore) TryLockElevation(d model.Dataset) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.locks[d.Id] {
+		return false, nil
+	}
+	st.locks[d.Id] = true
+	return true, nil
+}
+
+func (st *MemStore) UnlockElevation(d model.Dataset) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.locks[d.Id] {
+		return errors.New(fmt.Sprintf("dataset=%s is not locked", d.Name))
+	}
+	delete(st.locks, d.Id)
+	return nil
+}
+
+// nullCoordinates is true if the x or y column of row is null
+func nullCoordinates(row memRow) bool {
+	_, errX := strconv.ParseFloat(row.attrs["x"], 64)
+	_, errY := strconv.ParseFloat(row.attrs["y"], 64)
+	return errX != nil || errY != nil
+}
+
+func (st *MemStore) MarkNullCoordinates(d model.Dataset) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, err := st.emptyElevationRows(d); err != nil {
+		return 0, err
+	}
+	inv := st.inventories[d.TableName]
+	count := 0
+	for i := range inv.rows {
+		if !inv.rows[i].point.Resolved() && nullCoordinates(inv.rows[i]) {
+			inv.rows[i].point.Reason = types.NoGeometry
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (st *MemStore) GetEmptyElevationCells(d model.Dataset, size float64) ([]elevation.BoundingBox, error) {
+	return st.elevationCells(d, size, nil)
+}
+
+func (st *MemStore) GetSelectedElevationCells(d model.Dataset, size float64, f elevation.Filter) ([]elevation.BoundingBox, error) {
+	return st.elevationCells(d, size, &f)
+}
+
+func (st *MemStore) elevationCells(d model.Dataset, size float64, f *elevation.Filter) ([]elevation.BoundingBox, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	rows, err := st.elevationRows(d, f)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[[2]float64]bool{}
+	var cells []elevation.BoundingBox
+	for _, row := range rows {
+		if nullCoordinates(row) {
+			continue
+		}
+		k := [2]float64{math.Floor(row.point.X/size) * size, math.Floor(row.point.Y/size) * size}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		cells = append(cells, elevation.BoundingBox{MinX: k[0], MaxX: k[0] + size, MinY: k[1], MaxY: k[1] + size})
+	}
+	return cells, nil
+}
+
+func (st *MemStore) GetPointCells(d model.Dataset, size float64) ([]elevation.BoundingBox, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	seen := map[[2]float64]bool{}
+	var cells []elevation.BoundingBox
+	for _, row := range inv.rows {
+		if nullCoordinates(row) {
+			continue
+		}
+		k := [2]float64{math.Floor(row.point.X/size) * size, math.Floor(row.point.Y/size) * size}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		cells = append(cells, elevation.BoundingBox{MinX: k[0], MaxX: k[0] + size, MinY: k[1], MaxY: k[1] + size})
+	}
+	return cells, nil
+}
+
+// GetEmptyElevationPoints returns copies of the rows so that callers cannot
+// write to the store without going through UpdateElevationAtPoint
+func (st *MemStore) GetEmptyElevationPoints(d model.Dataset, part elevation.Partition, afterFdId int, count int) (elevation.Points, error) {
+	return st.elevationPoints(d, nil, part, afterFdId, count, true)
+}
+
+func (st *MemStore) GetSelectedElevationPoints(d model.Dataset, f elevation.Filter, part elevation.Partition, afterFdId int, count int) (elevation.Points, error) {
+	return st.elevationPoints(d, &f, part, afterFdId, count, true)
+}
+
+// elevationPoints skips the rows with a null x or y if xy is set, like the
+// statements reading X, Y from the columns
+func (st *MemStore) elevationPoints(d model.Dataset, f *elevation.Filter, part elevation.Partition, afterFdId int, count int, xy bool) (elevation.Points, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	rows, err := st.elevationRows(d, f)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].point.FdId < rows[j].point.FdId })
+	var points elevation.Points
+	for _, row := range rows {
+		p := row.point
+		if p.FdId <= afterFdId {
+			continue
+		}
+		if xy && nullCoordinates(row) {
+			continue
+		}
+		if part.Cell != nil {
+			c := part.Cell
+			if p.X < c.MinX || p.X >= c.MaxX || p.Y < c.MinY || p.Y >= c.MaxY {
+				continue
+			}
+		} else if p.FdId >= part.MaxFdId {
+			continue
+		}
+		if len(points) == count {
+			break
+		}
+		points = append(points, &p)
+	}
+	return points, nil
+}
+
+// GetEmptyElevationGeometries reads X, Y from the shape of each row, which is
+// the x, y columns in srid 4326 unless changed with SetShape
+func (st *MemStore) GetEmptyElevationGeometries(d model.Dataset, part elevation.Partition, afterFdId int, count int) (elevation.Points, error) {
+	return st.elevationGeometries(d, nil, part, afterFdId, count)
+}
+
+func (st *MemStore) GetSelectedElevationGeometries(d model.Dataset, f elevation.Filter, part elevation.Partition, afterFdId int, count int) (elevation.Points, error) {
+	return st.elevationGeometries(d, &f, part, afterFdId, count)
+}
+
+func (st *MemStore) elevationGeometries(d model.Dataset, f *elevation.Filter, part elevation.Partition, afterFdId int, count int) (elevation.Points, error) {
+	if part.Cell != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read geometries of %s, only fd_id partitions are supported", part))
+	}
+	points, err := st.elevationPoints(d, f, part, afterFdId, count, false)
+	if err != nil {
+		return nil, err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv := st.inventories[d.TableName]
+	for _, p := range points {
+		for _, row := range inv.rows {
+			if row.point.FdId != p.FdId {
+				continue
+			}
+			if x, err := strconv.ParseFloat(row.attrs["x"], 64); err == nil {
+				p.AttrX = &x
+			}
+			if y, err := strconv.ParseFloat(row.attrs["y"], 64); err == nil {
+				p.AttrY = &y
+			}
+			p.SRID = elevation.GeographicSRID
+			if row.shape != nil {
+				p.X, p.Y, p.SRID = row.shape.X, row.shape.Y, row.shape.SRID
+			}
+		}
+	}
+	return points, nil
+}
+
+// SetShape replaces the shape of a row, useful to test geometries that
+// disagree with the x, y columns. srid elevation.NullShapeSRID nulls the shape
+func (st *MemStore) SetShape(d model.Dataset, fdId int, x float64, y float64, srid int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	for i := range inv.rows {
+		if inv.rows[i].point.FdId == fdId {
+			inv.rows[i].shape = &elevation.Point{X: x, Y: y, SRID: srid}
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("fd_id=%d does not exist in table=%s", fdId, d.TableName))
+}
+
+func (st *MemStore) UpdateElevationAtPoint(d model.Dataset, points elevation.Points) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	for _, p := range points {
+		if !p.Resolved() {
+			continue
+		}
+		for i := range inv.rows {
+			if inv.rows[i].point.FdId == p.FdId {
+				inv.rows[i].point.Elevation = nil
+				if p.Elevation != nil {
+					v := *p.Elevation
+					inv.rows[i].point.Elevation = &v
+				}
+				inv.rows[i].point.Reason = p.Reason
+				inv.rows[i].point.Tile = p.Tile
+				inv.rows[i].point.Product = p.Product
+				inv.rows[i].point.Resolution = nil
+				if p.Resolution != nil {
+					v := *p.Resolution
+					inv.rows[i].point.Resolution = &v
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (st *MemStore) AddRasterColumn(d model.Dataset, column string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	if _, ok := inv.columns[column]; !ok {
+		inv.columns[column] = "double precision"
+	}
+	return nil
+}
+
+// GetPoints returns new points holding only fd_id, X and Y, skipping rows
+// without x, y
+func (st *MemStore) GetPoints(d model.Dataset, afterFdId int, count int) (elevation.Points, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	var points elevation.Points
+	for _, row := range inv.rows {
+		if row.point.FdId > afterFdId && !nullCoordinates(row) {
+			points = append(points, &elevation.Point{FdId: row.point.FdId, X: row.point.X, Y: row.point.Y})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].FdId < points[j].FdId })
+	if len(points) > count {
+		points = points[:count]
+	}
+	return points, nil
+}
+
+func (st *MemStore) CountNullCoordinates(d model.Dataset) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	count := 0
+	for _, row := range inv.rows {
+		if nullCoordinates(row) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (st *MemStore) UpdateColumnAtPoint(d model.Dataset, column string, points elevation.Points) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	if _, ok := inv.columns[column]; !ok {
+		return errors.New(fmt.Sprintf("column=%s does not exist on table=%s", column, d.TableName))
+	}
+	for _, p := range points {
+		for i := range inv.rows {
+			if inv.rows[i].point.FdId != p.FdId {
+				continue
+			}
+			inv.rows[i].attrs[column] = ""
+			if p.Elevation != nil {
+				inv.rows[i].attrs[column] = strconv.FormatFloat(*p.Elevation, 'g', -1, 64)
+			}
+		}
+	}
+	return nil
+}
+
+func (st *MemStore) GetElevationCells(d model.Dataset, size float64) ([]elevation.BoundingBox, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok || !inv.hasElevation {
+		return nil, errors.New(fmt.Sprintf("column=%s does not exist on table=%s", global.ELEVATION_COLUMN_NAME, d.TableName))
+	}
+	seen := map[[2]float64]bool{}
+	var cells []elevation.BoundingBox
+	for _, row := range inv.rows {
+		if row.point.NilElevation() || nullCoordinates(row) {
+			continue
+		}
+		k := [2]float64{math.Floor(row.point.X/size) * size, math.Floor(row.point.Y/size) * size}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		cells = append(cells, elevation.BoundingBox{MinX: k[0], MaxX: k[0] + size, MinY: k[1], MaxY: k[1] + size})
+	}
+	return cells, nil
+}
+
+func (st *MemStore) GetElevationPoints(d model.Dataset, b elevation.BoundingBox) (elevation.Points, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok || !inv.hasElevation {
+		return nil, errors.New(fmt.Sprintf("column=%s does not exist on table=%s", global.ELEVATION_COLUMN_NAME, d.TableName))
+	}
+	var points elevation.Points
+	for _, row := range inv.rows {
+		p := row.point
+		if p.NilElevation() || nullCoordinates(row) || p.X < b.MinX || p.X >= b.MaxX || p.Y < b.MinY || p.Y >= b.MaxY {
+			continue
+		}
+		points = append(points, &p)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].FdId < points[j].FdId })
+	return points, nil
+}
+
+func (st *MemStore) SaveElevationReviews(d model.Dataset, reviews []model.ElevationReview) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var kept []model.ElevationReview
+	for _, r := range st.reviews {
+		if r.DatasetId != d.Id {
+			kept = append(kept, r)
+		}
+	}
+	for _, r := range reviews {
+		r.DatasetId = d.Id
+		kept = append(kept, r)
+	}
+	st.reviews = kept
+	return nil
+}
+
+func (st *MemStore) GetElevationReviews(d model.Dataset) ([]model.ElevationReview, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var reviews []model.ElevationReview
+	for _, r := range st.reviews {
+		if r.DatasetId == d.Id {
+			reviews = append(reviews, r)
+		}
+	}
+	sort.Slice(reviews, func(i, j int) bool {
+		if reviews[i].FdId != reviews[j].FdId {
+			return reviews[i].FdId < reviews[j].FdId
+		}
+		return reviews[i].Check < reviews[j].Check
+	})
+	return reviews, nil
+}
+
+// trimAttribute strips the space or null padding of a dbf attribute value
+func trimAttribute(v string) string {
+	return strings.Trim(v, " \x00")
+}
+
+// EnrichFromPolygons reads the polygons of a shapefile, which are assumed to
+// be in lon/lat, and writes the attribute of the polygons holding each point
+// into column. Other polygon formats need ogr2ogr and are only supported by
+// PSStore
+func (st *MemStore) EnrichFromPolygons(d model.Dataset, l model.PolygonLayer, column string, columnType string) (model.EnrichResult, error) {
+	var result model.EnrichResult
+	if !strings.EqualFold(filepath.Ext(l.Path), ".shp") {
+		return result, errors.New(fmt.Sprintf("unable to read polygons=%s, only shapefiles are supported in memory", l.Path))
+	}
+	r, err := shp.Open(l.Path)
+	if err != nil {
+		return result, err
+	}
+	defer r.Close()
+	idx, err := shape.FieldIdx(r, l.Attribute)
+	if err != nil {
+		return result, err
+	}
+	var polygons []*shp.Polygon
+	var values []string
+	for r.Next() {
+		n, s := r.Shape()
+		p, ok := s.(*shp.Polygon)
+		if !ok {
+			return result, errors.New(fmt.Sprintf("polygons=%s holds %T shapes, not polygons", l.Path, s))
+		}
+		polygons = append(polygons, p)
+		values = append(values, trimAttribute(r.ReadAttribute(n, idx)))
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	inv, ok := st.inventories[d.TableName]
+	if !ok {
+		return result, errors.New(fmt.Sprintf("table=%s does not exist", d.TableName))
+	}
+	if _, ok := inv.columns[column]; !ok {
+		inv.columns[column] = columnType
+	}
+	for i := range inv.rows {
+		row := &inv.rows[i]
+		matches := map[string]bool{}
+		count := 0
+		for j, p := range polygons {
+			if polygonContains(p, row.point.X, row.point.Y) {
+				matches[values[j]] = true
+				count++
+			}
+		}
+		id := row.point.FdId
+		switch {
+		case count == 0:
+			result.NoMatch++
+			if len(result.NoMatchIds) < global.ENRICH_REPORT_LIMIT {
+				result.NoMatchIds = append(result.NoMatchIds, id)
+			}
+		case len(matches) > 1:
+			result.Conflicting++
+		default:
+			for v := range matches {
+				row.attrs[column] = v
+			}
+			result.Updated++
+		}
+		if count > 1 {
+			result.Multiple++
+			if len(result.MultipleIds) < global.ENRICH_REPORT_LIMIT {
+				result.MultipleIds = append(result.MultipleIds, id)
+			}
+		}
+	}
+	return result, nil
+}
+
+// polygonContains tests x, y against every ring of p with the even-odd rule,
+// so holes are excluded
+func polygonContains(p *shp.Polygon, x float64, y float64) bool {
+	if x < p.Box.MinX || x > p.Box.MaxX || y < p.Box.MinY || y > p.Box.MaxY {
+		return false
+	}
+	inside := false
+	for part := 0; part < len(p.Parts); part++ {
+		lo := int(p.Parts[part])
+		hi := len(p.Points)
+		if part+1 < len(p.Parts) {
+			hi = int(p.Parts[part+1])
+		}
+		ring := p.Points[lo:hi]
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			a, b := ring[i], ring[j]
+			if (a.Y > y) != (b.Y > y) && x < (b.X-a.X)*(y-a.Y)/(b.Y-a.Y)+a.X {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
@@ -1,37 +1,135 @@
 package store
 
 import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"math/rand"
+	"os/exec"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/config"
 	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/elevation"
 	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/global"
 	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/model"
 	shape "github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/shp"
+	"github.com/HydrologicEngineeringCenter/shape-sql-loader/internal/types"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/jonas-p/go-shp"
 	"github.com/usace/goquery"
 )
 
+// Store covers the catalog and inventory operations required by core. PSStore
+// is the PostGIS implementation, MemStore is an in-memory implementation used
+// for testing without a live database.
+type Store interface {
+	// schema / field / domain registry
+	GetSchemaId(s *model.Schema) error
+	AddSchema(s *model.Schema) error
+	GetFieldId(f *model.Field) error
+	GetFieldsByName(f model.Field) ([]model.Field, error)
+	AddField(f *model.Field) error
+	UpdateFieldUnit(f model.Field) error
+	AddDomain(d *model.Domain) error
+	SchemaFieldAssociationExists(sf model.SchemaField) (bool, error)
+	AddSchemaFieldAssociation(sf model.SchemaField) error
+
+	// quality / access
+	GetQuality(q *model.Quality) error
+	GetQualityId(q *model.Quality) error
+	GetGroupId(g *model.Group) error
+	AddGroup(g *model.Group) error
+	GetMemberId(m *model.Member) error
+	AddMember(m *model.Member) error
+	UpdateMemberRole(m *model.Member) error
+
+	// dataset / inventory
+	GetDataset(d *model.Dataset) error
+	GetDatasets() ([]model.Dataset, error)
+	AddDataset(d *model.Dataset) error
+	LoadShp(d model.Dataset, shpPath string, shp2DbName map[string]string, casts map[string]string, appendRows bool) error
+	ShpDataInStore(d model.Dataset, s *shp.Reader) (bool, error)
+	GetMaxFdId(d model.Dataset) (int, error)
+	GetInventoryStats(d model.Dataset, sinceFdId int) (model.DatasetStats, error)
+	GetDatasetStats(d model.Dataset) (model.DatasetStats, error)
+	SaveDatasetStats(s model.DatasetStats) error
+	AddElevationRun(r *model.ElevationRun) error
+	UpdateElevationRun(r model.ElevationRun) error
+	GetElevationRuns(d model.Dataset) ([]model.ElevationRun, error)
+	OptimizeInventory(d model.Dataset, columns []string, cluster bool) error
+	TableExists(table string) (bool, error)
+	GetInventoryColumns(d model.Dataset) (map[string]string, error)
+	GetDistinctValues(d model.Dataset, column string) ([]string, error)
+	AddFdIdColumn(d model.Dataset) error
+	AddShapeColumn(d model.Dataset) error
+
+	// elevation
+	ElevationColumnExists(d model.Dataset) (bool, error)
+	AddElevationColumn(d model.Dataset) error
+	GetEmptyElevationFdIdRange(d model.Dataset) (int, int, int, error)
+	GetEmptyElevationCells(d model.Dataset, size float64) ([]elevation.BoundingBox, error)
+	GetPointCells(d model.Dataset, size float64) ([]elevation.BoundingBox, error)
+	GetEmptyElevationPoints(d model.Dataset, part elevation.Partition, afterFdId int, count int) (elevation.Points, error)
+	GetEmptyElevationGeometries(d model.Dataset, part elevation.Partition, afterFdId int, count int) (elevation.Points, error)
+	GetSelectedElevationFdIdRange(d model.Dataset, f elevation.Filter) (int, int, int, error)
+	GetSelectedElevationCells(d model.Dataset, size float64, f elevation.Filter) ([]elevation.BoundingBox, error)
+	GetSelectedElevationPoints(d model.Dataset, f elevation.Filter, part elevation.Partition, afterFdId int, count int) (elevation.Points, error)
+	GetSelectedElevationGeometries(d model.Dataset, f elevation.Filter, part elevation.Partition, afterFdId int, count int) (elevation.Points, error)
+	UpdateElevationAtPoint(d model.Dataset, points elevation.Points) error
+	MarkNullCoordinates(d model.Dataset) (int, error)
+	TryLockElevation(d model.Dataset) (bool, error)
+	UnlockElevation(d model.Dataset) error
+
+	// raster sampling
+	AddRasterColumn(d model.Dataset, column string) error
+	GetPoints(d model.Dataset, afterFdId int, count int) (elevation.Points, error)
+	CountNullCoordinates(d model.Dataset) (int, error)
+	UpdateColumnAtPoint(d model.Dataset, column string, points elevation.Points) error
+
+	// point in polygon enrichment
+	EnrichFromPolygons(d model.Dataset, l model.PolygonLayer, column string, columnType string) (model.EnrichResult, error)
+
+	// elevation qa
+	GetElevationCells(d model.Dataset, size float64) ([]elevation.BoundingBox, error)
+	GetElevationPoints(d model.Dataset, b elevation.BoundingBox) (elevation.Points, error)
+	SaveElevationReviews(d model.Dataset, reviews []model.ElevationReview) error
+	GetElevationReviews(d model.Dataset) ([]model.ElevationReview, error)
+}
+
+var _ Store = (*PSStore)(nil)
+var _ Store = (*MemStore)(nil)
+
 type PSStore struct {
-	DS goquery.DataStore
+	DS      goquery.DataStore
+	connStr string // raw connection string, handed to ogr2ogr
+
+	mu    sync.Mutex
+	locks map[uuid.UUID]*pgxpool.Conn // connections holding the elevation lock of a dataset
 }
 
 func NewStore(c config.Config) (*PSStore, error) {
 	dbconf := c.Rdbmsconfig()
 	ds, err := goquery.NewRdbmsDataStore(&dbconf)
 	if err != nil {
-		log.Printf("Unable to connect to database during startup: %s", err)
-	} else {
-		log.Printf("Connected as %s to database %s:%s/%s", c.Dbuser, c.Dbhost, c.Dbport, c.Dbname)
+		return nil, errors.New(fmt.Sprintf("Unable to connect to database during startup: %s", err))
 	}
+	log.Printf("Connected as %s to database %s:%s/%s", c.Dbuser, c.Dbhost, c.Dbport, c.Dbname)
 
-	st := PSStore{ds}
+	st := PSStore{
+		DS:      ds,
+		connStr: c.ConnStr,
+		locks:   map[uuid.UUID]*pgxpool.Conn{},
+	}
 	return &st, nil
 }
 
@@ -55,7 +153,7 @@ func (st *PSStore) AddField(f *model.Field) error {
 	err := st.DS.Select().
 		DataSet(&fieldTable).
 		StatementKey("insert").
-		Params(f.DbName, f.Type, f.Description, f.IsDomain).
+		Params(f.DbName, f.Type, f.Description, f.IsDomain, f.Unit, f.VerticalDatum).
 		Dest(&fId).
 		Fetch()
 	if err != nil {
@@ -65,6 +163,11 @@ func (st *PSStore) AddField(f *model.Field) error {
 	return nil
 }
 
+// UpdateFieldUnit records the vertical unit and datum of a registered field
+func (st *PSStore) UpdateFieldUnit(f model.Field) error {
+	return st.DS.Exec(goquery.NoTx, fieldTable.Statements["updateUnit"], f.Id, f.Unit, f.VerticalDatum)
+}
+
 func (st *PSStore) AddMember(m *model.Member) error {
 	var mId uuid.UUID
 	err := st.DS.Select().
@@ -269,18 +372,35 @@ func (st *PSStore) GetDataset(d *model.Dataset) error {
 	return nil
 }
 
+// GetDatasets lists every dataset of the catalog, oldest first
+func (st *PSStore) GetDatasets() ([]model.Dataset, error) {
+	var ds []model.Dataset
+	err := st.DS.
+		Select().
+		DataSet(&datasetTable).
+		StatementKey("selectAll").
+		Dest(&ds).
+		Fetch()
+	return ds, err
+}
+
+// GetFieldId queries the field registry based on the unique field name and type.
+// Replaces Id field if a corresponding entry exists, otherwise change Id field to uuid.Nil
 func (st *PSStore) GetFieldId(f *model.Field) error {
 	var ids []uuid.UUID
 	err := st.DS.
 		Select().
 		DataSet(&fieldTable).
 		StatementKey("select").
-		Params(f.DbName).
+		Params(f.DbName, f.Type).
 		Dest(&ids).
 		Fetch()
+	if err != nil {
+		return err
+	}
 	if len(ids) == 0 {
 		f.Id = uuid.Nil
-		return err
+		return nil
 	}
 	if len(ids) > 1 {
 		return errors.New("more than 1 id exists for field.name=" + f.DbName + " and field.type=" + string(f.Type))
@@ -289,6 +409,23 @@ func (st *PSStore) GetFieldId(f *model.Field) error {
 	return err
 }
 
+// GetFieldsByName returns every registry field sharing the name of f regardless
+// of type. Used to detect type conflicts when GetFieldId finds no match
+func (st *PSStore) GetFieldsByName(f model.Field) ([]model.Field, error) {
+	var fs []model.Field
+	err := st.DS.
+		Select().
+		DataSet(&fieldTable).
+		StatementKey("selectByName").
+		Params(f.DbName).
+		Dest(&fs).
+		Fetch()
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
 // GetSchemaId queries the database based on the supplied schema name and version.
 // Replaces Id field if a corresponding entry exists, otherwise change Id field to uuid.Nil
 func (st *PSStore) GetSchemaId(s *model.Schema) error {
@@ -352,23 +489,87 @@ func (st *PSStore) GetQualityId(q *model.Quality) error {
 	return nil
 }
 
-// Check if table exists in database
-func (st *PSStore) TableExists(schema string, table string) (bool, error) {
+// TableExists checks if table exists in the inventory schema
+func (st *PSStore) TableExists(table string) (bool, error) {
 	var result bool
-	err := st.DS.Select(`
-    SELECT EXISTS (
-        SELECT FROM pg_tables
-        WHERE
-            schemaname='$1' AND
-            tablename='$2'
-    )
-    `).
-		Params(schema, table).
+	err := st.DS.
+		Select(datasetTable.Statements["tableExists"]).
+		Params(global.DB_SCHEMA, table).
 		Dest(&result).
 		Fetch()
 	return result, err
 }
 
+type columnRow struct {
+	Name string `db:"name"`
+	Type string `db:"type"`
+}
+
+// GetInventoryColumns maps every column of the inventory table to its
+// postgres data type
+func (st *PSStore) GetInventoryColumns(d model.Dataset) (map[string]string, error) {
+	var rows []columnRow
+	err := st.DS.
+		Select(datasetTable.Statements["inventoryColumns"]).
+		Params(global.DB_SCHEMA, d.TableName).
+		Dest(&rows).
+		Fetch()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]string{}
+	for _, r := range rows {
+		columns[r.Name] = r.Type
+	}
+	return columns, nil
+}
+
+// GetDistinctValues returns the sorted non null values of a column as text
+func (st *PSStore) GetDistinctValues(d model.Dataset, column string) ([]string, error) {
+	var values []string
+	err := st.DS.
+		Select(inventorySql("distinctValues", d.TableName, "", pgx.Identifier{column}.Sanitize())).
+		Dest(&values).
+		Fetch()
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// AddFdIdColumn adds a serial fd_id key to an adopted inventory table
+func (st *PSStore) AddFdIdColumn(d model.Dataset) error {
+	return st.execInTx(
+		inventorySql("addFdIdColumn", d.TableName, "", ""),
+		inventorySql("createUniqueIndex", d.TableName, indexName(d.TableName, "fd_id_idx"), "fd_id"),
+	)
+}
+
+// AddShapeColumn adds a point geometry built from the x, y columns of an
+// adopted inventory table
+func (st *PSStore) AddShapeColumn(d model.Dataset) error {
+	return st.execInTx(
+		inventorySql("addShapeColumn", d.TableName, "", ""),
+		inventorySql("fillShape", d.TableName, "", ""),
+	)
+}
+
+// execInTx runs statements in a single transaction, rolling back on the first error
+func (st *PSStore) execInTx(stmts ...string) error {
+	tx, err := st.DS.Transaction()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		err = st.DS.Exec(&tx, stmt)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func (st *PSStore) SchemaFieldAssociationExists(sf model.SchemaField) (bool, error) {
 	var ids []uuid.UUID
 	var result bool
@@ -390,18 +591,346 @@ func (st *PSStore) SchemaFieldAssociationExists(sf model.SchemaField) (bool, err
 	return result, err
 }
 
-func (st *PSStore) UpdateDatasetBBox(d model.Dataset) error {
-	// hacky way to dynamically generate table_name since identifiers cannot be used as variables
-	// should be safe from sql injection since all table names are generated internally from guids
-	var ids []interface{}
+// GetMaxFdId returns the largest fd_id in the inventory table, 0 if empty.
+// Rows loaded afterwards can be selected with fd_id > GetMaxFdId
+func (st *PSStore) GetMaxFdId(d model.Dataset) (int, error) {
+	var maxFdId int
+	err := st.DS.
+		Select(strings.ReplaceAll(datasetTable.Statements["maxFdId"], "{table_name}", d.TableName)).
+		Dest(&maxFdId).
+		Fetch()
+	return maxFdId, err
+}
+
+// extentRow holds the nullable aggregates of the extentSince statement
+type extentRow struct {
+	RowCount int64    `db:"row_count"`
+	MinX     *float64 `db:"min_x"`
+	MinY     *float64 `db:"min_y"`
+	MaxX     *float64 `db:"max_x"`
+	MaxY     *float64 `db:"max_y"`
+	Srid     int      `db:"srid"`
+}
+
+type keyCount struct {
+	Key   string `db:"key"`
+	Count int64  `db:"count"`
+}
+
+// GetInventoryStats summarizes the inventory rows loaded after sinceFdId.
+// County and state counts are only available if the table holds cbfips2010
+func (st *PSStore) GetInventoryStats(d model.Dataset, sinceFdId int) (model.DatasetStats, error) {
+	var ext []extentRow
 	err := st.DS.
-		Select(strings.ReplaceAll(datasetTable.Statements["updateBBox"], "{table_name}", d.TableName)).
+		Select(strings.ReplaceAll(datasetTable.Statements["extentSince"], "{table_name}", d.TableName)).
+		Params(sinceFdId).
+		Dest(&ext).
+		Fetch()
+	if err != nil {
+		return model.DatasetStats{}, err
+	}
+	stats := model.DatasetStats{
+		DatasetId:    d.Id,
+		StateCounts:  map[string]int64{},
+		CountyCounts: map[string]int64{},
+		NullCounts:   map[string]int64{},
+	}
+	if len(ext) == 0 || ext[0].RowCount == 0 {
+		return stats, nil
+	}
+	e := ext[0]
+	stats.RowCount = e.RowCount
+	stats.Srid = e.Srid
+	if e.MinX != nil {
+		stats.MinX, stats.MinY, stats.MaxX, stats.MaxY = *e.MinX, *e.MinY, *e.MaxX, *e.MaxY
+	}
+
+	fipsExists, err := st.columnExists(d, "cbfips2010")
+	if err != nil {
+		return model.DatasetStats{}, err
+	}
+	if fipsExists {
+		var counties []keyCount
+		err = st.DS.
+			Select(strings.ReplaceAll(datasetTable.Statements["countyCountsSince"], "{table_name}", d.TableName)).
+			Params(sinceFdId).
+			Dest(&counties).
+			Fetch()
+		if err != nil {
+			return model.DatasetStats{}, err
+		}
+		for _, c := range counties {
+			stats.CountyCounts[c.Key] += c.Count
+			if len(c.Key) >= 2 {
+				stats.StateCounts[c.Key[:2]] += c.Count
+			}
+		}
+	}
+
+	var nulls []keyCount
+	err = st.DS.
+		Select(strings.ReplaceAll(datasetTable.Statements["nullCountsSince"], "{table_name}", d.TableName)).
+		Params(sinceFdId).
+		Dest(&nulls).
+		Fetch()
+	if err != nil {
+		return model.DatasetStats{}, err
+	}
+	for _, n := range nulls {
+		stats.NullCounts[n.Key] = n.Count
+	}
+	return stats, nil
+}
+
+// statsRow is the db representation of model.DatasetStats, jsonb counts are read as text
+type statsRow struct {
+	DatasetId    uuid.UUID `db:"dataset_id"`
+	RowCount     int64     `db:"row_count"`
+	MinX         *float64  `db:"min_x"`
+	MinY         *float64  `db:"min_y"`
+	MaxX         *float64  `db:"max_x"`
+	MaxY         *float64  `db:"max_y"`
+	Srid         *int      `db:"srid"`
+	StateCounts  string    `db:"state_counts"`
+	CountyCounts string    `db:"county_counts"`
+	NullCounts   string    `db:"null_counts"`
+	LastLoad     time.Time `db:"last_load"`
+}
+
+// GetDatasetStats reads the statistics record of the dataset. Returns empty
+// stats if the dataset has not been summarized yet
+func (st *PSStore) GetDatasetStats(d model.Dataset) (model.DatasetStats, error) {
+	var rows []statsRow
+	err := st.DS.
+		Select().
+		DataSet(&datasetStatsTable).
+		StatementKey("select").
 		Params(d.Id).
-		Dest(&ids). // interface doesn't work without a dest sink
+		Dest(&rows).
+		Fetch()
+	if err != nil {
+		return model.DatasetStats{}, err
+	}
+	stats := model.DatasetStats{
+		DatasetId:    d.Id,
+		StateCounts:  map[string]int64{},
+		CountyCounts: map[string]int64{},
+		NullCounts:   map[string]int64{},
+	}
+	if len(rows) == 0 {
+		return stats, nil
+	}
+	r := rows[0]
+	stats.RowCount = r.RowCount
+	if r.MinX != nil {
+		stats.MinX, stats.MinY, stats.MaxX, stats.MaxY = *r.MinX, *r.MinY, *r.MaxX, *r.MaxY
+	}
+	if r.Srid != nil {
+		stats.Srid = *r.Srid
+	}
+	stats.LastLoad = r.LastLoad
+	for _, c := range []struct {
+		raw string
+		m   map[string]int64
+	}{
+		{r.StateCounts, stats.StateCounts},
+		{r.CountyCounts, stats.CountyCounts},
+		{r.NullCounts, stats.NullCounts},
+	} {
+		err = json.Unmarshal([]byte(c.raw), &c.m)
+		if err != nil {
+			return model.DatasetStats{}, err
+		}
+	}
+	return stats, nil
+}
+
+// SaveDatasetStats upserts the statistics record and sets dataset.shape to
+// the recorded extent
+func (st *PSStore) SaveDatasetStats(s model.DatasetStats) error {
+	var counts [][]byte
+	for _, m := range []map[string]int64{s.StateCounts, s.CountyCounts, s.NullCounts} {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		counts = append(counts, b)
+	}
+	var ids []uuid.UUID
+	err := st.DS.
+		Select().
+		DataSet(&datasetStatsTable).
+		StatementKey("upsert").
+		Params(s.DatasetId, s.RowCount, s.MinX, s.MinY, s.MaxX, s.MaxY, s.Srid,
+			string(counts[0]), string(counts[1]), string(counts[2]), s.LastLoad).
+		Dest(&ids).
+		Fetch()
+	if err != nil {
+		return err
+	}
+	var res []interface{}
+	err = st.DS.
+		Select(datasetTable.Statements["updateBBox"]).
+		Params(s.DatasetId, s.MinX, s.MinY, s.MaxX, s.MaxY, s.Srid).
+		Dest(&res). // interface doesn't work without a dest sink
 		Fetch()
 	return err
 }
 
+// AddElevationRun records the start of an elevation run and sets its id
+func (st *PSStore) AddElevationRun(r *model.ElevationRun) error {
+	var id uuid.UUID
+	err := st.DS.
+		Select().
+		DataSet(&elevationRunTable).
+		StatementKey("insert").
+		Params(r.DatasetId, r.Started, r.Source, r.Product, string(r.Sampling)).
+		Dest(&id).
+		Fetch()
+	if err != nil {
+		return err
+	}
+	r.Id = id
+	return nil
+}
+
+// UpdateElevationRun records the end and the counts of an elevation run
+func (st *PSStore) UpdateElevationRun(r model.ElevationRun) error {
+	var ids []uuid.UUID
+	return st.DS.
+		Select().
+		DataSet(&elevationRunTable).
+		StatementKey("update").
+		Params(r.Id, r.Ended, r.Filled, r.Failed, r.Mismatched, r.Error).
+		Dest(&ids).
+		Fetch()
+}
+
+// GetElevationRuns returns the elevation runs of a dataset, oldest first
+func (st *PSStore) GetElevationRuns(d model.Dataset) ([]model.ElevationRun, error) {
+	var runs []model.ElevationRun
+	err := st.DS.
+		Select().
+		DataSet(&elevationRunTable).
+		StatementKey("selectByDataset").
+		Params(d.Id).
+		Dest(&runs).
+		Fetch()
+	if err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// LoadShp inserts the shp file into the inventory table of the dataset using
+// ogr2ogr. The table is created unless appendRows is set
+func (st *PSStore) LoadShp(d model.Dataset, shpPath string, shp2DbName map[string]string, casts map[string]string, appendRows bool) error {
+	sqlArg := shape.GenerateSqlArg(shp2DbName, casts, strings.TrimSuffix(
+		filepath.Base(shpPath),
+		filepath.Ext(shpPath),
+	))
+	var args []string
+	if appendRows {
+		args = append(args, "-append", "-update")
+	}
+	args = append(args,
+		"-f", "PostgreSQL", "PG:"+strings.ReplaceAll(st.connStr, "database=", "dbname="), shpPath,
+		"-lco", "precision=no", "-lco", "fid=fd_id", "-lco", "geometry_name=shape",
+		"-nln", DbSchema+"."+d.TableName,
+		"-sql", sqlArg,
+	)
+	return runOgr2ogr(args, d.TableName)
+}
+
+// runOgr2ogr runs ogr2ogr with args loading table, logging its output. No
+// shell is involved, paths and names are passed through as they are
+func runOgr2ogr(args []string, table string) error {
+	cmd := exec.Command("ogr2ogr", args...)
+	// setting up pipeline
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// read command's stdout line by line
+	in := bufio.NewScanner(stdout)
+	for in.Scan() {
+		log.Printf(in.Text()) // write each line to your log, or anything you need
+	}
+	if err := in.Err(); err != nil {
+		log.Printf("ogr2ogr error: %s", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		return errors.New(fmt.Sprintf("ogr2ogr failed for table=%s: %s", table, err))
+	}
+	return nil
+}
+
+// OptimizeInventory builds the spatial index, the x/y lookup index used by
+// ShpDataInStore, a btree index for each of the requested columns, and the
+// partial index over empty elevations, then refreshes planner statistics.
+// Optionally clusters the table on its spatial index. Safe to rerun after
+// every appended shp file
+func (st *PSStore) OptimizeInventory(d model.Dataset, columns []string, cluster bool) error {
+	shapeIdx := indexName(d.TableName, "shape_geom_idx") // same name as the ogr2ogr default
+	stmts := []string{
+		inventorySql("createShapeIndex", d.TableName, shapeIdx, ""),
+		inventorySql("createIndex", d.TableName, indexName(d.TableName, "xy_idx"), "x, y"),
+	}
+	for _, c := range columns {
+		stmts = append(stmts, inventorySql(
+			"createIndex", d.TableName, indexName(d.TableName, c+"_idx"), pgx.Identifier{c}.Sanitize(),
+		))
+	}
+	elevColumnExists, err := st.ElevationColumnExists(d)
+	if err != nil {
+		return err
+	}
+	if elevColumnExists {
+		stmts = append(stmts, inventorySql(
+			"createEmptyElevationIndex", d.TableName, indexName(d.TableName, "elev_null_idx"), "",
+		))
+	}
+	if cluster {
+		stmts = append(stmts, inventorySql("cluster", d.TableName, shapeIdx, ""))
+	}
+	stmts = append(stmts, inventorySql("analyze", d.TableName, "", ""))
+	for _, stmt := range stmts {
+		err = st.DS.Exec(goquery.NoTx, stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inventorySql fills the identifier placeholders of a datasetTable statement.
+// Identifiers cannot be bound as params, all values are generated internally
+func inventorySql(key string, table string, index string, columns string) string {
+	return strings.NewReplacer(
+		"{table_name}", table,
+		"{index_name}", index,
+		"{columns}", columns,
+	).Replace(datasetTable.Statements[key])
+}
+
+// indexName derives a deterministic index name that fits within the 63 byte
+// identifier limit of postgres, so that reruns hit "if not exists"
+func indexName(table string, suffix string) string {
+	name := table + "_" + suffix
+	if len(name) <= 63 {
+		return name
+	}
+	h := md5.Sum([]byte(name))
+	name = fmt.Sprintf("idx_%x_%s", h[:8], suffix)
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name
+}
+
 // ShpDataInStore checks if shp file has already been uploaded to database
 func (st *PSStore) ShpDataInStore(d model.Dataset, s *shp.Reader) (bool, error) {
 	// algo takes a set of random sample points, if any sample matches with
@@ -449,12 +978,23 @@ func (st *PSStore) UpdateMemberRole(m *model.Member) error {
 	return err
 }
 
-// ElevationColumnExists tests if elevation column exists for inventory table
+// ElevationColumnExists tests if the elevation and elevation reason columns exist for inventory table
 func (st *PSStore) ElevationColumnExists(d model.Dataset) (bool, error) {
+	for _, c := range elevationColumns {
+		exists, err := st.columnExists(d, c)
+		if err != nil || !exists {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// columnExists tests if a column exists for inventory table
+func (st *PSStore) columnExists(d model.Dataset, column string) (bool, error) {
 	var res bool
 	err := st.DS.
-		Select(datasetTable.Statements["elevationColumnExists"]).
-		Params(global.DB_SCHEMA, d.TableName, global.ELEVATION_COLUMN_NAME).
+		Select(datasetTable.Statements["columnExists"]).
+		Params(global.DB_SCHEMA, d.TableName, column).
 		Dest(&res).
 		Fetch()
 	if err != nil {
@@ -466,20 +1006,158 @@ func (st *PSStore) ElevationColumnExists(d model.Dataset) (bool, error) {
 func (st *PSStore) AddElevationColumn(d model.Dataset) error {
 	sql := strings.ReplaceAll(datasetTable.Statements["addElevColumn"], "{table_name}", d.TableName)
 	tx, err := st.DS.Transaction()
+	if err != nil {
+		return err
+	}
 	err = st.DS.Exec(&tx, sql)
 	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// every row starts empty, index keeps the null scan cheap as the column fills up
+	sql = inventorySql("createEmptyElevationIndex", d.TableName, indexName(d.TableName, "elev_null_idx"), "")
+	err = st.DS.Exec(&tx, sql)
+	if err != nil {
+		tx.Rollback()
 		return err
 	}
 	err = tx.Commit()
 	return err
 }
 
-func (st *PSStore) GetEmptyElevationPoints(d model.Dataset, count int, offset int) (elevation.Points, error) {
-	sql := strings.ReplaceAll(datasetTable.Statements["selectEmptyElevationCoords"], "{table_name}", d.TableName)
+type fdIdRangeRow struct {
+	Count   int `db:"count"`
+	MinFdId int `db:"min_fd_id"`
+	MaxFdId int `db:"max_fd_id"`
+}
+
+// GetEmptyElevationFdIdRange returns the number of empty elevation points and
+// their smallest and largest fd_id
+func (st *PSStore) GetEmptyElevationFdIdRange(d model.Dataset) (int, int, int, error) {
+	return st.elevationFdIdRange(d, nil)
+}
+
+// GetSelectedElevationFdIdRange is GetEmptyElevationFdIdRange over the points
+// f selects, filled or not
+func (st *PSStore) GetSelectedElevationFdIdRange(d model.Dataset, f elevation.Filter) (int, int, int, error) {
+	return st.elevationFdIdRange(d, &f)
+}
+
+func (st *PSStore) elevationFdIdRange(d model.Dataset, f *elevation.Filter) (int, int, int, error) {
+	var r fdIdRangeRow
+	sql, params := elevationSql("elevationFdIdRange", d.TableName, f)
+	err := st.DS.
+		Select(sql).
+		Params(params...).
+		Dest(&r).
+		Fetch()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return r.Count, r.MinFdId, r.MaxFdId, nil
+}
+
+// elevationSql fills {where} of an elevation statement with the empty points,
+// or the points f selects with its values bound after the fixed params
+func elevationSql(key string, table string, f *elevation.Filter, fixed ...interface{}) (string, []interface{}) {
+	where, params := emptyElevationWhere, []interface{}(nil)
+	if f != nil {
+		where, params = filterSql(*f, len(fixed))
+	}
+	return strings.Replace(inventorySql(key, table, "", ""), "{where}", where, 1), append(fixed, params...)
+}
+
+type cellRow struct {
+	MinX float64 `db:"min_x"`
+	MinY float64 `db:"min_y"`
+}
+
+// GetEmptyElevationCells returns the size x size cells, aligned to multiples
+// of size, that hold empty elevation points
+func (st *PSStore) GetEmptyElevationCells(d model.Dataset, size float64) ([]elevation.BoundingBox, error) {
+	return st.elevationCells(d, size, nil)
+}
+
+// GetSelectedElevationCells is GetEmptyElevationCells over the points f
+// selects, filled or not
+func (st *PSStore) GetSelectedElevationCells(d model.Dataset, size float64, f elevation.Filter) ([]elevation.BoundingBox, error) {
+	return st.elevationCells(d, size, &f)
+}
+
+func (st *PSStore) elevationCells(d model.Dataset, size float64, f *elevation.Filter) ([]elevation.BoundingBox, error) {
+	var rows []cellRow
+	sql, params := elevationSql("selectedElevationCells", d.TableName, f, size)
+	err := st.DS.
+		Select(sql).
+		Params(params...).
+		Dest(&rows).
+		Fetch()
+	if err != nil {
+		return nil, err
+	}
+	var cells []elevation.BoundingBox
+	for _, r := range rows {
+		cells = append(cells, elevation.BoundingBox{
+			MinX: r.MinX,
+			MaxX: r.MinX + size,
+			MinY: r.MinY,
+			MaxY: r.MinY + size,
+		})
+	}
+	return cells, nil
+}
+
+// GetPointCells returns the size x size cells, aligned to multiples of size,
+// that hold points, it reads the inventory only and works without an
+// elevation column
+func (st *PSStore) GetPointCells(d model.Dataset, size float64) ([]elevation.BoundingBox, error) {
+	var rows []cellRow
+	err := st.DS.
+		Select(inventorySql("pointCells", d.TableName, "", "")).
+		Params(size).
+		Dest(&rows).
+		Fetch()
+	if err != nil {
+		return nil, err
+	}
+	var cells []elevation.BoundingBox
+	for _, r := range rows {
+		cells = append(cells, elevation.BoundingBox{
+			MinX: r.MinX,
+			MaxX: r.MinX + size,
+			MinY: r.MinY,
+			MaxY: r.MinY + size,
+		})
+	}
+	return cells, nil
+}
+
+// GetEmptyElevationPoints returns up to count empty points of the partition
+// with fd_id > afterFdId, ordered by fd_id so that callers can page through
+// the partition even if some points stay empty
+func (st *PSStore) GetEmptyElevationPoints(d model.Dataset, part elevation.Partition, afterFdId int, count int) (elevation.Points, error) {
+	return st.elevationPoints(d, nil, part, afterFdId, count)
+}
+
+// GetSelectedElevationPoints is GetEmptyElevationPoints over the points f
+// selects, which come with their current elevation
+func (st *PSStore) GetSelectedElevationPoints(d model.Dataset, f elevation.Filter, part elevation.Partition, afterFdId int, count int) (elevation.Points, error) {
+	return st.elevationPoints(d, &f, part, afterFdId, count)
+}
+
+func (st *PSStore) elevationPoints(d model.Dataset, f *elevation.Filter, part elevation.Partition, afterFdId int, count int) (elevation.Points, error) {
 	var coords elevation.Points
+	var sql string
+	var params []interface{}
+	if part.Cell != nil {
+		c := part.Cell
+		sql, params = elevationSql("selectElevationCell", d.TableName, f, afterFdId, c.MinX, c.MaxX, c.MinY, c.MaxY, count)
+	} else {
+		sql, params = elevationSql("selectElevationRange", d.TableName, f, afterFdId, part.MaxFdId, count)
+	}
 	err := st.DS.
 		Select(sql).
-		Params(count, offset).
+		Params(params...).
 		Dest(&coords).
 		Fetch()
 	if err != nil {
@@ -488,43 +1166,453 @@ func (st *PSStore) GetEmptyElevationPoints(d model.Dataset, count int, offset in
 	return coords, nil
 }
 
-func (st *PSStore) UpdateElevationAtPoint(d model.Dataset, points elevation.Points) error {
-	// batchSize here is the db update batchSize, not to be confused with the goroutine batchSize
-	batchSize := 1000
-	var tx goquery.Tx
-	var err error
-	tx, err = st.DS.Transaction()
+// GetEmptyElevationGeometries is GetEmptyElevationPoints reading X, Y and the
+// SRID from the shape geometry and keeping the x, y columns to check against.
+// Only fd_id range partitions are supported, cells are cut on the x, y columns
+func (st *PSStore) GetEmptyElevationGeometries(d model.Dataset, part elevation.Partition, afterFdId int, count int) (elevation.Points, error) {
+	return st.elevationGeometries(d, nil, part, afterFdId, count)
+}
+
+// GetSelectedElevationGeometries is GetEmptyElevationGeometries over the
+// points f selects
+func (st *PSStore) GetSelectedElevationGeometries(d model.Dataset, f elevation.Filter, part elevation.Partition, afterFdId int, count int) (elevation.Points, error) {
+	return st.elevationGeometries(d, &f, part, afterFdId, count)
+}
+
+func (st *PSStore) elevationGeometries(d model.Dataset, f *elevation.Filter, part elevation.Partition, afterFdId int, count int) (elevation.Points, error) {
+	if part.Cell != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read geometries of %s, only fd_id partitions are supported", part))
+	}
+	var points elevation.Points
+	sql, params := elevationSql("selectElevationShape", d.TableName, f, afterFdId, part.MaxFdId, count)
+	err := st.DS.
+		Select(sql).
+		Params(params...).
+		Dest(&points).
+		Fetch()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for i, p := range points {
-		if i%batchSize == 0 {
+	return points, nil
+}
+
+// UpdateElevationAtPoint writes the resolved points of a batch. Results are
+// streamed into a temp table with COPY and applied with one update join, which
+// is orders of magnitude faster than an update per point
+func (st *PSStore) UpdateElevationAtPoint(d model.Dataset, points elevation.Points) error {
+	var rows [][]interface{}
+	for _, p := range points {
+		if !p.Resolved() {
+			// left for another pass
+			continue
 		}
-		sql := strings.ReplaceAll(datasetTable.Statements["updateElevation"], "{table_name}", d.TableName)
-		err = st.DS.Exec(&tx, sql, *p.Elevation, p.FdId)
-		if err != nil {
-			return err
+		var reason *string
+		if p.Reason != "" {
+			r := string(p.Reason)
+			reason = &r
 		}
-		// commit batch, create new Tx
-		if i%batchSize == 0 {
-			err = tx.Commit()
-			if err != nil {
-				return err
-			}
-			tx, err = st.DS.Transaction()
-			if err != nil {
-				return err
-			}
+		var tile *string
+		if p.Tile != "" {
+			tile = &p.Tile
+		}
+		var product *string
+		if p.Product != "" {
+			v := string(p.Product)
+			product = &v
 		}
+		rows = append(rows, []interface{}{p.FdId, p.Elevation, reason, tile, p.Resolution, product})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	tx, err := st.DS.Transaction()
+	if err != nil {
+		return err
+	}
+	err = st.DS.Exec(&tx, datasetTable.Statements["createElevationStage"])
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.PgxTx().CopyFrom(
+		context.Background(),
+		pgx.Identifier{elevationStageTable},
+		append([]string{"fd_id"}, elevationColumns...),
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		tx.Rollback()
+		return errors.New(fmt.Sprintf("unable to copy elevation into table=%s: %s", d.TableName, err))
+	}
+	err = st.DS.Exec(&tx, inventorySql("updateElevationFromStage", d.TableName, "", ""))
+	if err != nil {
+		tx.Rollback()
+		return err
 	}
-	// flush Tx queue
 	err = tx.Commit()
 	if err != nil {
 		return err
 	}
+	elapsed := time.Since(start)
+	log.Printf(
+		"Wrote %d elevations to table=%s in %s (%.0f rows/s)",
+		len(rows),
+		d.TableName,
+		elapsed.Round(time.Millisecond),
+		float64(len(rows))/elapsed.Seconds(),
+	)
 	return nil
 }
 
+// AddRasterColumn adds a double precision column to the inventory table of d
+func (st *PSStore) AddRasterColumn(d model.Dataset, column string) error {
+	return st.DS.Exec(goquery.NoTx, inventorySql("addRasterColumn", d.TableName, "", column))
+}
+
+// GetPoints returns up to count points with fd_id > afterFdId in fd_id order,
+// points without x, y are skipped
+func (st *PSStore) GetPoints(d model.Dataset, afterFdId int, count int) (elevation.Points, error) {
+	var points elevation.Points
+	err := st.DS.
+		Select(inventorySql("selectPoints", d.TableName, "", "")).
+		Params(afterFdId, count).
+		Dest(&points).
+		Fetch()
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// CountNullCoordinates counts the points of d without x, y
+func (st *PSStore) CountNullCoordinates(d model.Dataset) (int, error) {
+	var count int
+	err := st.DS.
+		Select(inventorySql("countNullCoordinates", d.TableName, "", "")).
+		Dest(&count).
+		Fetch()
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// UpdateColumnAtPoint writes the Elevation of each point into column, points
+// without one are set to null. Like UpdateElevationAtPoint results are copied
+// into a temp table and applied with one update join
+func (st *PSStore) UpdateColumnAtPoint(d model.Dataset, column string, points elevation.Points) error {
+	var rows [][]interface{}
+	for _, p := range points {
+		rows = append(rows, []interface{}{p.FdId, p.Elevation})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	tx, err := st.DS.Transaction()
+	if err != nil {
+		return err
+	}
+	err = st.DS.Exec(&tx, datasetTable.Statements["createRasterStage"])
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.PgxTx().CopyFrom(
+		context.Background(),
+		pgx.Identifier{rasterStageTable},
+		[]string{"fd_id", "value"},
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		tx.Rollback()
+		return errors.New(fmt.Sprintf("unable to copy column=%s into table=%s: %s", column, d.TableName, err))
+	}
+	err = st.DS.Exec(&tx, inventorySql("updateColumnFromStage", d.TableName, "", column))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// enrichSql fills in the placeholders of an enrich statement
+func enrichSql(key string, table string, polygons string, attribute string, column string, columnType string) string {
+	return strings.NewReplacer(
+		"{table_name}", table,
+		"{polygons}", polygons,
+		"{attribute}", pgx.Identifier{attribute}.Sanitize(),
+		"{columns}", pgx.Identifier{column}.Sanitize(),
+		"{type}", columnType,
+	).Replace(enrichTable.Statements[key])
+}
+
+type enrichCounts struct {
+	NoMatch     int `db:"no_match"`
+	Multiple    int `db:"multiple"`
+	Conflicting int `db:"conflicting"`
+}
+
+// EnrichFromPolygons loads the polygon layer into a scratch table with
+// ogr2ogr, reprojected to lon/lat, and writes the attribute of the polygons
+// holding the x, y of each row into column, adding the column with columnType
+// if it is missing. Rows in no polygon or in polygons that disagree on the
+// attribute are left unchanged
+func (st *PSStore) EnrichFromPolygons(d model.Dataset, l model.PolygonLayer, column string, columnType string) (model.EnrichResult, error) {
+	polygons := "enrich_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:12]
+	args := []string{"-f", "PostgreSQL", "PG:" + strings.ReplaceAll(st.connStr, "database=", "dbname="), l.Path}
+	if l.Layer != "" {
+		args = append(args, l.Layer)
+	}
+	args = append(args,
+		"-nln", DbSchema+"."+polygons,
+		"-nlt", "PROMOTE_TO_MULTI",
+		"-t_srs", "EPSG:4326",
+		"-lco", "geometry_name=geom", "-lco", "LAUNDER=NO",
+		"-select", l.Attribute,
+	)
+	err := runOgr2ogr(args, polygons)
+	defer func() {
+		err := st.DS.Exec(goquery.NoTx, enrichSql("dropLayer", d.TableName, polygons, l.Attribute, column, columnType))
+		if err != nil {
+			log.Printf("Unable to drop polygon table=%s: %s", polygons, err)
+		}
+	}()
+	if err != nil {
+		return model.EnrichResult{}, err
+	}
+	err = st.DS.Exec(goquery.NoTx, enrichSql("addColumn", d.TableName, polygons, l.Attribute, column, columnType))
+	if err != nil {
+		return model.EnrichResult{}, err
+	}
+
+	var r model.EnrichResult
+	tx, err := st.DS.Transaction()
+	if err != nil {
+		return r, err
+	}
+	err = st.DS.Exec(&tx, enrichSql("createMatch", d.TableName, polygons, l.Attribute, column, columnType))
+	if err != nil {
+		tx.Rollback()
+		return r, err
+	}
+	err = st.DS.Select(enrichSql("update", d.TableName, polygons, l.Attribute, column, columnType)).
+		Tx(&tx).
+		Dest(&r.Updated).
+		Fetch()
+	if err != nil {
+		tx.Rollback()
+		return r, err
+	}
+	var counts enrichCounts
+	err = st.DS.Select(enrichTable.Statements["counts"]).Tx(&tx).Dest(&counts).Fetch()
+	if err != nil {
+		tx.Rollback()
+		return r, err
+	}
+	r.NoMatch, r.Multiple, r.Conflicting = counts.NoMatch, counts.Multiple, counts.Conflicting
+	err = st.DS.Select(enrichTable.Statements["noMatchIds"]).Tx(&tx).Params(global.ENRICH_REPORT_LIMIT).Dest(&r.NoMatchIds).Fetch()
+	if err != nil {
+		tx.Rollback()
+		return r, err
+	}
+	err = st.DS.Select(enrichTable.Statements["multipleIds"]).Tx(&tx).Params(global.ENRICH_REPORT_LIMIT).Dest(&r.MultipleIds).Fetch()
+	if err != nil {
+		tx.Rollback()
+		return r, err
+	}
+	return r, tx.Commit()
+}
+
+// TryLockElevation takes the session advisory lock elevation workers hold on
+// d while they fill it, on a connection set aside until UnlockElevation.
+// Returns false without waiting if another session holds it. The lock is
+// released by the server if the connection drops
+func (st *PSStore) TryLockElevation(d model.Dataset) (bool, error) {
+	pool, ok := st.DS.Connection().(*pgxpool.Pool)
+	if !ok {
+		return false, errors.New("advisory locks require a pgx connection pool")
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, held := st.locks[d.Id]; held {
+		return false, nil
+	}
+	conn, err := pool.Acquire(context.Background())
+	if err != nil {
+		return false, err
+	}
+	var locked bool
+	err = conn.QueryRow(context.Background(), datasetTable.Statements["tryLockElevation"], global.ELEVATION_LOCK_NAMESPACE, d.Id.String()).Scan(&locked)
+	if err != nil || !locked {
+		conn.Release()
+		return false, err
+	}
+	st.locks[d.Id] = conn
+	return true, nil
+}
+
+// UnlockElevation releases the lock taken by TryLockElevation and hands its
+// connection back to the pool. A connection that fails to unlock is closed
+// so that the lock can't outlive it
+func (st *PSStore) UnlockElevation(d model.Dataset) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	conn, held := st.locks[d.Id]
+	if !held {
+		return errors.New(fmt.Sprintf("dataset=%s is not locked", d.Name))
+	}
+	delete(st.locks, d.Id)
+	defer conn.Release()
+	_, err := conn.Exec(context.Background(), datasetTable.Statements["unlockElevation"], global.ELEVATION_LOCK_NAMESPACE, d.Id.String())
+	if err != nil {
+		conn.Conn().Close(context.Background())
+	}
+	return err
+}
+
+// MarkNullCoordinates records the NoGeometry reason on the empty points with
+// a null x or y, which the x, y partitions can't hold, and returns their count
+func (st *PSStore) MarkNullCoordinates(d model.Dataset) (int, error) {
+	var count int
+	err := st.DS.
+		Select(inventorySql("markNullCoordinates", d.TableName, "", "")).
+		Params(string(types.NoGeometry)).
+		Dest(&count).
+		Fetch()
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// filterSql builds the predicate of f from fixed fragments, values are bound
+// as params numbered after the offset params of the statement
+func filterSql(f elevation.Filter, offset int) (string, []interface{}) {
+	preds := []string{"true"}
+	var params []interface{}
+	add := func(pred string, values ...interface{}) {
+		var args []interface{}
+		for _, v := range values {
+			params = append(params, v)
+			args = append(args, offset+len(params))
+		}
+		preds = append(preds, fmt.Sprintf(pred, args...))
+	}
+	if len(f.Counties) > 0 {
+		add("left(cbfips2010, 5) = any($%d)", f.Counties)
+	}
+	if len(f.States) > 0 {
+		add("left(cbfips2010, 2) = any($%d)", f.States)
+	}
+	if b := f.BBox; b != nil {
+		add("x >= $%d and x <= $%d and y >= $%d and y <= $%d", b.MinX, b.MaxX, b.MinY, b.MaxY)
+	}
+	if len(f.Reasons) > 0 {
+		var reasons []string
+		for _, r := range f.Reasons {
+			reasons = append(reasons, string(r))
+		}
+		add(global.ELEVATION_REASON_COLUMN_NAME+" = any($%d)", reasons)
+	}
+	if len(f.Products) > 0 {
+		var products []string
+		for _, p := range f.Products {
+			products = append(products, string(p))
+		}
+		add(global.ELEVATION_PRODUCT_COLUMN_NAME+" = any($%d)", products)
+	}
+	return strings.Join(preds, " and "), params
+}
+
+// GetElevationCells returns the size x size cells, aligned to multiples of
+// size, that hold filled elevation points
+func (st *PSStore) GetElevationCells(d model.Dataset, size float64) ([]elevation.BoundingBox, error) {
+	var rows []cellRow
+	err := st.DS.
+		Select(inventorySql("elevationCells", d.TableName, "", "")).
+		Params(size).
+		Dest(&rows).
+		Fetch()
+	if err != nil {
+		return nil, err
+	}
+	var cells []elevation.BoundingBox
+	for _, r := range rows {
+		cells = append(cells, elevation.BoundingBox{
+			MinX: r.MinX,
+			MaxX: r.MinX + size,
+			MinY: r.MinY,
+			MaxY: r.MinY + size,
+		})
+	}
+	return cells, nil
+}
+
+// GetElevationPoints returns the filled points in the half open box
+// [MinX, MaxX) x [MinY, MaxY)
+func (st *PSStore) GetElevationPoints(d model.Dataset, b elevation.BoundingBox) (elevation.Points, error) {
+	var points elevation.Points
+	err := st.DS.
+		Select(inventorySql("selectElevationBox", d.TableName, "", "")).
+		Params(b.MinX, b.MaxX, b.MinY, b.MaxY).
+		Dest(&points).
+		Fetch()
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// SaveElevationReviews replaces the review rows of a dataset
+func (st *PSStore) SaveElevationReviews(d model.Dataset, reviews []model.ElevationReview) error {
+	var rows [][]interface{}
+	for _, r := range reviews {
+		rows = append(rows, []interface{}{
+			d.Id, r.FdId, r.X, r.Y, r.Elevation, string(r.Check), r.Expected, r.Difference, r.Samples, r.Created,
+		})
+	}
+	tx, err := st.DS.Transaction()
+	if err != nil {
+		return err
+	}
+	err = st.DS.Exec(&tx, elevationReviewTable.Statements["deleteByDataset"], d.Id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if len(rows) > 0 {
+		_, err = tx.PgxTx().CopyFrom(
+			context.Background(),
+			pgx.Identifier{DbSchema, elevationReviewTable.Name},
+			elevationReviewColumns,
+			pgx.CopyFromRows(rows),
+		)
+		if err != nil {
+			tx.Rollback()
+			return errors.New(fmt.Sprintf("unable to copy reviews of dataset=%s: %s", d.Name, err))
+		}
+	}
+	return tx.Commit()
+}
+
+// GetElevationReviews returns the review rows of a dataset ordered by fd_id
+func (st *PSStore) GetElevationReviews(d model.Dataset) ([]model.ElevationReview, error) {
+	var reviews []model.ElevationReview
+	err := st.DS.
+		Select().
+		DataSet(&elevationReviewTable).
+		StatementKey("selectByDataset").
+		Params(d.Id).
+		Dest(&reviews).
+		Fetch()
+	if err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
+
 //////////////////////////////////////////////////
 // Add row to sql table generically
 //////////////////////////////////////////////////
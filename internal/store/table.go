@@ -12,6 +12,25 @@ const (
 	DbSchema = global.DB_SCHEMA
 )
 
+// elevationColumns are added to an inventory table by AddElevationColumn
+var elevationColumns = []string{
+	global.ELEVATION_COLUMN_NAME,
+	global.ELEVATION_REASON_COLUMN_NAME,
+	global.ELEVATION_SOURCE_COLUMN_NAME,
+	global.ELEVATION_RES_COLUMN_NAME,
+	global.ELEVATION_PRODUCT_COLUMN_NAME,
+}
+
+// emptyElevationWhere selects the points that are neither filled nor marked
+// with a reason
+var emptyElevationWhere = fmt.Sprintf("%s is null and %s is null", global.ELEVATION_COLUMN_NAME, global.ELEVATION_REASON_COLUMN_NAME)
+
+// elevationStageTable is the temp table UpdateElevationAtPoint copies results into
+const elevationStageTable = "elevation_stage"
+
+// rasterStageTable is the temp table UpdateColumnAtPoint copies results into
+const rasterStageTable = "raster_stage"
+
 var datasetTable = goquery.TableDataSet{
 	Name:   "dataset",
 	Schema: DbSchema,
@@ -19,6 +38,10 @@ var datasetTable = goquery.TableDataSet{
 		"selectId":   `select id from dataset where name=$1 and version=$2 and purpose=$3 and quality_id=$4`,
 		"select":     `select * from dataset where name=$1 and version=$2 and quality_id=$3`,
 		"selectById": `select * from dataset where id=$1`,
+		"selectAll":  `select * from dataset order by date_created, id`,
+		// session advisory locks keyed by ELEVATION_LOCK_NAMESPACE and the dataset id
+		"tryLockElevation": `select pg_try_advisory_lock($1, hashtext($2))`,
+		"unlockElevation":  `select pg_advisory_unlock($1, hashtext($2))`,
 		"insertNullShape": `insert into dataset (
             name,
             version,
@@ -31,19 +54,222 @@ var datasetTable = goquery.TableDataSet{
             quality_id,
             group_id
         ) values ($1, $2, $3, $4, ST_Envelope('POLYGON((0 0, 0 0, 0 0, 0 0))'::geometry), $5, $6, $7, $8, $9) returning id`,
-		"updateBBox":            fmt.Sprintf(`update dataset set shape=(select ST_Envelope(ST_Collect(shape)) from %s.{table_name}) where id=$1`, DbSchema),
-		"structureInInventory":  fmt.Sprintf(`select fd_id from %s.{table_name} where X=$1 and Y=$2`, DbSchema),
-		"elevationColumnExists": `select exists (select 1 from information_schema.columns where table_schema=$1 and table_name=$2 and column_name=$3)`,
-		"addElevColumn":         fmt.Sprintf(`alter table %s.{table_name} add column %s double precision`, DbSchema, global.ELEVATION_COLUMN_NAME),
-		"selectEmptyElevationCoords": fmt.Sprintf(
-			// "select fd_id, X, Y, %s from %s.{table_name} where %s is null order by random() limit 3",
-			"select fd_id, X, Y, %s from %s.{table_name} where %s is null limit $1 offset $2",
+		"updateBBox":           `update dataset set shape=ST_MakeEnvelope($2, $3, $4, $5, $6) where id=$1`,
+		"structureInInventory": fmt.Sprintf(`select fd_id from %s.{table_name} where X=$1 and Y=$2`, DbSchema),
+		"columnExists":         `select exists (select 1 from information_schema.columns where table_schema=$1 and table_name=$2 and column_name=$3)`,
+		"addElevColumn": fmt.Sprintf(
+			`alter table %s.{table_name} add column if not exists %s double precision, add column if not exists %s text, add column if not exists %s text, add column if not exists %s double precision, add column if not exists %s text`,
+			DbSchema,
+			global.ELEVATION_COLUMN_NAME,
+			global.ELEVATION_REASON_COLUMN_NAME,
+			global.ELEVATION_SOURCE_COLUMN_NAME,
+			global.ELEVATION_RES_COLUMN_NAME,
+			global.ELEVATION_PRODUCT_COLUMN_NAME,
+		),
+		// inventory statistics over rows loaded after fd_id=$1
+		"maxFdId": fmt.Sprintf(`select coalesce(max(fd_id), 0) from %s.{table_name}`, DbSchema),
+		"extentSince": fmt.Sprintf(`select
+            count(*) as row_count,
+            ST_XMin(ST_Extent(shape)) as min_x,
+            ST_YMin(ST_Extent(shape)) as min_y,
+            ST_XMax(ST_Extent(shape)) as max_x,
+            ST_YMax(ST_Extent(shape)) as max_y,
+            coalesce(max(ST_SRID(shape)), 0) as srid
+        from %s.{table_name} where fd_id > $1`, DbSchema),
+		"countyCountsSince": fmt.Sprintf(`select left(cbfips2010, 5) as key, count(*) as count
+        from %s.{table_name} where fd_id > $1 and cbfips2010 is not null group by 1`, DbSchema),
+		"nullCountsSince": fmt.Sprintf(`select e.key as key, count(*) as count
+        from %s.{table_name} t, jsonb_each(to_jsonb(t) - 'shape') e
+        where t.fd_id > $1 and jsonb_typeof(e.value) = 'null' group by 1`, DbSchema),
+		// points to sample are selected by {where}, the empty points or the points of an overwrite run, by partition in fd_id order
+		"selectElevationRange": fmt.Sprintf(
+			"select fd_id, X, Y, %s from %s.{table_name} where {where} and x is not null and y is not null and fd_id > $1 and fd_id < $2 order by fd_id limit $3",
 			global.ELEVATION_COLUMN_NAME,
 			DbSchema,
+		),
+		"selectElevationCell": fmt.Sprintf(
+			"select fd_id, X, Y, %s from %s.{table_name} where {where} and x is not null and y is not null and fd_id > $1 and x >= $2 and x < $3 and y >= $4 and y < $5 order by fd_id limit $6",
 			global.ELEVATION_COLUMN_NAME,
-		), // TODO limit 10 for test
-		"updateElevation": fmt.Sprintf("update %s.{table_name} set %s=$1 where fd_id=$2", DbSchema, global.ELEVATION_COLUMN_NAME),
+			DbSchema,
+		),
+		// same as selectElevationRange with X, Y from the shape, srid -1 marks a null shape
+		"selectElevationShape": fmt.Sprintf(
+			"select fd_id, coalesce(ST_X(ST_PointOnSurface(shape)), 0) as x, coalesce(ST_Y(ST_PointOnSurface(shape)), 0) as y, coalesce(ST_SRID(shape), -1) as srid, x::float8 as attr_x, y::float8 as attr_y, %s from %s.{table_name} where {where} and fd_id > $1 and fd_id < $2 order by fd_id limit $3",
+			global.ELEVATION_COLUMN_NAME,
+			DbSchema,
+		),
+		"elevationFdIdRange": fmt.Sprintf(
+			"select count(*) as count, coalesce(min(fd_id), 0) as min_fd_id, coalesce(max(fd_id), 0) as max_fd_id from %s.{table_name} where {where}",
+			DbSchema,
+		),
+		"selectedElevationCells": fmt.Sprintf(
+			"select distinct floor(x / $1::float8) * $1::float8 as min_x, floor(y / $1::float8) * $1::float8 as min_y from %s.{table_name} where {where} and x is not null and y is not null",
+			DbSchema,
+		),
+		// empty points without x, y can't be sampled at them, marked with reason $1
+		"markNullCoordinates": fmt.Sprintf(
+			"with r as (update %s.{table_name} set %s=$1 where %s is null and %s is null and (x is null or y is null) returning 1) select count(*) from r",
+			DbSchema,
+			global.ELEVATION_REASON_COLUMN_NAME,
+			global.ELEVATION_COLUMN_NAME,
+			global.ELEVATION_REASON_COLUMN_NAME,
+		),
+		// filled points with x, y, read cell by cell by elevation qa
+		"pointCells": fmt.Sprintf(
+			"select distinct floor(x / $1::float8) * $1::float8 as min_x, floor(y / $1::float8) * $1::float8 as min_y from %s.{table_name} where x is not null and y is not null",
+			DbSchema,
+		),
+		"elevationCells": fmt.Sprintf(
+			"select distinct floor(x / $1::float8) * $1::float8 as min_x, floor(y / $1::float8) * $1::float8 as min_y from %s.{table_name} where %s is not null and x is not null and y is not null",
+			DbSchema,
+			global.ELEVATION_COLUMN_NAME,
+		),
+		"selectElevationBox": fmt.Sprintf(
+			"select fd_id, X, Y, %s from %s.{table_name} where %s is not null and x is not null and y is not null and x >= $1 and x < $2 and y >= $3 and y < $4 order by fd_id",
+			global.ELEVATION_COLUMN_NAME,
+			DbSchema,
+			global.ELEVATION_COLUMN_NAME,
+		),
+		// elevation results are copied into a session local stage table and applied with a single join per batch
+		"createElevationStage": fmt.Sprintf(
+			"create temp table %s (fd_id integer primary key, %s double precision, %s text, %s text, %s double precision, %s text) on commit drop",
+			elevationStageTable,
+			global.ELEVATION_COLUMN_NAME,
+			global.ELEVATION_REASON_COLUMN_NAME,
+			global.ELEVATION_SOURCE_COLUMN_NAME,
+			global.ELEVATION_RES_COLUMN_NAME,
+			global.ELEVATION_PRODUCT_COLUMN_NAME,
+		),
+		"updateElevationFromStage": fmt.Sprintf(
+			"update %s.{table_name} t set %[3]s=s.%[3]s, %[4]s=s.%[4]s, %[5]s=s.%[5]s, %[6]s=s.%[6]s, %[7]s=s.%[7]s from %[2]s s where t.fd_id=s.fd_id",
+			DbSchema,
+			elevationStageTable,
+			global.ELEVATION_COLUMN_NAME,
+			global.ELEVATION_REASON_COLUMN_NAME,
+			global.ELEVATION_SOURCE_COLUMN_NAME,
+			global.ELEVATION_RES_COLUMN_NAME,
+			global.ELEVATION_PRODUCT_COLUMN_NAME,
+		),
+		// raster sampling into any numeric column, {columns} is a single sanitized column name
+		"addRasterColumn":      fmt.Sprintf(`alter table %s.{table_name} add column if not exists {columns} double precision`, DbSchema),
+		"selectPoints":         fmt.Sprintf(`select fd_id, X, Y from %s.{table_name} where x is not null and y is not null and fd_id > $1 order by fd_id limit $2`, DbSchema),
+		"countNullCoordinates": fmt.Sprintf(`select count(*) from %s.{table_name} where x is null or y is null`, DbSchema),
+		"createRasterStage": fmt.Sprintf(
+			"create temp table %s (fd_id integer primary key, value double precision) on commit drop",
+			rasterStageTable,
+		),
+		"updateColumnFromStage": fmt.Sprintf(
+			"update %s.{table_name} t set {columns}=s.value from %s s where t.fd_id=s.fd_id",
+			DbSchema,
+			rasterStageTable,
+		),
+		// post-load optimization, {index_name} and {columns} are generated internally
+		"createShapeIndex": fmt.Sprintf(`create index if not exists {index_name} on %s.{table_name} using gist (shape)`, DbSchema),
+		"createIndex":      fmt.Sprintf(`create index if not exists {index_name} on %s.{table_name} ({columns})`, DbSchema),
+		"createEmptyElevationIndex": fmt.Sprintf(
+			`create index if not exists {index_name} on %s.{table_name} (fd_id) where %s is null`,
+			DbSchema,
+			global.ELEVATION_COLUMN_NAME,
+		),
+		"cluster": fmt.Sprintf(`cluster %s.{table_name} using {index_name}`, DbSchema),
+		// adopting existing tables, {columns} is a single sanitized column name
+		"tableExists":       `select exists (select from pg_tables where schemaname=$1 and tablename=$2)`,
+		"inventoryColumns":  `select column_name as name, data_type as type from information_schema.columns where table_schema=$1 and table_name=$2`,
+		"distinctValues":    fmt.Sprintf(`select distinct {columns}::text from %s.{table_name} where {columns} is not null order by 1`, DbSchema),
+		"addFdIdColumn":     fmt.Sprintf(`alter table %s.{table_name} add column fd_id serial`, DbSchema),
+		"createUniqueIndex": fmt.Sprintf(`create unique index if not exists {index_name} on %s.{table_name} ({columns})`, DbSchema),
+		"addShapeColumn":    fmt.Sprintf(`alter table %s.{table_name} add column shape geometry(Point, 4326)`, DbSchema),
+		"fillShape":         fmt.Sprintf(`update %s.{table_name} set shape=ST_SetSRID(ST_MakePoint(x, y), 4326) where shape is null`, DbSchema),
+		"analyze":           fmt.Sprintf(`analyze %s.{table_name}`, DbSchema),
+	},
+}
+
+var datasetStatsTable = goquery.TableDataSet{
+	Name:   "dataset_stats",
+	Schema: DbSchema,
+	Statements: map[string]string{
+		"select": `select
+            dataset_id, row_count, min_x, min_y, max_x, max_y, srid,
+            state_counts::text as state_counts,
+            county_counts::text as county_counts,
+            null_counts::text as null_counts,
+            last_load
+        from dataset_stats where dataset_id=$1`,
+		"upsert": `insert into dataset_stats (
+            dataset_id, row_count, min_x, min_y, max_x, max_y, srid, state_counts, county_counts, null_counts, last_load
+        ) values ($1, $2, $3, $4, $5, $6, $7, $8::jsonb, $9::jsonb, $10::jsonb, $11)
+        on conflict (dataset_id) do update set
+            row_count=excluded.row_count,
+            min_x=excluded.min_x,
+            min_y=excluded.min_y,
+            max_x=excluded.max_x,
+            max_y=excluded.max_y,
+            srid=excluded.srid,
+            state_counts=excluded.state_counts,
+            county_counts=excluded.county_counts,
+            null_counts=excluded.null_counts,
+            last_load=excluded.last_load
+        returning dataset_id`,
+	},
+}
+
+var elevationRunTable = goquery.TableDataSet{
+	Name:   "elevation_run",
+	Schema: DbSchema,
+	Statements: map[string]string{
+		"insert":          `insert into elevation_run (dataset_id, started, source, product, sampling) values ($1, $2, $3, $4, $5) returning id`,
+		"update":          `update elevation_run set ended=$2, filled=$3, failed=$4, mismatched=$5, error=$6 where id=$1 returning id`,
+		"selectByDataset": `select * from elevation_run where dataset_id=$1 order by started`,
+	},
+	Fields: model.ElevationRun{},
+}
+
+// enrichMatchTable is the temp table EnrichFromPolygons joins points and polygons into
+const enrichMatchTable = "enrich_match"
+
+// enrichTable statements join an inventory table to a polygon layer loaded by
+// ogr2ogr. {polygons} is the generated layer table, {attribute} and {columns}
+// are sanitized identifiers and {type} is the data type of the target column
+var enrichTable = goquery.TableDataSet{
+	Name:   "enrich",
+	Schema: DbSchema,
+	Statements: map[string]string{
+		"createMatch": fmt.Sprintf(`create temp table %[2]s on commit drop as
+        select t.fd_id,
+            count(p.{attribute}) as polygons,
+            count(distinct p.{attribute}) as matches,
+            min(p.{attribute}::text) as value
+        from %[1]s.{table_name} t
+        left join %[1]s.{polygons} p on ST_Intersects(p.geom, ST_SetSRID(ST_MakePoint(t.x, t.y), 4326))
+        group by t.fd_id`, DbSchema, enrichMatchTable),
+		"update": fmt.Sprintf(`with u as (
+            update %s.{table_name} t set {columns}=m.value::{type} from %s m where t.fd_id=m.fd_id and m.matches=1 returning 1
+        ) select count(*) from u`, DbSchema, enrichMatchTable),
+		"counts": fmt.Sprintf(`select
+            count(*) filter (where matches=0) as no_match,
+            count(*) filter (where polygons>1) as multiple,
+            count(*) filter (where matches>1) as conflicting
+        from %s`, enrichMatchTable),
+		"noMatchIds":  fmt.Sprintf(`select fd_id from %s where matches=0 order by fd_id limit $1`, enrichMatchTable),
+		"multipleIds": fmt.Sprintf(`select fd_id from %s where polygons>1 order by fd_id limit $1`, enrichMatchTable),
+		"addColumn":   fmt.Sprintf(`alter table %s.{table_name} add column if not exists {columns} {type}`, DbSchema),
+		"dropLayer":   fmt.Sprintf(`drop table if exists %s.{polygons}`, DbSchema),
+	},
+}
+
+var elevationReviewTable = goquery.TableDataSet{
+	Name:   "elevation_review",
+	Schema: DbSchema,
+	Statements: map[string]string{
+		"deleteByDataset": `delete from elevation_review where dataset_id=$1`,
+		"selectByDataset": `select * from elevation_review where dataset_id=$1 order by fd_id, check_type`,
 	},
+	Fields: model.ElevationReview{},
+}
+
+// elevationReviewColumns are copied into elevation_review by SaveElevationReviews
+var elevationReviewColumns = []string{
+	"dataset_id", "fd_id", "x", "y", global.ELEVATION_COLUMN_NAME, "check_type", "expected", "difference", "samples", "created",
 }
 
 var domainTable = goquery.TableDataSet{
@@ -60,9 +286,11 @@ var fieldTable = goquery.TableDataSet{
 	Name:   "field",
 	Schema: DbSchema,
 	Statements: map[string]string{
-		"select":     `select id from field where name=$1`,
-		"selectById": `select * from field where id=$1`,
-		"insert":     `insert into field (name, type, description, is_domain) values ($1, $2, $3, $4) returning id`,
+		"select":       `select id from field where name=$1 and type=$2`,
+		"selectByName": `select * from field where name=$1`,
+		"selectById":   `select * from field where id=$1`,
+		"insert":       `insert into field (name, type, description, is_domain, unit, vertical_datum) values ($1, $2, $3, $4, $5, $6) returning id`,
+		"updateUnit":   `update field set unit=$2, vertical_datum=$3 where id=$1`,
 	},
 	Fields: model.Field{},
 }
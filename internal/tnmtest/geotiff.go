@@ -0,0 +1,386 @@
+package tnmtest
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Raster is a float32 grid, single band unless Bands are set, in geographic coordinates (NAD83)
+// unless EPSG names a projected CRS of the extent. Values are stored row major
+// starting at the north west corner
+type Raster struct {
+	MinX, MaxX, MinY, MaxY float64
+	Width, Height          int
+	Values                 []float32
+	NoData                 *float64    // written as the GDAL_NODATA tag if set
+	EPSG                   int         // projected CRS, e.g. 26905 for UTM zone 5 like the 1m DEMs
+	Bands                  [][]float32 // further bands interleaved after Values by WriteTiledGeoTIFF
+	Predictor              uint16      // of the tiles of WriteTiledGeoTIFF, FloatPredictor if 0
+}
+
+// NewRaster samples f at the pixel centers of a width x height grid covering the extent
+func NewRaster(minX, maxX, minY, maxY float64, width, height int, f func(x, y float64) float64) Raster {
+	r := Raster{
+		MinX:   minX,
+		MaxX:   maxX,
+		MinY:   minY,
+		MaxY:   maxY,
+		Width:  width,
+		Height: height,
+		Values: make([]float32, width*height),
+	}
+	sx, sy := r.PixelSize()
+	for row := 0; row < height; row++ {
+		for col := 0; col < width; col++ {
+			x := minX + (float64(col)+0.5)*sx
+			y := maxY - (float64(row)+0.5)*sy
+			r.Values[row*width+col] = float32(f(x, y))
+		}
+	}
+	return r
+}
+
+// PixelSize returns the width and height of a pixel in map units
+func (r Raster) PixelSize() (float64, float64) {
+	return (r.MaxX - r.MinX) / float64(r.Width), (r.MaxY - r.MinY) / float64(r.Height)
+}
+
+// tiff tags and types used by WriteGeoTIFF
+const (
+	tagImageWidth       = 256
+	tagImageLength      = 257
+	tagBitsPerSample    = 258
+	tagCompression      = 259
+	tagPhotometric      = 262
+	tagStripOffsets     = 273
+	tagSamplesPerPixel  = 277
+	tagRowsPerStrip     = 278
+	tagStripByteCounts  = 279
+	tagPlanarConfig     = 284
+	tagPredictor        = 317
+	tagTileWidth        = 322
+	tagTileLength       = 323
+	tagTileOffsets      = 324
+	tagTileByteCounts   = 325
+	tagSampleFormat     = 339
+	tagModelPixelScale  = 33550
+	tagModelTiepoint    = 33922
+	tagGeoKeyDirectory  = 34735
+	tagGdalNoData       = 42113
+	typeAscii           = 2
+	typeShort           = 3
+	typeLong            = 4
+	typeDouble          = 12
+	sampleFormatFloat   = 3
+	modelTypeProjected  = 1
+	modelTypeGeographic = 2
+	rasterPixelIsArea   = 1
+	gcsNAD83            = 4269
+)
+
+// Compression of the tiles written by WriteTiledGeoTIFF
+const (
+	LZW     = 5
+	Deflate = 8
+)
+
+// Predictor applied to the tiles written by WriteTiledGeoTIFF
+const (
+	HorizontalPredictor = 2
+	FloatPredictor      = 3
+)
+
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte // little endian payload, stored inline if <= 4 bytes
+}
+
+// WriteGeoTIFF encodes r as an uncompressed, single strip, little endian GeoTIFF
+func (r Raster) WriteGeoTIFF(w io.Writer) error {
+	if r.Width <= 0 || r.Height <= 0 || len(r.Values) != r.Width*r.Height {
+		return errors.New(fmt.Sprintf("invalid raster size=%dx%d for %d values", r.Width, r.Height, len(r.Values)))
+	}
+	pixels := new(bytes.Buffer)
+	binary.Write(pixels, binary.LittleEndian, r.Values)
+
+	entries := []ifdEntry{
+		longEntry(tagImageWidth, uint32(r.Width)),
+		longEntry(tagImageLength, uint32(r.Height)),
+		shortEntry(tagBitsPerSample, 32),
+		shortEntry(tagCompression, 1),
+		shortEntry(tagPhotometric, 1),
+		longEntry(tagStripOffsets, 0), // patched once the layout is known
+		shortEntry(tagSamplesPerPixel, 1),
+		longEntry(tagRowsPerStrip, uint32(r.Height)),
+		longEntry(tagStripByteCounts, uint32(pixels.Len())),
+		shortEntry(tagPlanarConfig, 1),
+		shortEntry(tagSampleFormat, sampleFormatFloat),
+	}
+	return r.encode(w, entries, tagStripOffsets, [][]byte{pixels.Bytes()})
+}
+
+// WriteTiledGeoTIFF encodes r like the National Map DEMs, in square tiles of
+// tileSize pixels compressed with LZW or Deflate after the floating point
+// predictor, or the horizontal one if set. Tiles past the edges of r are
+// padded with zeros
+func (r Raster) WriteTiledGeoTIFF(w io.Writer, tileSize int, compression uint16) error {
+	if r.Width <= 0 || r.Height <= 0 || len(r.Values) != r.Width*r.Height {
+		return errors.New(fmt.Sprintf("invalid raster size=%dx%d for %d values", r.Width, r.Height, len(r.Values)))
+	}
+	for i, band := range r.Bands {
+		if len(band) != len(r.Values) {
+			return errors.New(fmt.Sprintf("invalid band=%d of %d values, expected %d", i+2, len(band), len(r.Values)))
+		}
+	}
+	predictor := r.Predictor
+	if predictor == 0 {
+		predictor = FloatPredictor
+	}
+	if predictor != HorizontalPredictor && predictor != FloatPredictor {
+		return errors.New(fmt.Sprintf("unsupported predictor=%d", predictor))
+	}
+	samples := 1 + len(r.Bands)
+	if tileSize <= 0 || tileSize%16 != 0 {
+		return errors.New(fmt.Sprintf("invalid tile size=%d, must be a multiple of 16", tileSize))
+	}
+	var tiles [][]byte
+	var counts []uint32
+	for ty := 0; ty < r.Height; ty += tileSize {
+		for tx := 0; tx < r.Width; tx += tileSize {
+			raw := new(bytes.Buffer)
+			for y := ty; y < ty+tileSize; y++ {
+				row := make([]float32, tileSize*samples)
+				for x := tx; x < tx+tileSize && y < r.Height && x < r.Width; x++ {
+					row[(x-tx)*samples] = r.Values[y*r.Width+x]
+					for b, band := range r.Bands {
+						row[(x-tx)*samples+1+b] = band[y*r.Width+x]
+					}
+				}
+				if predictor == HorizontalPredictor {
+					raw.Write(horizontalPredictor(row, samples))
+				} else {
+					raw.Write(floatPredictor(row, samples))
+				}
+			}
+			tile, err := compress(raw.Bytes(), compression)
+			if err != nil {
+				return err
+			}
+			tiles = append(tiles, tile)
+			counts = append(counts, uint32(len(tile)))
+		}
+	}
+	entries := []ifdEntry{
+		longEntry(tagImageWidth, uint32(r.Width)),
+		longEntry(tagImageLength, uint32(r.Height)),
+		shortEntry(tagBitsPerSample, 32),
+		shortEntry(tagCompression, compression),
+		shortEntry(tagPhotometric, 1),
+		shortEntry(tagSamplesPerPixel, uint16(samples)),
+		shortEntry(tagPlanarConfig, 1),
+		shortEntry(tagPredictor, predictor),
+		longEntry(tagTileWidth, uint32(tileSize)),
+		longEntry(tagTileLength, uint32(tileSize)),
+		longEntry(tagTileOffsets, make([]uint32, len(tiles))...), // patched once the layout is known
+		longEntry(tagTileByteCounts, counts...),
+		shortEntry(tagSampleFormat, sampleFormatFloat),
+	}
+	return r.encode(w, entries, tagTileOffsets, tiles)
+}
+
+// floatPredictor splits a row of samples into byte planes, most significant
+// first, and differences each byte with the one stride samples per pixel
+// before it (TIFF predictor 3)
+func floatPredictor(row []float32, stride int) []byte {
+	n := len(row)
+	b := make([]byte, 4*n)
+	for i, v := range row {
+		bits := math.Float32bits(v)
+		for p := 0; p < 4; p++ {
+			b[p*n+i] = byte(bits >> (24 - 8*p))
+		}
+	}
+	for i := len(b) - 1; i >= stride; i-- {
+		b[i] -= b[i-stride]
+	}
+	return b
+}
+
+// horizontalPredictor differences the 32 bit words of a row of samples with
+// the word of the same band in the previous pixel (TIFF predictor 2)
+func horizontalPredictor(row []float32, stride int) []byte {
+	words := make([]uint32, len(row))
+	for i, v := range row {
+		words[i] = math.Float32bits(v)
+	}
+	for i := len(words) - 1; i >= stride; i-- {
+		words[i] -= words[i-stride]
+	}
+	b := make([]byte, 4*len(words))
+	for i, w := range words {
+		binary.LittleEndian.PutUint32(b[4*i:], w)
+	}
+	return b
+}
+
+func compress(b []byte, compression uint16) ([]byte, error) {
+	out := new(bytes.Buffer)
+	switch compression {
+	case Deflate:
+		zw := zlib.NewWriter(out)
+		_, err := zw.Write(b)
+		if err != nil {
+			return nil, err
+		}
+		err = zw.Close()
+		if err != nil {
+			return nil, err
+		}
+	case LZW:
+		writeLZW(out, b)
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported compression=%d", compression))
+	}
+	return out.Bytes(), nil
+}
+
+// writeLZW encodes b as TIFF LZW with 9 bit literal codes only, clearing the
+// table before it grows past 9 bit codes. Valid though barely compressed
+func writeLZW(out *bytes.Buffer, b []byte) {
+	const clear, eoi = 256, 257
+	var acc uint32
+	var bits uint
+	put := func(code uint32) {
+		acc = acc<<9 | code
+		bits += 9
+		for bits >= 8 {
+			out.WriteByte(byte(acc >> (bits - 8)))
+			bits -= 8
+		}
+	}
+	for i, c := range b {
+		if i%200 == 0 {
+			put(clear)
+		}
+		put(uint32(c))
+	}
+	put(eoi)
+	if bits > 0 {
+		out.WriteByte(byte(acc << (8 - bits)))
+	}
+}
+
+// encode lays out a little endian GeoTIFF of entries, the georeferencing of r
+// and blocks, with the offsets of blocks written to offsetsTag
+func (r Raster) encode(w io.Writer, entries []ifdEntry, offsetsTag uint16, blocks [][]byte) error {
+	sx, sy := r.PixelSize()
+	keys := []uint16{1, 1, 0, 3,
+		1024, 0, 1, modelTypeGeographic,
+		1025, 0, 1, rasterPixelIsArea,
+		2048, 0, 1, gcsNAD83,
+	}
+	if r.EPSG != 0 {
+		keys = []uint16{1, 1, 0, 3,
+			1024, 0, 1, modelTypeProjected,
+			1025, 0, 1, rasterPixelIsArea,
+			3072, 0, 1, uint16(r.EPSG),
+		}
+	}
+	entries = append(entries,
+		doubleEntry(tagModelPixelScale, sx, sy, 0),
+		doubleEntry(tagModelTiepoint, 0, 0, 0, r.MinX, r.MaxY, 0),
+		shortEntry(tagGeoKeyDirectory, keys...),
+	)
+	if r.NoData != nil {
+		s := fmt.Sprintf("%g\x00", *r.NoData)
+		entries = append(entries, ifdEntry{tag: tagGdalNoData, typ: typeAscii, count: uint32(len(s)), data: []byte(s)})
+	}
+	// entries are sorted by tag
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	// header | ifd | out of line entry data | blocks
+	ifdSize := 2 + 12*len(entries) + 4
+	offset := 8 + ifdSize
+	layout := func() (*bytes.Buffer, []uint32) {
+		extra := new(bytes.Buffer)
+		offsets := make([]uint32, len(entries))
+		for i, e := range entries {
+			if len(e.data) > 4 {
+				offsets[i] = uint32(offset + extra.Len())
+				extra.Write(e.data)
+				if extra.Len()%2 == 1 {
+					extra.WriteByte(0) // values start on a word boundary
+				}
+			}
+		}
+		return extra, offsets
+	}
+	extra, _ := layout()
+	blockOffsets := make([]uint32, len(blocks))
+	at := uint32(offset + extra.Len())
+	for i, b := range blocks {
+		blockOffsets[i] = at
+		at += uint32(len(b))
+	}
+	for i, e := range entries {
+		if e.tag == offsetsTag {
+			entries[i] = longEntry(offsetsTag, blockOffsets...)
+		}
+	}
+	extra, offsets := layout()
+
+	out := new(bytes.Buffer)
+	out.WriteString("II")
+	binary.Write(out, binary.LittleEndian, uint16(42))
+	binary.Write(out, binary.LittleEndian, uint32(8))
+	binary.Write(out, binary.LittleEndian, uint16(len(entries)))
+	for i, e := range entries {
+		binary.Write(out, binary.LittleEndian, e.tag)
+		binary.Write(out, binary.LittleEndian, e.typ)
+		binary.Write(out, binary.LittleEndian, e.count)
+		if len(e.data) > 4 {
+			binary.Write(out, binary.LittleEndian, offsets[i])
+		} else {
+			inline := make([]byte, 4)
+			copy(inline, e.data)
+			out.Write(inline)
+		}
+	}
+	binary.Write(out, binary.LittleEndian, uint32(0)) // no next ifd
+	out.Write(extra.Bytes())
+	for _, b := range blocks {
+		out.Write(b)
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func shortEntry(tag uint16, values ...uint16) ifdEntry {
+	b := new(bytes.Buffer)
+	binary.Write(b, binary.LittleEndian, values)
+	return ifdEntry{tag: tag, typ: typeShort, count: uint32(len(values)), data: b.Bytes()}
+}
+
+func longEntry(tag uint16, values ...uint32) ifdEntry {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(b[4*i:], v)
+	}
+	return ifdEntry{tag: tag, typ: typeLong, count: uint32(len(values)), data: b}
+}
+
+func doubleEntry(tag uint16, values ...float64) ifdEntry {
+	b := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(b[8*i:], math.Float64bits(v))
+	}
+	return ifdEntry{tag: tag, typ: typeDouble, count: uint32(len(values)), data: b}
+}
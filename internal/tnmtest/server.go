@@ -0,0 +1,271 @@
+// Package tnmtest is a stand-in for the TNM Access API of the National Map.
+// It answers /api/v1/products bbox and name queries from a fixture file and
+// serves small synthetic GeoTIFFs for every product, so that the elevation
+// pipeline can be exercised without network access.
+package tnmtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// BaseURLToken is replaced by the server url in the downloadURL of every fixture item
+const BaseURLToken = "{base}"
+
+// ProductsPath is the products endpoint of the TNM Access API
+const ProductsPath = "/api/v1/products"
+
+// DefaultPageSize is the number of items returned without a max param
+const DefaultPageSize = 50
+
+// TiffPath prefixes the download urls of the synthetic GeoTIFFs
+const TiffPath = "/tiff/"
+
+// Elevation is the default surface sampled into the synthetic GeoTIFFs
+func Elevation(x float64, y float64) float64 {
+	return 10*(x+180) + 20*y
+}
+
+// fixtureItem keeps the raw product json next to the fields used for filtering
+type fixtureItem struct {
+	raw         map[string]interface{}
+	title       string
+	downloadURL string
+	datasets    []string
+	bbox        [4]float64 // minX, minY, maxX, maxY
+}
+
+// Server serves the fixture products. Elevation, Width and Height may be
+// changed before the first download. The next FailTiffs downloads are
+// answered with 503 Service Unavailable. MaxPageSize caps the max param of
+// product queries, 0 for no cap
+type Server struct {
+	*httptest.Server
+	Elevation     func(x, y float64) float64
+	Width, Height int
+	FailTiffs     int
+	MaxPageSize   int
+
+	items []fixtureItem
+	mu    sync.Mutex
+	hits  map[string]int
+}
+
+// NewServer starts a server for the fixture file, a TNM products response
+// whose items use BaseURLToken in place of the download host
+func NewServer(fixture string) (*Server, error) {
+	b, err := os.ReadFile(fixture)
+	if err != nil {
+		return nil, err
+	}
+	var f struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid fixture=%s: %s", fixture, err))
+	}
+	s := Server{
+		Elevation: Elevation,
+		Width:     12,
+		Height:    12,
+		hits:      map[string]int{},
+	}
+	for _, raw := range f.Items {
+		item, err := newFixtureItem(raw)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid fixture=%s: %s", fixture, err))
+		}
+		s.items = append(s.items, item)
+	}
+	s.Server = httptest.NewServer(&s)
+	return &s, nil
+}
+
+func newFixtureItem(raw map[string]interface{}) (fixtureItem, error) {
+	item := fixtureItem{raw: raw}
+	item.title, _ = raw["title"].(string)
+	item.downloadURL, _ = raw["downloadURL"].(string)
+	datasets, _ := raw["datasets"].([]interface{})
+	for _, d := range datasets {
+		if v, ok := d.(string); ok {
+			item.datasets = append(item.datasets, v)
+		}
+	}
+	b, ok := raw["boundingBox"].(map[string]interface{})
+	if !ok {
+		return fixtureItem{}, errors.New(fmt.Sprintf("item=%s has no boundingBox", item.title))
+	}
+	for i, k := range []string{"minX", "minY", "maxX", "maxY"} {
+		v, ok := b[k].(float64)
+		if !ok {
+			return fixtureItem{}, errors.New(fmt.Sprintf("item=%s has no boundingBox.%s", item.title, k))
+		}
+		item.bbox[i] = v
+	}
+	return item, nil
+}
+
+// inDatasets reports whether the item belongs to one of the datasets
+func (i fixtureItem) inDatasets(datasets []string) bool {
+	for _, d := range i.datasets {
+		for _, v := range datasets {
+			if d == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Hits returns the number of requests received for a url path
+func (s *Server) Hits(p string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[p]
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.hits[r.URL.Path]++
+	s.mu.Unlock()
+	switch {
+	case r.URL.Path == ProductsPath:
+		s.serveProducts(w, r)
+	case strings.HasPrefix(r.URL.Path, TiffPath):
+		s.serveTiff(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveProducts filters the fixture by the bbox, datasets and q params and pages the
+// result by the offset and max params like the TNM API
+func (s *Server) serveProducts(w http.ResponseWriter, r *http.Request) {
+	offset, max := 0, DefaultPageSize
+	for k, v := range map[string]*int{"offset": &offset, "max": &max} {
+		if p := r.URL.Query().Get(k); p != "" {
+			n, err := strconv.Atoi(p)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("invalid %s=%s", k, p), http.StatusBadRequest)
+				return
+			}
+			*v = n
+		}
+	}
+	s.mu.Lock()
+	if s.MaxPageSize > 0 && max > s.MaxPageSize {
+		max = s.MaxPageSize
+	}
+	s.mu.Unlock()
+	var bbox []float64
+	if v := r.URL.Query().Get("bbox"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			f, err := strconv.ParseFloat(t, 64)
+			if err != nil {
+				http.Error(w, "invalid bbox="+v, http.StatusBadRequest)
+				return
+			}
+			bbox = append(bbox, f)
+		}
+		if len(bbox) != 4 {
+			http.Error(w, "invalid bbox="+v, http.StatusBadRequest)
+			return
+		}
+	}
+	q := r.URL.Query().Get("q")
+	datasets := r.URL.Query().Get("datasets")
+	items := []map[string]interface{}{}
+	for _, i := range s.items {
+		if bbox != nil && (i.bbox[2] < bbox[0] || i.bbox[0] > bbox[2] || i.bbox[3] < bbox[1] || i.bbox[1] > bbox[3]) {
+			continue
+		}
+		if q != "" && !strings.Contains(i.title, q) && !strings.Contains(i.downloadURL, q) {
+			continue
+		}
+		if datasets != "" && !i.inDatasets(strings.Split(datasets, ",")) {
+			continue
+		}
+		items = append(items, s.rewrite(i))
+	}
+	total := len(items)
+	if offset > total {
+		offset = total
+	}
+	if offset+max < total {
+		items = items[:offset+max]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":    total,
+		"items":    items[offset:],
+		"errors":   []string{},
+		"messages": []string{},
+	})
+}
+
+// rewrite points the download urls of an item at this server and reports the
+// size of the synthetic GeoTIFF, so that downloads can be verified
+func (s *Server) rewrite(i fixtureItem) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range i.raw {
+		out[k] = v
+	}
+	var b bytes.Buffer
+	if s.raster(i).WriteGeoTIFF(&b) == nil {
+		out["sizeInBytes"] = b.Len()
+	}
+	out["downloadURL"] = strings.ReplaceAll(i.downloadURL, BaseURLToken, s.URL)
+	if urls, ok := i.raw["urls"].(map[string]interface{}); ok {
+		rewritten := map[string]interface{}{}
+		for k, v := range urls {
+			if u, ok := v.(string); ok {
+				v = strings.ReplaceAll(u, BaseURLToken, s.URL)
+			}
+			rewritten[k] = v
+		}
+		out["urls"] = rewritten
+	}
+	return out
+}
+
+// raster is the synthetic GeoTIFF of an item
+func (s *Server) raster(i fixtureItem) Raster {
+	return NewRaster(i.bbox[0], i.bbox[2], i.bbox[1], i.bbox[3], s.Width, s.Height, s.Elevation)
+}
+
+// serveTiff renders the synthetic GeoTIFF of the item downloaded from the path
+func (s *Server) serveTiff(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	fail := s.FailTiffs > 0
+	if fail {
+		s.FailTiffs--
+	}
+	s.mu.Unlock()
+	if fail {
+		http.Error(w, "tile temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	name := path.Base(r.URL.Path)
+	for _, i := range s.items {
+		if path.Base(i.downloadURL) != name {
+			continue
+		}
+		w.Header().Set("Content-Type", "image/tiff")
+		err := s.raster(i).WriteGeoTIFF(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	http.NotFound(w, r)
+}
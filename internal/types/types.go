@@ -27,10 +27,56 @@ var (
 	}
 )
 
+var (
+	// DatatypePostgres maps information_schema.columns.data_type of an existing
+	// inventory table to a Datatype
+	DatatypePostgres = map[string]Datatype{
+		"text":              Char,
+		"character":         Char,
+		"character varying": Char,
+		"numeric":           Number,
+		"smallint":          Number,
+		"integer":           Number,
+		"bigint":            Number,
+		"real":              Float,
+		"double precision":  Float,
+		"date":              Date,
+	}
+)
+
 func (t Datatype) String() string {
 	return DatatypeReadable[t]
 }
 
+var (
+	// DatatypeOgrCast maps each Datatype to the OGR SQL CAST target used to
+	// coerce a shp column into the type already held by the field registry
+	DatatypeOgrCast = map[Datatype]string{
+		Char:   "character",
+		Number: "numeric",
+		Float:  "float",
+		Date:   "date",
+	}
+)
+
+// FieldConflict is the policy applied when a shp field arrives with the same
+// name as a registry field but a different type
+type FieldConflict string
+
+const (
+	Fail   FieldConflict = "fail"   // abort the upload
+	Coerce               = "coerce" // cast shp column into the registry type
+	Create               = "new"    // register a new field under the same name
+)
+
+var (
+	FieldConflictReverse = map[string]FieldConflict{
+		"fail":   Fail,
+		"coerce": Coerce,
+		"new":    Create,
+	}
+)
+
 type Quality string
 
 const (
@@ -95,20 +141,164 @@ var (
 // 	MULTIPATCH   = 31
 // )
 
+// SamplingMethod is the interpolation used to read elevation at a point
+type SamplingMethod string
+
+const (
+	Nearest  SamplingMethod = "nearest"  // value of the pixel containing the point
+	Bilinear                = "bilinear" // weighted 2x2 pixel centers around the point
+	Bicubic                 = "bicubic"  // catmull-rom over 4x4 pixel centers around the point
+)
+
+var (
+	SamplingMethodReverse = map[string]SamplingMethod{
+		"nearest":  Nearest,
+		"bilinear": Bilinear,
+		"bicubic":  Bicubic,
+	}
+)
+
+// ElevationReason records why a point was left without elevation so that it
+// is not selected again
+type ElevationReason string
+
+const (
+	NoData       ElevationReason = "nodata"         // the raster holds nodata at the point
+	NoCoverage                   = "no_coverage"    // no raster covers the point
+	Unresolved                   = "unresolved"     // the source returned neither a value nor a reason
+	NoDatumShift                 = "no_datum_shift" // the geoid grid holds no shift at the point
+	NoGeometry                   = "no_geometry"    // the shape or the x, y columns it is sampled at are null
+	InvalidSRID                  = "invalid_srid"   // the srid of the shape can't be reprojected
+)
+
+var (
+	ElevationReasonReverse = map[string]ElevationReason{
+		"nodata":         NoData,
+		"no_coverage":    NoCoverage,
+		"unresolved":     Unresolved,
+		"no_datum_shift": NoDatumShift,
+		"no_geometry":    NoGeometry,
+		"invalid_srid":   InvalidSRID,
+	}
+)
+
+// ElevationUnit is the vertical unit ground elevation is written in. Sources
+// are sampled in meters
+type ElevationUnit string
+
+const (
+	Meters ElevationUnit = "meters"
+	Feet                 = "feet" // international foot, 0.3048 meters
+)
+
+var (
+	ElevationUnitReverse = map[string]ElevationUnit{
+		"meters": Meters,
+		"feet":   Feet,
+	}
+)
+
+// ElevationProduct is a DEM product of the National Map, named by its resolution
+type ElevationProduct string
+
+const (
+	OneMeter       ElevationProduct = "1m"  // 3DEP 1 meter DEM
+	NinthArcSecond                  = "1/9" // NED 1/9 arc-second, about 3 meters
+	ThirdArcSecond                  = "1/3" // NED 1/3 arc-second, about 10 meters
+	OneArcSecond                    = "1"   // NED 1 arc-second, about 30 meters, covers Alaska and the territories
+)
+
+var (
+	ElevationProductReverse = map[string]ElevationProduct{
+		"1m":  OneMeter,
+		"1/9": NinthArcSecond,
+		"1/3": ThirdArcSecond,
+		"1":   OneArcSecond,
+	}
+)
+
+// ElevationCoordinates is where the coordinates elevation is sampled at are read from
+type ElevationCoordinates string
+
+const (
+	XYCoordinates    ElevationCoordinates = "xy"    // x, y columns, lon/lat
+	ShapeCoordinates                      = "shape" // shape geometry, reprojected from its srid
+)
+
+var (
+	ElevationCoordinatesReverse = map[string]ElevationCoordinates{
+		"xy":    XYCoordinates,
+		"shape": ShapeCoordinates,
+	}
+)
+
+// ElevationPartition is how the empty points of a dataset are split into
+// units of work for the elevation scheduler
+type ElevationPartition string
+
+const (
+	TilePartition  ElevationPartition = "tile" // one unit per DEM tile sized cell
+	RangePartition                    = "fdid" // one unit per fd_id range
+)
+
+var (
+	ElevationPartitionReverse = map[string]ElevationPartition{
+		"tile": TilePartition,
+		"fdid": RangePartition,
+	}
+)
+
+// ElevationCheck is the QA check that flagged a ground elevation for review
+type ElevationCheck string
+
+const (
+	NeighborCheck ElevationCheck = "neighbors" // differs from the median of nearby structures
+	DemCheck                     = "dem"       // differs from the median of the DEM around the structure
+)
+
+var (
+	ElevationCheckReverse = map[string]ElevationCheck{
+		"neighbors": NeighborCheck,
+		"dem":       DemCheck,
+	}
+)
+
 type Mode string
 
 const (
-	Prep      Mode = "prep"
-	Upload         = "upload"
-	Access         = "access"
-	Elevation      = "elevation"
+	Prep            Mode = "prep"
+	Upload               = "upload"
+	Access               = "access"
+	Elevation            = "elevation"
+	Show                 = "show"
+	Adopt                = "adopt"
+	DemList              = "dem_list"
+	DemVerify            = "dem_verify"
+	DemPrune             = "dem_prune"
+	DemPrefetch          = "dem_prefetch"
+	ElevationQA          = "elevation_qa"
+	Raster               = "raster"
+	Enrich               = "enrich"
+	ElevationAssess      = "elevation_assess"
+	ElevationWorker      = "elevation_worker"
 )
 
 var (
 	ModeReverse = map[string]Mode{
-		"prep":      Prep,
-		"upload":    Upload,
-		"access":    Access,
-		"elevation": Elevation,
+		"prep":             Prep,
+		"upload":           Upload,
+		"access":           Access,
+		"elevation":        Elevation,
+		"show":             Show,
+		"adopt":            Adopt,
+		"dem_list":         DemList,
+		"dem_verify":       DemVerify,
+		"dem_prune":        DemPrune,
+		"dem_prefetch":     DemPrefetch,
+		"elevation_qa":     ElevationQA,
+		"raster":           Raster,
+		"enrich":           Enrich,
+		"elevation_assess": ElevationAssess,
+		"elevation_worker": ElevationWorker,
 	}
 )
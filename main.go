@@ -64,6 +64,16 @@ func main() {
 								Usage:    "Path to shp file",
 								Required: true,
 							},
+							&cli.StringFlag{
+								Name:    "fieldConflict",
+								Aliases: []string{"f"},
+								Usage:   "Policy when a field name exists in the registry under another type: fail / coerce / new",
+								Value:   "fail",
+							},
+							&cli.BoolFlag{
+								Name:  "cluster",
+								Usage: "Cluster inventory table on its spatial index after upload",
+							},
 						},
 					},
 					{
@@ -132,6 +142,627 @@ func main() {
 								Usage:    "PostGIS connection string",
 								Required: true,
 							},
+							&cli.PathFlag{
+								Name:  "demSource",
+								Usage: "Directory of GeoTIFFs or a GDAL VRT to sample instead of the National Map",
+							},
+							&cli.StringFlag{
+								Name:  "sampling",
+								Usage: "Elevation sampling method: nearest / bilinear / bicubic",
+								Value: "nearest",
+							},
+							&cli.StringFlag{
+								Name:  "tnmUrl",
+								Usage: "Base url of the TNM Access API, e.g. a local stand-in server",
+								Value: global.NATIONAL_MAP_URL,
+							},
+							&cli.Float64Flag{
+								Name:  "tnmRate",
+								Usage: "TNM product queries per second across all workers, 0 for no limit",
+								Value: global.NATIONAL_MAP_REQUEST_RATE,
+							},
+							&cli.StringFlag{
+								Name:  "products",
+								Usage: "National Map products to sample, best first, each point is sampled from the first product with a value: 1m, 1/9, 1/3, 1",
+								Value: global.NATIONAL_MAP_PRODUCTS,
+							},
+							&cli.PathFlag{
+								Name:  "demCache",
+								Usage: "Directory National Map tiles are downloaded to",
+								Value: global.NATIONAL_MAP_CACHE_BASEPATH,
+							},
+							&cli.IntFlag{
+								Name:  "workers",
+								Usage: "Number of partitions sampled concurrently",
+								Value: global.ELEVATION_NO_PARALLEL_ROUTINES,
+							},
+							&cli.StringFlag{
+								Name:  "partition",
+								Usage: "Split the empty points by DEM tile / fd_id range: tile / fdid",
+								Value: string(types.TilePartition),
+							},
+							&cli.IntFlag{
+								Name:  "retries",
+								Usage: "Failed batches retried before the run is aborted",
+								Value: global.ELEVATION_RETRY_BUDGET,
+							},
+							&cli.StringFlag{
+								Name:  "coords",
+								Usage: "Sample at the x, y columns (xy) or at the shape geometry reprojected from its srid (shape), shape reports points whose x, y disagree with it and implies --partition fdid",
+								Value: string(types.XYCoordinates),
+							},
+							&cli.StringFlag{
+								Name:  "units",
+								Usage: "Unit ground_elev is written in: meters or feet, recorded in the field registry",
+								Value: string(types.Meters),
+							},
+							&cli.PathFlag{
+								Name:  "geoid",
+								Usage: "Grid of offsets in meters from NAVD88 to --datum, sampled bilinear",
+							},
+							&cli.StringFlag{
+								Name:  "datum",
+								Usage: "Vertical datum ground_elev is written in, recorded in the field registry",
+								Value: global.NATIONAL_MAP_VERTICAL_DATUM,
+							},
+							&cli.BoolFlag{
+								Name:  "overwrite",
+								Usage: "Resample points that already hold an elevation or a reason, the ones selected by --where or all of them with --all",
+							},
+							&cli.BoolFlag{
+								Name:  "all",
+								Usage: "Let --overwrite empty and resample every point of the dataset",
+							},
+							&cli.StringFlag{
+								Name:  "where",
+								Usage: "Points --overwrite resamples, ; separated conditions: county=15001,15003 / state=15 / bbox=minX,minY,maxX,maxY / reason=nodata / product=1/3",
+							},
+						},
+					},
+					{
+						Name:  "raster",
+						Usage: "Sample a band of a local raster into a column of an inventory table",
+						Action: func(c *cli.Context) error {
+							err := core.Core(c, types.Raster)
+							return err
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "dataset",
+								Aliases:  []string{"d"},
+								Usage:    "Dataset name",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "version",
+								Aliases:  []string{"v"},
+								Usage:    "Dataset version",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "quality",
+								Aliases:  []string{"q"},
+								Usage:    "Dataset quality",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "sqlConn",
+								Aliases:  []string{"s"},
+								Usage:    "PostGIS connection string",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "column",
+								Aliases:  []string{"c"},
+								Usage:    "Column the raster is sampled into, added as double precision and registered as a float field",
+								Required: true,
+							},
+							&cli.PathFlag{
+								Name:     "raster",
+								Aliases:  []string{"r"},
+								Usage:    "GeoTIFF, GDAL VRT or directory of them",
+								Required: true,
+							},
+							&cli.IntFlag{
+								Name:  "band",
+								Usage: "Band sampled, numbered from 1",
+								Value: 1,
+							},
+							&cli.StringFlag{
+								Name:  "sampling",
+								Usage: "Sampling method: nearest / bilinear / bicubic, keep nearest for categories such as land cover",
+								Value: "nearest",
+							},
+						},
+					},
+					{
+						Name:  "enrich",
+						Usage: "Fill a column of an inventory table from the attribute of the polygons each structure falls in",
+						Action: func(c *cli.Context) error {
+							err := core.Core(c, types.Enrich)
+							return err
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "dataset",
+								Aliases:  []string{"d"},
+								Usage:    "Dataset name",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "version",
+								Aliases:  []string{"v"},
+								Usage:    "Dataset version",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "quality",
+								Aliases:  []string{"q"},
+								Usage:    "Dataset quality",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "sqlConn",
+								Aliases:  []string{"s"},
+								Usage:    "PostGIS connection string",
+								Required: true,
+							},
+							&cli.PathFlag{
+								Name:     "polygons",
+								Aliases:  []string{"p"},
+								Usage:    "Polygon layer file ogr2ogr reads, e.g. a GeoPackage or shapefile",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:  "layer",
+								Usage: "Layer of --polygons, the first layer if empty",
+							},
+							&cli.StringFlag{
+								Name:     "attribute",
+								Aliases:  []string{"a"},
+								Usage:    "Polygon attribute written to --column, e.g. FLD_ZONE",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "column",
+								Aliases:  []string{"c"},
+								Usage:    "Column the attribute is written to, added as text and registered as a char field if missing",
+								Required: true,
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:  "dataset",
+				Usage: "Options to inspect and register datasets",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "show",
+						Usage: "Show catalog entry and statistics of a dataset",
+						Action: func(c *cli.Context) error {
+							err := core.Core(c, types.Show)
+							return err
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "dataset",
+								Aliases:  []string{"d"},
+								Usage:    "Dataset name",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "version",
+								Aliases:  []string{"v"},
+								Usage:    "Dataset version",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "quality",
+								Aliases:  []string{"q"},
+								Usage:    "Dataset quality",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "sqlConn",
+								Aliases:  []string{"s"},
+								Usage:    "PostGIS connection string",
+								Required: true,
+							},
+						},
+					},
+					{
+						Name:  "adopt",
+						Usage: "Register an existing PostGIS inventory table as a dataset without copying it",
+						Action: func(c *cli.Context) error {
+							err := core.Core(c, types.Adopt)
+							return err
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "sqlConn",
+								Aliases:  []string{"s"},
+								Usage:    "PostGIS connection string",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "table",
+								Aliases:  []string{"t"},
+								Usage:    "Existing inventory table, table or schema.table",
+								Required: true,
+							},
+							&cli.PathFlag{
+								Name:     "metaPath",
+								Aliases:  []string{"m"},
+								Usage:    "Path to metadata xlsx or yaml file",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:    "fieldConflict",
+								Aliases: []string{"f"},
+								Usage:   "Policy when a field name exists in the registry under another type: fail / new",
+								Value:   "fail",
+							},
+							&cli.BoolFlag{
+								Name:  "cluster",
+								Usage: "Cluster inventory table on its spatial index after adopting",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:  "dem",
+				Usage: "Options to inspect and manage the National Map tile cache",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "List cached tiles with their extent, product, publication date and size",
+						Action: func(c *cli.Context) error {
+							err := core.Core(c, types.DemList)
+							return err
+						},
+						Flags: []cli.Flag{
+							&cli.PathFlag{
+								Name:  "demCache",
+								Usage: "Directory National Map tiles are downloaded to",
+								Value: global.NATIONAL_MAP_CACHE_BASEPATH,
+							},
+						},
+					},
+					{
+						Name:  "verify",
+						Usage: "Detect corrupt or truncated cached tiles",
+						Action: func(c *cli.Context) error {
+							err := core.Core(c, types.DemVerify)
+							return err
+						},
+						Flags: []cli.Flag{
+							&cli.PathFlag{
+								Name:  "demCache",
+								Usage: "Directory National Map tiles are downloaded to",
+								Value: global.NATIONAL_MAP_CACHE_BASEPATH,
+							},
+							&cli.BoolFlag{
+								Name:  "repair",
+								Usage: "Remove invalid tiles so that they are downloaded again",
+							},
+						},
+					},
+					{
+						Name:  "prune",
+						Usage: "Evict the least recently used tiles until the cache fits in --maxSize",
+						Action: func(c *cli.Context) error {
+							err := core.Core(c, types.DemPrune)
+							return err
+						},
+						Flags: []cli.Flag{
+							&cli.PathFlag{
+								Name:  "demCache",
+								Usage: "Directory National Map tiles are downloaded to",
+								Value: global.NATIONAL_MAP_CACHE_BASEPATH,
+							},
+							&cli.StringFlag{
+								Name:     "maxSize",
+								Aliases:  []string{"max-size"},
+								Usage:    "Cache size limit in bytes or with a K / M / G / T suffix, e.g. 20G",
+								Required: true,
+							},
+						},
+					},
+					{
+						Name:  "prefetch",
+						Usage: "Download every tile a dataset or a bounding box needs before an elevation run",
+						Action: func(c *cli.Context) error {
+							err := core.Core(c, types.DemPrefetch)
+							return err
+						},
+						Flags: []cli.Flag{
+							&cli.PathFlag{
+								Name:  "demCache",
+								Usage: "Directory National Map tiles are downloaded to",
+								Value: global.NATIONAL_MAP_CACHE_BASEPATH,
+							},
+							&cli.StringFlag{
+								Name:  "bbox",
+								Usage: "Bounding box minX,minY,maxX,maxY in geographic coordinates",
+							},
+							&cli.StringFlag{
+								Name:    "dataset",
+								Aliases: []string{"d"},
+								Usage:   "Dataset name, prefetches the tiles of its points without elevation",
+							},
+							&cli.StringFlag{
+								Name:    "version",
+								Aliases: []string{"v"},
+								Usage:   "Dataset version",
+							},
+							&cli.StringFlag{
+								Name:    "quality",
+								Aliases: []string{"q"},
+								Usage:   "Dataset quality",
+							},
+							&cli.StringFlag{
+								Name:    "sqlConn",
+								Aliases: []string{"s"},
+								Usage:   "PostGIS connection string, required with --dataset",
+							},
+							&cli.StringFlag{
+								Name:  "tnmUrl",
+								Usage: "Base url of the TNM Access API, e.g. a local stand-in server",
+								Value: global.NATIONAL_MAP_URL,
+							},
+							&cli.Float64Flag{
+								Name:  "tnmRate",
+								Usage: "TNM product queries per second across all workers, 0 for no limit",
+								Value: global.NATIONAL_MAP_REQUEST_RATE,
+							},
+							&cli.StringFlag{
+								Name:  "products",
+								Usage: "National Map products to sample, best first, each point is sampled from the first product with a value: 1m, 1/9, 1/3, 1",
+								Value: global.NATIONAL_MAP_PRODUCTS,
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:  "elevation",
+				Usage: "Options to review ground elevation",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "qa",
+						Usage: "Flag elevations that differ sharply from nearby structures or the DEM around them, written to elevation_review",
+						Action: func(c *cli.Context) error {
+							err := core.Core(c, types.ElevationQA)
+							return err
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "dataset",
+								Aliases:  []string{"d"},
+								Usage:    "Dataset name",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "version",
+								Aliases:  []string{"v"},
+								Usage:    "Dataset version",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "quality",
+								Aliases:  []string{"q"},
+								Usage:    "Dataset quality",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "sqlConn",
+								Aliases:  []string{"s"},
+								Usage:    "PostGIS connection string",
+								Required: true,
+							},
+							&cli.PathFlag{
+								Name:  "demSource",
+								Usage: "Directory of GeoTIFFs or a GDAL VRT to sample instead of the National Map",
+							},
+							&cli.StringFlag{
+								Name:  "sampling",
+								Usage: "Elevation sampling method: nearest / bilinear / bicubic",
+								Value: "nearest",
+							},
+							&cli.StringFlag{
+								Name:  "tnmUrl",
+								Usage: "Base url of the TNM Access API, e.g. a local stand-in server",
+								Value: global.NATIONAL_MAP_URL,
+							},
+							&cli.Float64Flag{
+								Name:  "tnmRate",
+								Usage: "TNM product queries per second across all workers, 0 for no limit",
+								Value: global.NATIONAL_MAP_REQUEST_RATE,
+							},
+							&cli.StringFlag{
+								Name:  "products",
+								Usage: "National Map products to sample, best first, each point is sampled from the first product with a value: 1m, 1/9, 1/3, 1",
+								Value: global.NATIONAL_MAP_PRODUCTS,
+							},
+							&cli.PathFlag{
+								Name:  "demCache",
+								Usage: "Directory National Map tiles are downloaded to",
+								Value: global.NATIONAL_MAP_CACHE_BASEPATH,
+							},
+							&cli.Float64Flag{
+								Name:  "radius",
+								Usage: "Meters structures count as neighbors within",
+								Value: global.ELEVATION_QA_RADIUS,
+							},
+							&cli.Float64Flag{
+								Name:  "threshold",
+								Usage: "Difference from the neighbors or the DEM flagged for review, in the unit of ground_elev",
+								Value: global.ELEVATION_QA_THRESHOLD,
+							},
+							&cli.StringFlag{
+								Name:  "units",
+								Usage: "Unit ground_elev is written in: meters or feet, recorded in the field registry",
+								Value: string(types.Meters),
+							},
+							&cli.PathFlag{
+								Name:  "geoid",
+								Usage: "Grid of offsets in meters from NAVD88 to --datum, sampled bilinear",
+							},
+							&cli.StringFlag{
+								Name:  "datum",
+								Usage: "Vertical datum ground_elev is written in, recorded in the field registry",
+								Value: global.NATIONAL_MAP_VERTICAL_DATUM,
+							},
+						},
+					},
+					{
+						Name:  "worker",
+						Usage: "Fill the empty elevation of every dataset in the catalog as a long running process, several workers can share a database",
+						Action: func(c *cli.Context) error {
+							err := core.Core(c, types.ElevationWorker)
+							return err
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "sqlConn",
+								Aliases:  []string{"s"},
+								Usage:    "PostGIS connection string",
+								Required: true,
+							},
+							&cli.DurationFlag{
+								Name:  "idle",
+								Usage: "Sleep once no dataset has empty elevation points, e.g. 30s or 5m",
+								Value: global.ELEVATION_WORKER_IDLE,
+							},
+							&cli.PathFlag{
+								Name:  "demSource",
+								Usage: "Directory of GeoTIFFs or a GDAL VRT to sample instead of the National Map",
+							},
+							&cli.StringFlag{
+								Name:  "sampling",
+								Usage: "Elevation sampling method: nearest / bilinear / bicubic",
+								Value: "nearest",
+							},
+							&cli.StringFlag{
+								Name:  "tnmUrl",
+								Usage: "Base url of the TNM Access API, e.g. a local stand-in server",
+								Value: global.NATIONAL_MAP_URL,
+							},
+							&cli.Float64Flag{
+								Name:  "tnmRate",
+								Usage: "TNM product queries per second across all workers, 0 for no limit",
+								Value: global.NATIONAL_MAP_REQUEST_RATE,
+							},
+							&cli.StringFlag{
+								Name:  "products",
+								Usage: "National Map products to sample, best first, each point is sampled from the first product with a value: 1m, 1/9, 1/3, 1",
+								Value: global.NATIONAL_MAP_PRODUCTS,
+							},
+							&cli.PathFlag{
+								Name:  "demCache",
+								Usage: "Directory National Map tiles are downloaded to",
+								Value: global.NATIONAL_MAP_CACHE_BASEPATH,
+							},
+							&cli.IntFlag{
+								Name:  "workers",
+								Usage: "Number of partitions of a dataset sampled concurrently",
+								Value: global.ELEVATION_NO_PARALLEL_ROUTINES,
+							},
+							&cli.StringFlag{
+								Name:  "partition",
+								Usage: "Split the empty points by DEM tile / fd_id range: tile / fdid",
+								Value: string(types.TilePartition),
+							},
+							&cli.IntFlag{
+								Name:  "retries",
+								Usage: "Failed batches retried before the run of a dataset is aborted",
+								Value: global.ELEVATION_RETRY_BUDGET,
+							},
+							&cli.StringFlag{
+								Name:  "coords",
+								Usage: "Sample at the x, y columns (xy) or at the shape geometry reprojected from its srid (shape), shape implies --partition fdid",
+								Value: string(types.XYCoordinates),
+							},
+							&cli.StringFlag{
+								Name:  "units",
+								Usage: "Unit ground_elev is written in: meters or feet, recorded in the field registry",
+								Value: string(types.Meters),
+							},
+							&cli.PathFlag{
+								Name:  "geoid",
+								Usage: "Grid of offsets in meters from NAVD88 to --datum, sampled bilinear",
+							},
+							&cli.StringFlag{
+								Name:  "datum",
+								Usage: "Vertical datum ground_elev is written in, recorded in the field registry",
+								Value: global.NATIONAL_MAP_VERTICAL_DATUM,
+							},
+						},
+					},
+					{
+						Name:  "assess",
+						Usage: "Report RMSE, bias and percentiles of the elevation sampled at surveyed benchmarks by product and region",
+						Action: func(c *cli.Context) error {
+							err := core.Core(c, types.ElevationAssess)
+							return err
+						},
+						Flags: []cli.Flag{
+							&cli.PathFlag{
+								Name:     "benchmarks",
+								Aliases:  []string{"b"},
+								Usage:    "csv with a header naming x, y (lon/lat) and elevation columns, optionally id and region",
+								Required: true,
+							},
+							&cli.PathFlag{
+								Name:    "output",
+								Aliases: []string{"o"},
+								Usage:   "csv the sample and difference at each benchmark are written to",
+							},
+							&cli.PathFlag{
+								Name:  "demSource",
+								Usage: "Directory of GeoTIFFs or a GDAL VRT to sample instead of the National Map",
+							},
+							&cli.StringFlag{
+								Name:  "sampling",
+								Usage: "Elevation sampling method: nearest / bilinear / bicubic",
+								Value: "nearest",
+							},
+							&cli.StringFlag{
+								Name:  "tnmUrl",
+								Usage: "Base url of the TNM Access API, e.g. a local stand-in server",
+								Value: global.NATIONAL_MAP_URL,
+							},
+							&cli.Float64Flag{
+								Name:  "tnmRate",
+								Usage: "TNM product queries per second across all workers, 0 for no limit",
+								Value: global.NATIONAL_MAP_REQUEST_RATE,
+							},
+							&cli.StringFlag{
+								Name:  "products",
+								Usage: "National Map products to sample, best first, each point is sampled from the first product with a value: 1m, 1/9, 1/3, 1",
+								Value: global.NATIONAL_MAP_PRODUCTS,
+							},
+							&cli.PathFlag{
+								Name:  "demCache",
+								Usage: "Directory National Map tiles are downloaded to",
+								Value: global.NATIONAL_MAP_CACHE_BASEPATH,
+							},
+							&cli.StringFlag{
+								Name:  "units",
+								Usage: "Unit benchmark elevations are in: meters or feet",
+								Value: string(types.Meters),
+							},
+							&cli.PathFlag{
+								Name:  "geoid",
+								Usage: "Grid of offsets in meters from NAVD88 to --datum, sampled bilinear",
+							},
+							&cli.StringFlag{
+								Name:  "datum",
+								Usage: "Vertical datum benchmark elevations are in",
+								Value: global.NATIONAL_MAP_VERTICAL_DATUM,
+							},
 						},
 					},
 				},
@@ -1,6 +1,9 @@
 drop table domain;
 drop table schema_field;
 drop table field;
+drop table dataset_stats;
+drop table elevation_run;
+drop table elevation_review;
 drop table dataset;
 drop table nsi_schema;
 drop table quality;
@@ -0,0 +1,62 @@
+-- brings a database created from an earlier schema_v2.sql up to date, safe to rerun
+alter table field
+    add column if not exists unit text,
+    add column if not exists vertical_datum text;
+
+-- summary of each dataset inventory table, maintained incrementally after every load
+create table if not exists dataset_stats (
+    dataset_id uuid not null primary key,
+    row_count bigint not null default 0,
+    min_x double precision,
+    min_y double precision,
+    max_x double precision,
+    max_y double precision,
+    srid integer,
+    state_counts jsonb not null default '{}',
+    county_counts jsonb not null default '{}',
+    null_counts jsonb not null default '{}',
+    last_load timestamp not null default now(),
+    constraint fk_dataset_stats_dataset
+        foreign key(dataset_id)
+            references dataset(id)
+);
+
+-- one record per mod elevation run of a dataset
+create table if not exists elevation_run (
+    id uuid not null default gen_random_uuid() primary key,
+    dataset_id uuid not null,
+    started timestamp not null,
+    ended timestamp,
+    source text not null,
+    product text not null,
+    sampling text not null,
+    filled bigint not null default 0,
+    failed bigint not null default 0,
+    mismatched bigint not null default 0,
+    error text,
+    constraint fk_elevation_run_dataset
+        foreign key(dataset_id)
+            references dataset(id)
+);
+
+-- elevation_run tables created before the mismatched count
+alter table elevation_run
+    add column if not exists mismatched bigint not null default 0;
+
+-- ground elevations flagged by elevation qa, replaced for a dataset on every qa run
+create table if not exists elevation_review (
+    dataset_id uuid not null,
+    fd_id integer not null,
+    x double precision not null,
+    y double precision not null,
+    ground_elev double precision not null,
+    check_type text not null,
+    expected double precision not null,
+    difference double precision not null,
+    samples integer not null,
+    created timestamp not null,
+    primary key (dataset_id, fd_id, check_type),
+    constraint fk_elevation_review_dataset
+        foreign key(dataset_id)
+            references dataset(id)
+);